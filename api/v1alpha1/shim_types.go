@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -26,6 +27,82 @@ type ShimSpec struct {
 	FetchStrategy   FetchStrategy     `json:"fetchStrategy"`
 	RuntimeClass    RuntimeClassSpec  `json:"runtimeClass"`
 	RolloutStrategy RolloutStrategy   `json:"rolloutStrategy"`
+	// +kubebuilder:default=immediate
+	RestartPolicy RestartPolicyType `json:"restartPolicy,omitempty"`
+	// Preflight runs the installation in dry-run mode on every node before
+	// the shim is installed. The changes it would make are reported in
+	// status.preflight, and the shim is only installed on nodes where the
+	// dry run succeeded.
+	// +optional
+	Preflight bool `json:"preflight,omitempty"`
+	// DriftDetection periodically verifies the shim on provisioned nodes.
+	// +optional
+	DriftDetection DriftDetectionSpec `json:"driftDetection,omitempty"`
+	// PinnedVersion is the sha256, or a prefix of it, of a previously
+	// installed version of the shim. Nodes roll back to this version on the
+	// next install instead of downloading the shim. The version must still
+	// be kept on the node.
+	// +optional
+	PinnedVersion string `json:"pinnedVersion,omitempty"`
+	// JobTemplate customizes the pods of the node-installer Jobs of the
+	// shim. It is merged into the job template of the controller.
+	// +optional
+	JobTemplate *JobTemplateSpec `json:"jobTemplate,omitempty"`
+	// JobHistoryLimits limits the finished node-installer Jobs kept for the
+	// shim. All finished Jobs are kept if not set.
+	// +optional
+	JobHistoryLimits *JobHistoryLimits `json:"jobHistoryLimits,omitempty"`
+}
+
+// JobHistoryLimits is the number of finished Jobs kept per outcome for every
+// node and operation. The most recently finished Jobs are kept.
+type JobHistoryLimits struct {
+	// Successful is the number of successful Jobs kept. All are kept if not
+	// set.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Successful *int32 `json:"successful,omitempty"`
+	// Failed is the number of failed Jobs kept. All are kept if not set.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Failed *int32 `json:"failed,omitempty"`
+}
+
+// JobTemplateSpec customizes the pods of node-installer Jobs.
+type JobTemplateSpec struct {
+	// Labels are added to the pods. They do not override the labels the
+	// controller relies on.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations are added to the pods.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Tolerations of the pods, e.g. to run on tainted node pools.
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// Resources of the containers of the pods.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// ImagePullSecrets to pull the downloader and node-installer images.
+	// +optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// PriorityClassName of the pods.
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// ServiceAccountName the pods run as.
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+}
+
+// DriftDetectionSpec configures the verification of provisioned nodes.
+type DriftDetectionSpec struct {
+	// Interval in which provisioned nodes are verified against the lock file
+	// of node-installer. Drift detection is disabled if not set.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// Remediate reinstalls the shim on nodes where drift has been detected.
+	// +optional
+	Remediate bool `json:"remediate,omitempty"`
 }
 
 type FetchStrategy struct {
@@ -59,12 +136,111 @@ type RollingSpec struct {
 	MaxUpdate int `json:"maxUpdate"`
 }
 
+// +kubebuilder:validation:Enum=immediate;deferred;drain
+type RestartPolicyType string
+
+const (
+	// RestartPolicyImmediate restarts containerd as soon as the shim is installed.
+	RestartPolicyImmediate RestartPolicyType = "immediate"
+	// RestartPolicyDeferred installs the shim without restarting containerd.
+	// The node is marked as pending-restart until containerd is restarted
+	// in the next maintenance window.
+	RestartPolicyDeferred RestartPolicyType = "deferred"
+	// RestartPolicyDrain cordons and drains the node before the shim is
+	// installed and containerd is restarted, and uncordons it afterwards.
+	RestartPolicyDrain RestartPolicyType = "drain"
+)
+
 // ShimStatus defines the observed state of Shim
 // +operator-sdk:csv:customresourcedefinitions:type=status
 type ShimStatus struct {
 	Conditions     []metav1.Condition `json:"conditions,omitempty"`
 	NodeCount      int                `json:"nodes"`
 	NodeReadyCount int                `json:"nodesReady"`
+	// Preflight holds the results of the dry runs on the nodes.
+	// +listType=map
+	// +listMapKey=node
+	// +optional
+	Preflight []NodePreflight `json:"preflight,omitempty"`
+	// Verifications holds the results of the last drift detection on the
+	// nodes.
+	// +listType=map
+	// +listMapKey=node
+	// +optional
+	Verifications []NodeVerification `json:"verifications,omitempty"`
+	// Failures holds the reasons of the last failed install or uninstall
+	// on the nodes. A failure is removed once the operation succeeds on the
+	// node.
+	// +listType=map
+	// +listMapKey=node
+	// +optional
+	Failures []NodeFailure `json:"failures,omitempty"`
+}
+
+// NodeFailure is the reason an install or uninstall failed on a node, as
+// reported by node-installer.
+type NodeFailure struct {
+	Node string `json:"node"`
+	// Operation is the failed operation, install or uninstall.
+	Operation string `json:"operation"`
+	// Class classifies the failure, e.g. DistroDetection, LockTimeout or
+	// RuntimeRestart. It is Unknown if the job did not report a failure.
+	Class string `json:"class"`
+	// Message is the error the operation failed with.
+	Message string `json:"message"`
+	// Distro is the distro detected on the node.
+	// +optional
+	Distro string `json:"distro,omitempty"`
+	// ConfigPath is the path of the containerd config on the node.
+	// +optional
+	ConfigPath string `json:"configPath,omitempty"`
+	// LastFailureTime is the time the job failed.
+	LastFailureTime metav1.Time `json:"lastFailureTime"`
+}
+
+// NodeVerification is the result of a drift detection on a node.
+type NodeVerification struct {
+	Node string `json:"node"`
+	// Drift lists how the node differs from the installed shim, e.g.
+	// binary-missing, binary-modified or config-missing. It is empty if the
+	// node is in sync.
+	// +optional
+	Drift []string `json:"drift,omitempty"`
+	// Error is set if the verification failed.
+	// +optional
+	Error string `json:"error,omitempty"`
+	// LastVerifyTime is the time the node has been verified.
+	LastVerifyTime metav1.Time `json:"lastVerifyTime"`
+}
+
+// NodePreflight is the result of a dry run of the installation on a node.
+type NodePreflight struct {
+	Node string `json:"node"`
+	// ObservedGeneration is the generation of the Shim the dry run has been
+	// run for.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Shims lists the changes to the shims installed on the node.
+	// +optional
+	Shims []ShimChange `json:"shims,omitempty"`
+	// ConfigDiff is the unified diff of the runtime config.
+	// +optional
+	ConfigDiff string `json:"configDiff,omitempty"`
+	// RestartRequired is set if the runtime would be restarted.
+	// +optional
+	RestartRequired bool `json:"restartRequired,omitempty"`
+	// Error is set if the dry run failed.
+	// +optional
+	Error string `json:"error,omitempty"`
+	// LastRunTime is the time the result has been reported.
+	// +optional
+	LastRunTime metav1.Time `json:"lastRunTime,omitempty"`
+}
+
+// ShimChange describes the change to a shim binary on a node.
+type ShimChange struct {
+	Name string `json:"name"`
+	// Action is one of install, update or remove.
+	Action string `json:"action"`
 }
 
 // +kubebuilder:object:root=true
@@ -21,7 +21,8 @@ limitations under the License.
 package v1alpha1
 
 import (
-	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -40,6 +41,26 @@ func (in *AnonHTTPSpec) DeepCopy() *AnonHTTPSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DriftDetectionSpec) DeepCopyInto(out *DriftDetectionSpec) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DriftDetectionSpec.
+func (in *DriftDetectionSpec) DeepCopy() *DriftDetectionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DriftDetectionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FetchStrategy) DeepCopyInto(out *FetchStrategy) {
 	*out = *in
@@ -56,6 +77,135 @@ func (in *FetchStrategy) DeepCopy() *FetchStrategy {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *JobHistoryLimits) DeepCopyInto(out *JobHistoryLimits) {
+	*out = *in
+	if in.Successful != nil {
+		in, out := &in.Successful, &out.Successful
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Failed != nil {
+		in, out := &in.Failed, &out.Failed
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new JobHistoryLimits.
+func (in *JobHistoryLimits) DeepCopy() *JobHistoryLimits {
+	if in == nil {
+		return nil
+	}
+	out := new(JobHistoryLimits)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *JobTemplateSpec) DeepCopyInto(out *JobTemplateSpec) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]v1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(v1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]v1.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new JobTemplateSpec.
+func (in *JobTemplateSpec) DeepCopy() *JobTemplateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(JobTemplateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeFailure) DeepCopyInto(out *NodeFailure) {
+	*out = *in
+	in.LastFailureTime.DeepCopyInto(&out.LastFailureTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeFailure.
+func (in *NodeFailure) DeepCopy() *NodeFailure {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeFailure)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodePreflight) DeepCopyInto(out *NodePreflight) {
+	*out = *in
+	if in.Shims != nil {
+		in, out := &in.Shims, &out.Shims
+		*out = make([]ShimChange, len(*in))
+		copy(*out, *in)
+	}
+	in.LastRunTime.DeepCopyInto(&out.LastRunTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodePreflight.
+func (in *NodePreflight) DeepCopy() *NodePreflight {
+	if in == nil {
+		return nil
+	}
+	out := new(NodePreflight)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeVerification) DeepCopyInto(out *NodeVerification) {
+	*out = *in
+	if in.Drift != nil {
+		in, out := &in.Drift, &out.Drift
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.LastVerifyTime.DeepCopyInto(&out.LastVerifyTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeVerification.
+func (in *NodeVerification) DeepCopy() *NodeVerification {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeVerification)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RollingSpec) DeepCopyInto(out *RollingSpec) {
 	*out = *in
@@ -129,6 +279,21 @@ func (in *Shim) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ShimChange) DeepCopyInto(out *ShimChange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ShimChange.
+func (in *ShimChange) DeepCopy() *ShimChange {
+	if in == nil {
+		return nil
+	}
+	out := new(ShimChange)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ShimList) DeepCopyInto(out *ShimList) {
 	*out = *in
@@ -174,6 +339,17 @@ func (in *ShimSpec) DeepCopyInto(out *ShimSpec) {
 	out.FetchStrategy = in.FetchStrategy
 	out.RuntimeClass = in.RuntimeClass
 	out.RolloutStrategy = in.RolloutStrategy
+	in.DriftDetection.DeepCopyInto(&out.DriftDetection)
+	if in.JobTemplate != nil {
+		in, out := &in.JobTemplate, &out.JobTemplate
+		*out = new(JobTemplateSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.JobHistoryLimits != nil {
+		in, out := &in.JobHistoryLimits, &out.JobHistoryLimits
+		*out = new(JobHistoryLimits)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ShimSpec.
@@ -191,7 +367,28 @@ func (in *ShimStatus) DeepCopyInto(out *ShimStatus) {
 	*out = *in
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
-		*out = make([]v1.Condition, len(*in))
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Preflight != nil {
+		in, out := &in.Preflight, &out.Preflight
+		*out = make([]NodePreflight, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Verifications != nil {
+		in, out := &in.Verifications, &out.Verifications
+		*out = make([]NodeVerification, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Failures != nil {
+		in, out := &in.Failures, &out.Failures
+		*out = make([]NodeFailure, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
@@ -0,0 +1,532 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+	"github.com/spinkube/runtime-class-manager/internal/containerd"
+	"github.com/spinkube/runtime-class-manager/internal/logging"
+	"github.com/spinkube/runtime-class-manager/internal/preset"
+	"github.com/spinkube/runtime-class-manager/internal/state"
+	"github.com/spinkube/runtime-class-manager/internal/tracing"
+)
+
+// Provisioning states of a shim on the node. They match the node labels set
+// by the controller in job mode.
+const (
+	AgentStatusPending        = "pending"
+	AgentStatusProvisioned    = "provisioned"
+	AgentStatusPendingRestart = "pending-restart"
+	AgentStatusFailed         = "failed"
+)
+
+const (
+	// AgentLeaseLabel marks the leases node agents report their status in.
+	AgentLeaseLabel = "kwasm.sh/agent"
+	// shimAnnotationSuffix is appended to the shim name to build the
+	// annotation keys of the per shim status in the lease.
+	shimAnnotationSuffix = ".shims.kwasm.sh/"
+)
+
+var ErrNodeNameMissing = errors.New("node name missing, set --node-name or KWASM_NODE_NAME")
+
+// agentCmd represents the agent command.
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Run as node agent, installing the shims selected for this node",
+	Run: func(cmd *cobra.Command, _ []string) {
+		if config.Agent.NodeName == "" {
+			slog.Error("invalid agent config", "error", ErrNodeNameMissing)
+			os.Exit(1)
+		}
+
+		rootFs := afero.NewOsFs()
+		hostFs := afero.NewBasePathFs(rootFs, config.Host.RootPath)
+
+		distro, err := DetectDistro(cmd.Context(), config, hostFs)
+		if err != nil {
+			slog.Error("failed to detect containerd config", "error", err)
+			os.Exit(1)
+		}
+
+		restarter, err := SelectRestarter(config, distro)
+		if err != nil {
+			slog.Error("failed to select restarter", "error", err)
+			os.Exit(1)
+		}
+
+		config.Runtime.ConfigPath = distro.ConfigPath
+		if config.Runtime.SocketPath == "" {
+			config.Runtime.SocketPath = distro.SocketPath
+		}
+		// The result is reported in the lease, not through the termination log.
+		config.Kwasm.TerminationLogPath = ""
+
+		if err = distro.Setup(preset.Env{ConfigPath: distro.ConfigPath, HostFs: hostFs}); err != nil {
+			slog.Error("failed to run distro setup", "error", err)
+			os.Exit(1)
+		}
+
+		if err := RunAgent(ctrl.SetupSignalHandler(), config, rootFs, hostFs, restarter); err != nil {
+			slog.Error("agent failed", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	agentCmd.Flags().StringVar(&config.Agent.NodeName, "node-name", "", "Name of the node the agent runs on")
+	agentCmd.Flags().StringVar(&config.Agent.Namespace, "namespace", "default", "Namespace of the lease the agent reports its status in")
+	agentCmd.Flags().DurationVar(&config.Agent.HeartbeatInterval, "heartbeat-interval", 30*time.Second, "Interval in which the agent renews its lease") //nolint:mnd // default interval
+	rootCmd.AddCommand(agentCmd)
+}
+
+// RunAgent runs the node agent until ctx is done.
+func RunAgent(ctx context.Context, config Config, rootFs, hostFs afero.Fs, restarter containerd.Restarter) error {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(rcmv1.AddToScheme(scheme))
+
+	ctrl.SetLogger(logr.FromSlogHandler(slog.Default().Handler()))
+
+	restConfig, err := ctrl.GetConfig()
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig: %w", err)
+	}
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
+		Scheme:  scheme,
+		Metrics: metricsserver.Options{BindAddress: "0"},
+		// Only the own node and lease are of interest to the agent.
+		Cache: cache.Options{
+			ByObject: map[client.Object]cache.ByObject{
+				&corev1.Node{}:          {Field: fields.OneTermEqualSelector("metadata.name", config.Agent.NodeName)},
+				&coordinationv1.Lease{}: {Namespaces: map[string]cache.Config{config.Agent.Namespace: {}}},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create manager: %w", err)
+	}
+
+	agent := &Agent{
+		Client:     mgr.GetClient(),
+		Config:     config,
+		RootFs:     rootFs,
+		HostFs:     hostFs,
+		Restarter:  restarter,
+		Verifier:   containerd.NewCRIVerifier(path.Join(config.Host.RootPath, config.Runtime.SocketPath), restartCheckTimeout),
+		HTTPClient: http.DefaultClient,
+	}
+	if err := agent.SetupWithManager(mgr); err != nil {
+		return err
+	}
+
+	slog.Info("starting agent")
+	return mgr.Start(ctx)
+}
+
+// Agent installs and uninstalls the shims selected for its node. It is the
+// node local counterpart of the install and uninstall jobs the controller
+// deploys in job mode.
+type Agent struct {
+	client.Client
+	Config     Config
+	RootFs     afero.Fs
+	HostFs     afero.Fs
+	Restarter  containerd.Restarter
+	HTTPClient *http.Client
+	// Verifier detects restarts of containerd outside of the agent, which
+	// complete installs with a pending restart.
+	Verifier containerd.Verifier
+}
+
+// SetupWithManager sets up the agent with the Manager. Besides Shims, the
+// node of the agent is watched, so that label changes that select or
+// deselect shims are picked up. Failed installations are retried with the
+// backoff of the controller.
+func (a *Agent) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.Add(manager.RunnableFunc(a.heartbeat)); err != nil {
+		return fmt.Errorf("failed to add heartbeat: %w", err)
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("agent").
+		// Shims are updated by the controller on every reconcile, only spec
+		// changes and deletions are relevant.
+		For(&rcmv1.Shim{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(
+			&corev1.Node{},
+			handler.EnqueueRequestsFromMapFunc(a.allShims),
+			builder.WithPredicates(
+				predicate.NewPredicateFuncs(func(obj client.Object) bool {
+					return obj.GetName() == a.Config.Agent.NodeName
+				}),
+				predicate.Funcs{UpdateFunc: selectionChanged},
+			),
+		).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
+		Complete(a)
+}
+
+// Reconcile installs the shim if it is selected for the node and not yet
+// installed in its current generation, and uninstalls it if it has been
+// deleted or is no longer selected.
+func (a *Agent) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ctx, span := tracing.Start(ctx, "Agent.Reconcile", attribute.String("shim", req.Name), attribute.String("node", a.Config.Agent.NodeName))
+	result, err := a.reconcile(ctx, req)
+	tracing.End(span, err)
+	return result, err
+}
+
+// reconcile installs or uninstalls the shim on the node of the agent.
+func (a *Agent) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	shim := &rcmv1.Shim{}
+	if err := a.Get(ctx, req.NamespacedName, shim); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	node := &corev1.Node{}
+	if err := a.Get(ctx, types.NamespacedName{Name: a.Config.Agent.NodeName}, node); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to fetch node: %w", err)
+	}
+
+	status, installed := node.Labels[shim.Name]
+	selected := labels.SelectorFromSet(shim.Spec.NodeSelector).Matches(labels.Set(node.Labels))
+
+	switch {
+	case !shim.DeletionTimestamp.IsZero() || !selected:
+		if !installed {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, a.uninstall(ctx, shim, node)
+	case status == AgentStatusPendingRestart:
+		generation, err := a.installedGeneration(ctx, shim.Name)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if generation != shim.Generation {
+			return ctrl.Result{}, a.install(ctx, shim, node)
+		}
+		return a.checkRestart(ctx, shim)
+	case status != AgentStatusFailed && status != AgentStatusPending && installed:
+		generation, err := a.installedGeneration(ctx, shim.Name)
+		if err != nil || generation == shim.Generation {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, a.install(ctx, shim, node)
+	default:
+		return ctrl.Result{}, a.install(ctx, shim, node)
+	}
+}
+
+func (a *Agent) install(ctx context.Context, shim *rcmv1.Shim, node *corev1.Node) error {
+	log := slog.With(logging.KeyShim, shim.Name, logging.KeyOperation, "install")
+	log.Info("installing shim", "generation", shim.Generation)
+
+	if err := a.setStatus(ctx, shim, AgentStatusPending, 0); err != nil {
+		return err
+	}
+
+	installErr := func() error {
+		config := a.Config
+		config.Runtime.Name = shim.Name
+		config.Runtime.RestartPolicy = agentRestartPolicy(shim.Spec.RestartPolicy)
+
+		// A pinned version is already on the node, nothing is downloaded.
+		if shim.Spec.PinnedVersion != "" {
+			config.Version = shim.Spec.PinnedVersion
+			return RunInstall(ctx, config, a.RootFs, a.HostFs, a.Restarter)
+		}
+
+		dir, err := afero.TempDir(a.RootFs, "", "kwasm-")
+		if err != nil {
+			return err
+		}
+		defer func() { _ = a.RootFs.RemoveAll(dir) }()
+
+		assetPath, err := DownloadShim(ctx, a.RootFs, a.HTTPClient, shim.Spec.FetchStrategy.AnonHTTP.Location, shim.Name, dir)
+		if err != nil {
+			return err
+		}
+
+		config.Kwasm.AssetPath, err = bundleAssetPath(a.RootFs, dir, assetPath)
+		if err != nil {
+			return err
+		}
+		config.Source = state.Source{
+			URL:            shim.Spec.FetchStrategy.AnonHTTP.Location,
+			FetchStrategy:  shim.Spec.FetchStrategy.Type,
+			ShimUID:        string(shim.UID),
+			ShimGeneration: shim.Generation,
+		}
+		return RunInstall(ctx, config, a.RootFs, a.HostFs, a.Restarter)
+	}()
+	if installErr != nil {
+		log.Error("failed to install shim", "error", installErr)
+		return errors.Join(installErr, a.setStatus(ctx, shim, AgentStatusFailed, 0))
+	}
+
+	status := AgentStatusProvisioned
+	pending, err := isRestartPending(a.HostFs, a.Config.Kwasm.Path)
+	if err != nil {
+		return err
+	}
+	if pending {
+		status = AgentStatusPendingRestart
+	}
+	log.Info("shim installed", "status", status)
+	return a.setStatus(ctx, shim, status, shim.Generation)
+}
+
+// checkRestart labels the node as provisioned once containerd has been
+// restarted after the install of the shim. While the restart is still
+// pending, the node is checked again after restartCheckInterval.
+func (a *Agent) checkRestart(ctx context.Context, shim *rcmv1.Shim) (ctrl.Result, error) {
+	pending, err := isRestartPending(a.HostFs, a.Config.Kwasm.Path)
+	if err == nil && pending && a.Verifier != nil {
+		pending, err = CheckPendingRestart(a.Config, a.HostFs, a.Verifier)
+	}
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to check for pending restart: %w", err)
+	}
+	if pending {
+		return ctrl.Result{RequeueAfter: restartCheckInterval}, nil
+	}
+
+	slog.Info("containerd has been restarted", logging.KeyShim, shim.Name, "status", AgentStatusProvisioned)
+	return ctrl.Result{}, a.setStatus(ctx, shim, AgentStatusProvisioned, shim.Generation)
+}
+
+func (a *Agent) uninstall(ctx context.Context, shim *rcmv1.Shim, node *corev1.Node) error {
+	log := slog.With(logging.KeyShim, shim.Name, logging.KeyOperation, "uninstall")
+	log.Info("uninstalling shim")
+
+	config := a.Config
+	config.Runtime.Name = shim.Name
+	if err := RunUninstall(ctx, config, a.RootFs, a.HostFs, a.Restarter); err != nil {
+		log.Error("failed to uninstall shim", "error", err)
+		return errors.Join(err, a.setStatus(ctx, shim, AgentStatusFailed, 0))
+	}
+
+	log.Info("shim uninstalled")
+	return a.setStatus(ctx, shim, "", 0)
+}
+
+// agentRestartPolicy returns the restart policy the agent applies. The agent
+// cannot drain its own node, so it restarts immediately instead.
+func agentRestartPolicy(policy rcmv1.RestartPolicyType) string {
+	if policy == rcmv1.RestartPolicyDeferred {
+		return RestartPolicyDeferred
+	}
+	return RestartPolicyImmediate
+}
+
+// setStatus reports the status of the shim on the node in the node label
+// the controller counts ready nodes by and in the lease of the agent. An
+// empty status removes the shim from both. The generation is only recorded
+// for installed shims.
+func (a *Agent) setStatus(ctx context.Context, shim *rcmv1.Shim, status string, generation int64) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node := &corev1.Node{}
+		if err := a.Get(ctx, types.NamespacedName{Name: a.Config.Agent.NodeName}, node); err != nil {
+			return err
+		}
+		if status == "" {
+			delete(node.Labels, shim.Name)
+		} else {
+			if node.Labels == nil {
+				node.Labels = map[string]string{}
+			}
+			node.Labels[shim.Name] = status
+		}
+		return a.Update(ctx, node)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update node label: %w", err)
+	}
+
+	return a.updateLease(ctx, func(lease *coordinationv1.Lease) {
+		statusKey := shim.Name + shimAnnotationSuffix + "status"
+		generationKey := shim.Name + shimAnnotationSuffix + "generation"
+		if status == "" {
+			delete(lease.Annotations, statusKey)
+			delete(lease.Annotations, generationKey)
+			return
+		}
+		lease.Annotations[statusKey] = status
+		if generation > 0 {
+			lease.Annotations[generationKey] = strconv.FormatInt(generation, 10)
+		} else {
+			delete(lease.Annotations, generationKey)
+		}
+	})
+}
+
+// installedGeneration returns the generation of the shim the agent installed
+// last, or 0 if it is unknown.
+func (a *Agent) installedGeneration(ctx context.Context, shimName string) (int64, error) {
+	lease := &coordinationv1.Lease{}
+	err := a.Get(ctx, types.NamespacedName{Namespace: a.Config.Agent.Namespace, Name: a.Config.Agent.NodeName}, lease)
+	if apierrors.IsNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch lease: %w", err)
+	}
+
+	generation, err := strconv.ParseInt(lease.Annotations[shimName+shimAnnotationSuffix+"generation"], 10, 64)
+	if err != nil {
+		return 0, nil //nolint:nilerr // a missing or invalid generation means unknown
+	}
+	return generation, nil
+}
+
+// heartbeat renews the lease of the agent until ctx is done.
+func (a *Agent) heartbeat(ctx context.Context) error {
+	ticker := time.NewTicker(a.Config.Agent.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.updateLease(ctx, func(*coordinationv1.Lease) {}); err != nil {
+			slog.Warn("failed to renew lease", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// updateLease applies update to the lease of the agent and renews it. The
+// lease is named after the node and created if it does not exist.
+func (a *Agent) updateLease(ctx context.Context, update func(lease *coordinationv1.Lease)) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		lease := &coordinationv1.Lease{}
+		err := a.Get(ctx, types.NamespacedName{Namespace: a.Config.Agent.Namespace, Name: a.Config.Agent.NodeName}, lease)
+		create := apierrors.IsNotFound(err)
+		if err != nil && !create {
+			return err
+		}
+		if create {
+			lease.ObjectMeta = metav1.ObjectMeta{
+				Namespace: a.Config.Agent.Namespace,
+				Name:      a.Config.Agent.NodeName,
+				Labels:    map[string]string{AgentLeaseLabel: "true"},
+			}
+		}
+		if lease.Annotations == nil {
+			lease.Annotations = map[string]string{}
+		}
+
+		update(lease)
+		lease.Spec.HolderIdentity = &a.Config.Agent.NodeName
+		lease.Spec.LeaseDurationSeconds = ptr(int32(3 * a.Config.Agent.HeartbeatInterval / time.Second)) //nolint:mnd // lease expires after 3 missed heartbeats
+		lease.Spec.RenewTime = &metav1.MicroTime{Time: time.Now()}
+
+		if create {
+			return a.Create(ctx, lease)
+		}
+		return a.Update(ctx, lease)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update lease: %w", err)
+	}
+	return nil
+}
+
+// selectionChanged ignores the label changes the agent makes to report the
+// status of shims, so that only changes that may select or deselect shims
+// trigger a reconcile.
+func selectionChanged(e event.UpdateEvent) bool {
+	oldLabels, newLabels := e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels()
+	for key, value := range newLabels {
+		if oldLabels[key] != value && !isAgentStatus(oldLabels[key]) && !isAgentStatus(value) {
+			return true
+		}
+	}
+	for key, value := range oldLabels {
+		if _, ok := newLabels[key]; !ok && !isAgentStatus(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func isAgentStatus(value string) bool {
+	switch value {
+	case AgentStatusPending, AgentStatusProvisioned, AgentStatusPendingRestart, AgentStatusFailed:
+		return true
+	}
+	return false
+}
+
+// allShims enqueues all Shims, as any of them may be selected or deselected
+// by a label change of the node.
+func (a *Agent) allShims(ctx context.Context, _ client.Object) []reconcile.Request {
+	shims := &rcmv1.ShimList{}
+	if err := a.List(ctx, shims); err != nil {
+		slog.Error("failed to list shims", "error", err)
+		return nil
+	}
+
+	requests := make([]reconcile.Request, len(shims.Items))
+	for i, shim := range shims.Items {
+		requests[i] = reconcile.Request{NamespacedName: types.NamespacedName{Name: shim.Name}}
+	}
+	return requests
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,235 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	main "github.com/spinkube/runtime-class-manager/cmd/node-installer"
+	"github.com/spinkube/runtime-class-manager/internal/state"
+	tests "github.com/spinkube/runtime-class-manager/tests/node-installer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+)
+
+// shimArchive returns a tar.gz archive with the given files.
+func shimArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o755, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func Test_DownloadShim(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr error
+	}{
+		{"shim in archive", map[string]string{"README.md": "readme", "containerd-shim-spin-v2": "shim"}, nil},
+		{"no shim in archive", map[string]string{"README.md": "readme"}, main.ErrShimNotInArchive},
+		{"bundle", map[string]string{"kwasm-manifest.json": "{}", "containerd-shim-spin-v2": "shim", "lib/helper": "helper"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := shimArchive(t, tt.files)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write(archive)
+			}))
+			defer server.Close()
+
+			fs := afero.NewMemMapFs()
+			binPath, err := main.DownloadShim(context.Background(), fs, server.Client(), server.URL, "my-spin", "/assets")
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "/assets/containerd-shim-my-spin", binPath)
+			data, err := afero.ReadFile(fs, binPath)
+			require.NoError(t, err)
+			assert.Equal(t, "shim", string(data))
+		})
+	}
+}
+
+func Test_AgentReconcile(t *testing.T) {
+	archive := shimArchive(t, map[string]string{"containerd-shim-spin-v2": "shim"})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(archive)
+	}))
+	defer server.Close()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, rcmv1.AddToScheme(scheme))
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1", Labels: map[string]string{"wasm": "true"}}}
+	shim := &rcmv1.Shim{
+		ObjectMeta: metav1.ObjectMeta{Name: "spin", Generation: 1, Finalizers: []string{"rcm.spinkube.dev/finalizer"}},
+		Spec: rcmv1.ShimSpec{
+			NodeSelector:  map[string]string{"wasm": "true"},
+			FetchStrategy: rcmv1.FetchStrategy{Type: "anonHttp", AnonHTTP: rcmv1.AnonHTTPSpec{Location: server.URL}},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node, shim).Build()
+
+	config := testConfig("/etc/containerd/config.toml", "")
+	config.Agent.NodeName = "worker-1"
+	config.Agent.Namespace = "rcm"
+	config.Agent.HeartbeatInterval = 30 * time.Second
+	hostFs := tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-shim-config")
+	restarter := &countingRestarter{}
+	agent := &main.Agent{
+		Client:     c,
+		Config:     config,
+		RootFs:     afero.NewMemMapFs(),
+		HostFs:     hostFs,
+		Restarter:  restarter,
+		HTTPClient: server.Client(),
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "spin"}}
+
+	t.Run("install", func(t *testing.T) {
+		_, err := agent.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+
+		require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(node), node))
+		assert.Equal(t, main.AgentStatusProvisioned, node.Labels["spin"])
+
+		lease := &coordinationv1.Lease{}
+		require.NoError(t, c.Get(context.Background(), types.NamespacedName{Namespace: "rcm", Name: "worker-1"}, lease))
+		assert.Equal(t, main.AgentStatusProvisioned, lease.Annotations["spin.shims.kwasm.sh/status"])
+		assert.Equal(t, "1", lease.Annotations["spin.shims.kwasm.sh/generation"])
+		assert.Equal(t, "worker-1", *lease.Spec.HolderIdentity)
+
+		st, err := state.Get(hostFs, "/opt/kwasm")
+		require.NoError(t, err)
+		assert.Contains(t, st.Shims, "spin")
+		assert.Equal(t, 1, restarter.calls)
+	})
+
+	t.Run("up to date", func(t *testing.T) {
+		_, err := agent.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, 1, restarter.calls)
+	})
+
+	t.Run("uninstall when deselected", func(t *testing.T) {
+		require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(node), node))
+		node.Labels["wasm"] = "false"
+		require.NoError(t, c.Update(context.Background(), node))
+
+		_, err := agent.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+
+		require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(node), node))
+		assert.NotContains(t, node.Labels, "spin")
+
+		st, err := state.Get(hostFs, "/opt/kwasm")
+		require.NoError(t, err)
+		assert.NotContains(t, st.Shims, "spin")
+	})
+}
+
+func Test_AgentReconcilePendingRestart(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, rcmv1.AddToScheme(scheme))
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1", Labels: map[string]string{"wasm": "true", "spin": main.AgentStatusPendingRestart}}}
+	shim := &rcmv1.Shim{
+		ObjectMeta: metav1.ObjectMeta{Name: "spin", Generation: 1},
+		Spec:       rcmv1.ShimSpec{NodeSelector: map[string]string{"wasm": "true"}},
+	}
+	lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{
+		Name:        "worker-1",
+		Namespace:   "rcm",
+		Annotations: map[string]string{"spin.shims.kwasm.sh/status": main.AgentStatusPendingRestart, "spin.shims.kwasm.sh/generation": "1"},
+	}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node, shim, lease).Build()
+
+	config := testConfig("/etc/containerd/config.toml", "")
+	config.Agent.NodeName = "worker-1"
+	config.Agent.Namespace = "rcm"
+	hostFs := tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config")
+	require.NoError(t, afero.WriteFile(hostFs, "/opt/kwasm/restart-pending", nil, 0o644))
+	restarter := &countingRestarter{}
+	verifier := &fakeVerifier{err: errors.New("runtime handlers spin-v1 are not registered")}
+	agent := &main.Agent{
+		Client:    c,
+		Config:    config,
+		RootFs:    afero.NewMemMapFs(),
+		HostFs:    hostFs,
+		Restarter: restarter,
+		Verifier:  verifier,
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "spin"}}
+
+	t.Run("restart pending", func(t *testing.T) {
+		result, err := agent.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Positive(t, result.RequeueAfter)
+
+		require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(node), node))
+		assert.Equal(t, main.AgentStatusPendingRestart, node.Labels["spin"])
+		assert.Equal(t, 0, restarter.calls, "shim is not installed again")
+	})
+
+	t.Run("containerd restarted", func(t *testing.T) {
+		verifier.err = nil
+
+		result, err := agent.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+		assert.Zero(t, result.RequeueAfter)
+
+		require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(node), node))
+		assert.Equal(t, main.AgentStatusProvisioned, node.Labels["spin"])
+		require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(lease), lease))
+		assert.Equal(t, main.AgentStatusProvisioned, lease.Annotations["spin.shims.kwasm.sh/status"])
+		assert.Equal(t, 0, restarter.calls)
+	})
+}
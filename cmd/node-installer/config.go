@@ -16,16 +16,69 @@
 
 package main
 
+import (
+	"path"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spinkube/runtime-class-manager/internal/containerd"
+	"github.com/spinkube/runtime-class-manager/internal/state"
+)
+
 type Config struct {
 	Runtime struct {
-		Name       string
-		ConfigPath string
+		Name string
+		// Distro is the name of the distro preset to use instead of
+		// detecting the distro.
+		Distro        string
+		ConfigPath    string
+		SocketPath    string
+		Restarter     string
+		RestartPolicy string
+		VerifyTimeout time.Duration
 	}
 	Kwasm struct {
-		Path      string
-		AssetPath string
+		Path               string
+		AssetPath          string
+		TerminationLogPath string
+		// LockTimeout is the time to wait for other node-installer
+		// processes on the node to finish.
+		LockTimeout time.Duration
+		// KeepVersions is the number of previous versions kept per shim.
+		KeepVersions int
 	}
 	Host struct {
 		RootPath string
 	}
+	// Source is recorded in the lock file for installed shims.
+	Source state.Source
+	// Version is the sha256 prefix of a kept version of the shim to
+	// activate instead of installing the shim from the asset path.
+	Version string
+	// Agent configures the agent command.
+	Agent struct {
+		NodeName          string
+		Namespace         string
+		HeartbeatInterval time.Duration
+	}
+	// Log configures the format and level of log records.
+	Log struct {
+		Format string
+		Level  string
+	}
+	// DryRun reports the changes an install or uninstall would make
+	// without changing anything on the host.
+	DryRun bool
+}
+
+// newContainerdConfig returns the containerd config for the configured
+// runtime. Unless disabled, containerd is verified through its CRI socket
+// after every restart.
+func newContainerdConfig(config Config, hostFs afero.Fs, restarter containerd.Restarter) *containerd.Config {
+	containerdConfig := containerd.NewConfig(hostFs, config.Runtime.ConfigPath, config.Kwasm.Path, restarter)
+	if config.Runtime.VerifyTimeout > 0 {
+		socketPath := path.Join(config.Host.RootPath, config.Runtime.SocketPath)
+		containerdConfig.WithVerifier(containerd.NewCRIVerifier(socketPath, config.Runtime.VerifyTimeout))
+	}
+	return containerdConfig
 }
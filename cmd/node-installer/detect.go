@@ -17,47 +17,368 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"os"
+	"slices"
+	"strings"
 
+	"github.com/mitchellh/go-ps"
 	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/spinkube/runtime-class-manager/internal/containerd"
 	"github.com/spinkube/runtime-class-manager/internal/preset"
+	"github.com/spinkube/runtime-class-manager/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-var containerdConfigLocations = map[string]preset.Settings{
-	// Microk8s
-	"/var/snap/microk8s/current/args/containerd-template.toml": preset.MicroK8s,
-	// RKE2
-	"/var/lib/rancher/rke2/agent/etc/containerd/config.toml": preset.RKE2,
-	// K3s
-	"/var/lib/rancher/k3s/agent/etc/containerd/config.toml": preset.K3s,
-	// K0s
-	"/etc/k0s/containerd.toml": preset.K0s,
-	// default
-	"/etc/containerd/config.toml": preset.Default,
+// detectCmd represents the detect command.
+var detectCmd = &cobra.Command{
+	Use:   "detect",
+	Short: "Print the detected distro and runtime settings as JSON",
+	Run: func(_ *cobra.Command, _ []string) {
+		hostFs := afero.NewBasePathFs(afero.NewOsFs(), config.Host.RootPath)
+
+		processes, err := runningProcesses()
+		if err != nil {
+			slog.Warn("failed to list processes, detecting distro from files only", "error", err)
+		}
+
+		detection, err := Detect(config, hostFs, processes)
+		if err != nil {
+			slog.Error("failed to detect containerd config", "error", err)
+			os.Exit(1)
+		}
+
+		restarter, err := SelectRestarter(config, detection.Distro)
+		if err != nil {
+			slog.Error("failed to select restarter", "error", err)
+			os.Exit(1)
+		}
+
+		if err := WriteDetection(os.Stdout, config, detection, restarter); err != nil {
+			slog.Error("failed to write detection result", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(detectCmd)
+}
+
+// detectionReport is the output of the detect command.
+type detectionReport struct {
+	Distro     string     `json:"distro"`
+	ConfigPath string     `json:"configPath"`
+	SocketPath string     `json:"socketPath"`
+	Restarter  string     `json:"restarter"`
+	Confidence Confidence `json:"confidence"`
+	Evidence   []string   `json:"evidence"`
+}
+
+// WriteDetection writes the detection result and the selected restarter as JSON.
+func WriteDetection(w io.Writer, config Config, detection Detection, restarter containerd.Restarter) error {
+	report := detectionReport{
+		Distro:     detection.Distro.Name,
+		ConfigPath: detection.Distro.ConfigPath,
+		SocketPath: detection.Distro.SocketPath,
+		Restarter:  fmt.Sprint(restarter),
+		Confidence: detection.Confidence,
+		Evidence:   detection.Evidence,
+	}
+	if config.Runtime.SocketPath != "" {
+		report.SocketPath = config.Runtime.SocketPath
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// Confidence describes how certain the distro detection is.
+type Confidence string
+
+const (
+	// ConfidenceHigh means the distro has been identified by a marker, or its
+	// runtime config has been found together with its processes or binaries.
+	ConfidenceHigh Confidence = "high"
+	// ConfidenceMedium means only the runtime config of the distro has been found.
+	ConfidenceMedium Confidence = "medium"
+	// ConfidenceLow means the runtime config path has been set explicitly but
+	// does not belong to a known distro, so the defaults are used.
+	ConfidenceLow Confidence = "low"
+)
+
+// Detection is the result of the distro detection on a host.
+type Detection struct {
+	Distro     preset.Settings
+	Confidence Confidence
+	// Evidence lists what the detection is based on.
+	Evidence []string
+}
+
+// distroProbe describes how to recognize a distro on the host.
+type distroProbe struct {
+	distro preset.Settings
+	// markers identify the distro on their own.
+	markers []marker
+	// configs are the locations of the runtime config of the distro.
+	configs []string
+	// processes and binaries support a config found on the host.
+	processes []string
+	binaries  []string
+}
+
+// marker checks the host for evidence of a distro and describes what it found.
+type marker func(hostFs afero.Fs) (string, bool)
+
+// distroProbes are checked in order, more specific distros come first so
+// that e.g. a K3s node that also has /etc/containerd/config.toml is not
+// detected as a plain containerd host.
+var distroProbes = []distroProbe{
+	{
+		distro:  preset.Talos,
+		markers: []marker{osReleaseID("talos")},
+		configs: []string{"/etc/cri/containerd.toml"},
+	},
+	{
+		distro:  preset.Bottlerocket,
+		markers: []marker{osReleaseID("bottlerocket")},
+	},
+	{
+		distro:    preset.OpenShift,
+		markers:   []marker{osReleaseID("rhcos")},
+		configs:   []string{"/etc/crio/crio.conf"},
+		processes: []string{"crio"},
+		binaries:  []string{"/usr/bin/crio"},
+	},
+	{
+		distro:  preset.Kind,
+		markers: []marker{fileExists("/kind/version")},
+	},
+	{
+		distro:  preset.K3d,
+		markers: []marker{allOf(fileExists("/.dockerenv"), fileExists("/var/lib/rancher/k3s/agent/etc/containerd/config.toml"))},
+	},
+	{
+		distro:    preset.MicroK8s,
+		configs:   []string{"/var/snap/microk8s/current/args/containerd-template.toml"},
+		processes: []string{"kubelite"},
+		binaries:  []string{"/snap/bin/microk8s"},
+	},
+	{
+		distro:    preset.RKE2,
+		configs:   []string{"/var/lib/rancher/rke2/agent/etc/containerd/config.toml"},
+		processes: []string{"rke2"},
+		binaries:  []string{"/usr/local/bin/rke2", "/opt/rke2/bin/rke2", "/usr/bin/rke2"},
+	},
+	{
+		distro:    preset.K3s,
+		configs:   []string{"/var/lib/rancher/k3s/agent/etc/containerd/config.toml"},
+		processes: []string{"k3s", "k3s-agent", "k3s-server"},
+		binaries:  []string{"/usr/local/bin/k3s", "/usr/bin/k3s"},
+	},
+	{
+		distro:    preset.K0s,
+		configs:   []string{"/etc/k0s/containerd.toml"},
+		processes: []string{"k0s"},
+		binaries:  []string{"/usr/local/bin/k0s", "/usr/bin/k0s"},
+	},
+	{
+		distro:    preset.Default,
+		configs:   []string{"/etc/containerd/config.toml"},
+		processes: []string{"containerd"},
+		binaries:  []string{"/usr/bin/containerd", "/usr/local/bin/containerd"},
+	},
+}
+
+func fileExists(path string) marker {
+	return func(hostFs afero.Fs) (string, bool) {
+		_, err := hostFs.Stat(path)
+		return "found " + path, err == nil
+	}
+}
+
+func allOf(markers ...marker) marker {
+	return func(hostFs afero.Fs) (string, bool) {
+		var evidence []string
+		for _, m := range markers {
+			e, ok := m(hostFs)
+			if !ok {
+				return "", false
+			}
+			evidence = append(evidence, e)
+		}
+		return strings.Join(evidence, " and "), true
+	}
+}
+
+// osReleaseID matches hosts whose /etc/os-release has the given ID.
+func osReleaseID(id string) marker {
+	return func(hostFs afero.Fs) (string, bool) {
+		data, err := afero.ReadFile(hostFs, "/etc/os-release")
+		if err != nil {
+			return "", false
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+			if ok && key == "ID" {
+				return "os-release ID is " + id, strings.Trim(value, `"'`) == id
+			}
+		}
+		return "", false
+	}
+}
+
+// runningProcesses returns the executable names of all processes visible to
+// node-installer. The installer pods share the PID namespace of the host.
+var runningProcesses = func() ([]string, error) {
+	processes, err := ps.Processes()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(processes))
+	for _, p := range processes {
+		names = append(names, p.Executable())
+	}
+	return names, nil
+}
+
+// DetectDistro detects the distro of the host and logs the evidence the
+// detection is based on.
+func DetectDistro(ctx context.Context, config Config, hostFs afero.Fs) (_ preset.Settings, err error) {
+	_, span := tracing.Start(ctx, "detect distro")
+	defer func() { tracing.End(span, err) }()
+
+	processes, err := runningProcesses()
+	if err != nil {
+		slog.Warn("failed to list processes, detecting distro from files only", "error", err)
+	}
+
+	detection, err := Detect(config, hostFs, processes)
+	if err != nil {
+		return preset.Settings{}, err
+	}
+	slog.Info("detected distro", "distro", detection.Distro.Name, "confidence", detection.Confidence, "evidence", detection.Evidence)
+	span.SetAttributes(attribute.String("distro", detection.Distro.Name), attribute.String("confidence", string(detection.Confidence)))
+
+	return detection.Distro, nil
 }
 
-func DetectDistro(config Config, hostFs afero.Fs) (preset.Settings, error) {
+// Detect detects the distro of the host from marker files, the location of
+// the runtime config and the given running processes. Distros are checked
+// in the order of distroProbes. A distro whose config is backed by a running
+// process or an installed binary takes precedence over one where only the
+// config has been found. A distro set explicitly is used as is, as the
+// files it would be detected from may not be accessible.
+func Detect(config Config, hostFs afero.Fs, processes []string) (Detection, error) {
+	if config.Runtime.Distro != "" {
+		distro, ok := preset.ByName(config.Runtime.Distro)
+		if !ok {
+			return Detection{}, fmt.Errorf("unknown distro %q", config.Runtime.Distro)
+		}
+		if config.Runtime.ConfigPath != "" {
+			distro = distro.WithConfigPath(config.Runtime.ConfigPath)
+		}
+		return Detection{
+			Distro:     distro,
+			Confidence: ConfidenceHigh,
+			Evidence:   []string{"distro set to " + config.Runtime.Distro},
+		}, nil
+	}
+
 	if config.Runtime.ConfigPath != "" {
-		// containerd config path has been set explicitly
-		if distro, ok := containerdConfigLocations[config.Runtime.ConfigPath]; ok {
-			return distro, nil
+		// containerd config path has been set explicitly, it is matched
+		// against the config paths the presets configure.
+		for _, distro := range preset.All {
+			if distro.ConfigPath == config.Runtime.ConfigPath {
+				return Detection{
+					Distro:     distro,
+					Confidence: ConfidenceHigh,
+					Evidence:   []string{"runtime config set to " + config.Runtime.ConfigPath},
+				}, nil
+			}
 		}
 		slog.Warn("could not determine distro from containerd config, falling back to defaults", "config", config.Runtime.ConfigPath)
-		return preset.Default.WithConfigPath(config.Runtime.ConfigPath), nil
+		return Detection{
+			Distro:     preset.Default.WithConfigPath(config.Runtime.ConfigPath),
+			Confidence: ConfidenceLow,
+			Evidence:   []string{"runtime config set to " + config.Runtime.ConfigPath},
+		}, nil
 	}
 
+	for _, probe := range distroProbes {
+		for _, m := range probe.markers {
+			if evidence, ok := m(hostFs); ok {
+				return Detection{Distro: probe.distro, Confidence: ConfidenceHigh, Evidence: []string{evidence}}, nil
+			}
+		}
+	}
+
+	var candidate *Detection
 	var errs []error
 
-	for loc, distro := range containerdConfigLocations {
-		_, err := hostFs.Stat(loc)
-		if err == nil {
-			// config file found, return corresponding distro settings
-			return distro, nil
+	for _, probe := range distroProbes {
+		for _, loc := range probe.configs {
+			_, err := hostFs.Stat(loc)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			evidence := append([]string{"found " + loc}, supportingEvidence(probe, hostFs, processes)...)
+			if len(evidence) > 1 {
+				return Detection{Distro: probe.distro, Confidence: ConfidenceHigh, Evidence: evidence}, nil
+			}
+			if candidate == nil {
+				candidate = &Detection{Distro: probe.distro, Confidence: ConfidenceMedium, Evidence: evidence}
+			}
 		}
-		errs = append(errs, err)
 	}
 
-	return preset.Settings{}, fmt.Errorf("failed to detect containerd config path: %w", errors.Join(errs...))
+	if candidate != nil {
+		return *candidate, nil
+	}
+
+	return Detection{}, fmt.Errorf("failed to detect containerd config path: %w", errors.Join(errs...))
+}
+
+// supportingEvidence returns the processes and binaries of the distro found
+// on the host.
+func supportingEvidence(probe distroProbe, hostFs afero.Fs, processes []string) []string {
+	var evidence []string
+	for _, name := range probe.processes {
+		if slices.Contains(processes, name) {
+			evidence = append(evidence, "process "+name+" is running")
+		}
+	}
+	for _, bin := range probe.binaries {
+		if _, err := hostFs.Stat(bin); err == nil {
+			evidence = append(evidence, "found "+bin)
+		}
+	}
+	return evidence
+}
+
+// SelectRestarter returns the restarter selected with --restarter. By default
+// the restarter of the detected distro is used.
+func SelectRestarter(config Config, distro preset.Settings) (containerd.Restarter, error) {
+	switch config.Runtime.Restarter {
+	case "", "auto":
+		return distro.Restarter, nil
+	case "systemd":
+		if len(distro.SystemdUnits) == 0 {
+			return nil, errors.New("no systemd units known for the detected distro")
+		}
+		return containerd.NewSystemdRestarter(distro.SystemdUnits...), nil
+	case "signal":
+		return containerd.NewRestarter(), nil
+	default:
+		return nil, fmt.Errorf("unknown restarter %q, must be one of auto, systemd, signal", config.Runtime.Restarter)
+	}
 }
@@ -17,168 +17,241 @@
 package main_test
 
 import (
+	"bytes"
 	"reflect"
 	"testing"
 
 	"github.com/spf13/afero"
 	main "github.com/spinkube/runtime-class-manager/cmd/node-installer"
+	"github.com/spinkube/runtime-class-manager/internal/containerd"
 	"github.com/spinkube/runtime-class-manager/internal/preset"
 	tests "github.com/spinkube/runtime-class-manager/tests/node-installer"
 	"github.com/stretchr/testify/require"
 )
 
-func Test_DetectDistro(t *testing.T) {
+func Test_Detect(t *testing.T) {
 	type args struct {
-		config main.Config
-		hostFs afero.Fs
+		config    main.Config
+		hostFs    afero.Fs
+		processes []string
 	}
 	tests := []struct {
-		name       string
-		args       args
-		wantErr    bool
-		wantPreset preset.Settings
+		name           string
+		args           args
+		wantErr        bool
+		wantPreset     preset.Settings
+		wantConfidence main.Confidence
 	}{
 		{
 			"config_override",
 			args{
-				main.Config{
-					struct {
-						Name       string
-						ConfigPath string
-					}{"containerd", preset.MicroK8s.ConfigPath},
-					struct {
-						Path      string
-						AssetPath string
-					}{"/opt/kwasm", "/assets"},
-					struct{ RootPath string }{""},
-				},
+				testConfig(preset.MicroK8s.ConfigPath, ""),
 				tests.FixtureFs("../../testdata/node-installer/distros/default"),
+				nil,
 			},
 			false,
 			preset.MicroK8s,
+			main.ConfidenceHigh,
 		},
 		{
 			"config_not_found_fallback_default",
 			args{
-				main.Config{
-					struct {
-						Name       string
-						ConfigPath string
-					}{"containerd", "/etc/containerd/not_found.toml"},
-					struct {
-						Path      string
-						AssetPath string
-					}{"/opt/kwasm", "/assets"},
-					struct{ RootPath string }{""},
-				},
+				testConfig("/etc/containerd/not_found.toml", ""),
 				tests.FixtureFs("../../testdata/node-installer/distros/default"),
+				nil,
 			},
 			false,
 			preset.Default.WithConfigPath("/etc/containerd/not_found.toml"),
+			main.ConfidenceLow,
 		},
 		{
 			"unsupported",
 			args{
-				main.Config{
-					struct {
-						Name       string
-						ConfigPath string
-					}{"containerd", ""},
-					struct {
-						Path      string
-						AssetPath string
-					}{"/opt/kwasm", "/assets"},
-					struct{ RootPath string }{""},
-				},
+				testConfig("", ""),
 				tests.FixtureFs("../../testdata/node-installer/distros/unsupported"),
+				nil,
 			},
 			true,
 			preset.Default,
+			"",
+		},
+		{
+			"default",
+			args{
+				testConfig("", ""),
+				tests.FixtureFs("../../testdata/node-installer/distros/default"),
+				nil,
+			},
+			false,
+			preset.Default,
+			main.ConfidenceMedium,
+		},
+		{
+			"default_running",
+			args{
+				testConfig("", ""),
+				tests.FixtureFs("../../testdata/node-installer/distros/default"),
+				[]string{"systemd", "containerd"},
+			},
+			false,
+			preset.Default,
+			main.ConfidenceHigh,
 		},
 		{
 			"microk8s",
 			args{
-				main.Config{
-					struct {
-						Name       string
-						ConfigPath string
-					}{"containerd", ""},
-					struct {
-						Path      string
-						AssetPath string
-					}{"/opt/kwasm", "/assets"},
-					struct{ RootPath string }{""},
-				},
+				testConfig("", ""),
 				tests.FixtureFs("../../testdata/node-installer/distros/microk8s"),
+				nil,
 			},
 			false,
 			preset.MicroK8s,
+			main.ConfidenceMedium,
 		},
 		{
 			"k0s",
 			args{
-				main.Config{
-					struct {
-						Name       string
-						ConfigPath string
-					}{"containerd", ""},
-					struct {
-						Path      string
-						AssetPath string
-					}{"/opt/kwasm", "/assets"},
-					struct{ RootPath string }{""},
-				},
+				testConfig("", ""),
 				tests.FixtureFs("../../testdata/node-installer/distros/k0s"),
+				nil,
 			},
 			false,
 			preset.K0s,
+			main.ConfidenceMedium,
 		},
 		{
 			"k3s",
 			args{
-				main.Config{
-					struct {
-						Name       string
-						ConfigPath string
-					}{"containerd", ""},
-					struct {
-						Path      string
-						AssetPath string
-					}{"/opt/kwasm", "/assets"},
-					struct{ RootPath string }{""},
-				},
+				testConfig("", ""),
+				tests.FixtureFs("../../testdata/node-installer/distros/k3s"),
+				nil,
+			},
+			false,
+			preset.K3s,
+			main.ConfidenceMedium,
+		},
+		{
+			"k3s_running",
+			args{
+				testConfig("", ""),
 				tests.FixtureFs("../../testdata/node-installer/distros/k3s"),
+				[]string{"k3s-server", "containerd"},
 			},
 			false,
 			preset.K3s,
+			main.ConfidenceHigh,
 		},
 		{
 			"rke2",
 			args{
-				main.Config{
-					struct {
-						Name       string
-						ConfigPath string
-					}{"containerd", ""},
-					struct {
-						Path      string
-						AssetPath string
-					}{"/opt/kwasm", "/assets"},
-					struct{ RootPath string }{""},
-				},
+				testConfig("", ""),
 				tests.FixtureFs("../../testdata/node-installer/distros/rke2"),
+				nil,
 			},
 			false,
 			preset.RKE2,
+			main.ConfidenceMedium,
+		},
+		{
+			"kind",
+			args{
+				testConfig("", ""),
+				tests.FixtureFs("../../testdata/node-installer/distros/kind"),
+				nil,
+			},
+			false,
+			preset.Kind,
+			main.ConfidenceHigh,
+		},
+		{
+			"k3d",
+			args{
+				testConfig("", ""),
+				tests.FixtureFs("../../testdata/node-installer/distros/k3d"),
+				nil,
+			},
+			false,
+			preset.K3d,
+			main.ConfidenceHigh,
+		},
+		{
+			"talos",
+			args{
+				testConfig("", ""),
+				tests.FixtureFs("../../testdata/node-installer/distros/talos"),
+				nil,
+			},
+			false,
+			preset.Talos,
+			main.ConfidenceHigh,
+		},
+		{
+			"bottlerocket",
+			args{
+				testConfig("", ""),
+				tests.FixtureFs("../../testdata/node-installer/distros/bottlerocket"),
+				nil,
+			},
+			false,
+			preset.Bottlerocket,
+			main.ConfidenceHigh,
+		},
+		{
+			"openshift",
+			args{
+				testConfig("", ""),
+				tests.FixtureFs("../../testdata/node-installer/distros/openshift"),
+				nil,
+			},
+			false,
+			preset.OpenShift,
+			main.ConfidenceHigh,
+		},
+		{
+			"k3s_and_containerd_prefers_k3s",
+			args{
+				testConfig("", ""),
+				tests.FixtureFs("../../testdata/node-installer/distros/k3s-and-containerd"),
+				nil,
+			},
+			false,
+			preset.K3s,
+			main.ConfidenceMedium,
+		},
+		{
+			"k3s_and_containerd_prefers_running_containerd",
+			args{
+				testConfig("", ""),
+				tests.FixtureFs("../../testdata/node-installer/distros/k3s-and-containerd"),
+				[]string{"containerd"},
+			},
+			false,
+			preset.Default,
+			main.ConfidenceHigh,
+		},
+		{
+			"k3s_and_containerd_prefers_installed_k3s",
+			args{
+				testConfig("", ""),
+				tests.FixtureFs("../../testdata/node-installer/distros/k3s-binary"),
+				[]string{"containerd"},
+			},
+			false,
+			preset.K3s,
+			main.ConfidenceHigh,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			preset, err := main.DetectDistro(tt.args.config, tt.args.hostFs)
+			detection, err := main.Detect(tt.args.config, tt.args.hostFs, tt.args.processes)
 			if tt.wantErr {
 				require.Error(t, err)
 			} else {
 				require.NoError(t, err)
+				require.Equal(t, tt.wantConfidence, detection.Confidence)
+				require.NotEmpty(t, detection.Evidence)
+				preset := detection.Distro
+				require.Equal(t, tt.wantPreset.Name, preset.Name)
 				require.Equal(t, tt.wantPreset.ConfigPath, preset.ConfigPath)
 				require.Equal(t, reflect.ValueOf(tt.wantPreset.Setup), reflect.ValueOf(preset.Setup))
 				require.Equal(t, reflect.ValueOf(tt.wantPreset.Restarter), reflect.ValueOf(preset.Restarter))
@@ -186,3 +259,114 @@ func Test_DetectDistro(t *testing.T) {
 		})
 	}
 }
+
+func Test_Detect_Distro(t *testing.T) {
+	config := testConfig("", "")
+	config.Runtime.Distro = preset.K3s.Name
+	// The files of k3s are not accessible, e.g. in an unprivileged pod.
+	hostFs := tests.FixtureFs("../../testdata/node-installer/distros/unsupported")
+
+	detection, err := main.Detect(config, hostFs, nil)
+	require.NoError(t, err)
+	require.Equal(t, preset.K3s.Name, detection.Distro.Name)
+	require.Equal(t, preset.K3s.ConfigPath, detection.Distro.ConfigPath)
+	require.Equal(t, main.ConfidenceHigh, detection.Confidence)
+
+	config.Runtime.ConfigPath = "/etc/k3s/config.toml.tmpl"
+	detection, err = main.Detect(config, hostFs, nil)
+	require.NoError(t, err)
+	require.Equal(t, "/etc/k3s/config.toml.tmpl", detection.Distro.ConfigPath)
+
+	config.Runtime.Distro = "windows"
+	_, err = main.Detect(config, hostFs, nil)
+	require.ErrorContains(t, err, `unknown distro "windows"`)
+}
+
+func Test_Detect_ConfigPath(t *testing.T) {
+	hostFs := tests.FixtureFs("../../testdata/node-installer/distros/unsupported")
+
+	// Kind and Bottlerocket share the config path of the default preset,
+	// K3d the one of K3s.
+	tests := []struct {
+		configPath string
+		want       preset.Settings
+	}{
+		{preset.Default.ConfigPath, preset.Default},
+		{preset.K3s.ConfigPath, preset.K3s},
+		{preset.RKE2.ConfigPath, preset.RKE2},
+		{preset.MicroK8s.ConfigPath, preset.MicroK8s},
+		{preset.K0s.ConfigPath, preset.K0s},
+		{preset.Talos.ConfigPath, preset.Talos},
+		{preset.OpenShift.ConfigPath, preset.OpenShift},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want.Name, func(t *testing.T) {
+			detection, err := main.Detect(testConfig(tt.configPath, ""), hostFs, nil)
+			require.NoError(t, err)
+			require.Equal(t, tt.want.Name, detection.Distro.Name)
+			require.Equal(t, tt.configPath, detection.Distro.ConfigPath)
+			require.Equal(t, main.ConfidenceHigh, detection.Confidence)
+		})
+	}
+}
+
+func Test_Detect_Deterministic(t *testing.T) {
+	hostFs := tests.FixtureFs("../../testdata/node-installer/distros/k3s-and-containerd")
+	for range 20 {
+		detection, err := main.Detect(testConfig("", ""), hostFs, nil)
+		require.NoError(t, err)
+		require.Equal(t, preset.K3s.Name, detection.Distro.Name)
+	}
+}
+
+func Test_WriteDetection(t *testing.T) {
+	detection := main.Detection{
+		Distro:     preset.K3s,
+		Confidence: main.ConfidenceHigh,
+		Evidence:   []string{"found /usr/local/bin/k3s"},
+	}
+	config := testConfig("", "")
+	config.Runtime.SocketPath = "/run/custom.sock"
+
+	var out bytes.Buffer
+	require.NoError(t, main.WriteDetection(&out, config, detection, containerd.NewRestarter()))
+	require.JSONEq(t, `{
+		"distro": "k3s",
+		"configPath": "/var/lib/rancher/k3s/agent/etc/containerd/config.toml.tmpl",
+		"socketPath": "/run/custom.sock",
+		"restarter": "signal",
+		"confidence": "high",
+		"evidence": ["found /usr/local/bin/k3s"]
+	}`, out.String())
+}
+
+func Test_SelectRestarter(t *testing.T) {
+	tests := []struct {
+		name          string
+		restarter     string
+		distro        preset.Settings
+		wantRestarter containerd.Restarter
+		wantErr       bool
+	}{
+		{"auto", "auto", preset.K3s, preset.K3s.Restarter, false},
+		{"empty", "", preset.RKE2, preset.RKE2.Restarter, false},
+		{"systemd", "systemd", preset.K3s, containerd.NewSystemdRestarter("k3s-agent.service", "k3s.service"), false},
+		{"systemd without units", "systemd", preset.Default.WithSystemdUnits(), nil, true},
+		{"signal", "signal", preset.MicroK8s, containerd.NewRestarter(), false},
+		{"unknown", "reboot", preset.Default, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig("", "")
+			config.Runtime.Restarter = tt.restarter
+
+			restarter, err := main.SelectRestarter(config, tt.distro)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tt.wantRestarter, restarter)
+			}
+		})
+	}
+}
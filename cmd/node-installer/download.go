@@ -0,0 +1,135 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+	"github.com/spinkube/runtime-class-manager/internal/shim"
+	"github.com/spinkube/runtime-class-manager/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const shimBinaryPrefix = "containerd-shim-"
+
+// ErrShimNotInArchive is returned if a downloaded archive contains no shim binary.
+var ErrShimNotInArchive = errors.New("no containerd shim found in archive")
+
+// DownloadShim fetches the tar.gz archive at location and extracts it into
+// dir. Like the downloader image, the shim binary is renamed after the Shim,
+// so that multiple versions of the same shim can be installed. Other files
+// are extracted as they are, for bundles with a manifest. It returns the
+// path of the extracted shim binary.
+func DownloadShim(ctx context.Context, fs afero.Fs, client *http.Client, location, shimName, dir string) (string, error) {
+	ctx, span := tracing.Start(ctx, "fetch shim", attribute.String("shim", shimName), attribute.String("location", location))
+	binPath, err := downloadShim(ctx, fs, client, location, shimName, dir)
+	tracing.End(span, err)
+	return binPath, err
+}
+
+func downloadShim(ctx context.Context, fs afero.Fs, client *http.Client, location, shimName, dir string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download shim: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download shim: %s", resp.Status)
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read shim archive: %w", err)
+	}
+	defer gz.Close()
+
+	binPath := ""
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read shim archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg || !filepath.IsLocal(hdr.Name) {
+			continue
+		}
+
+		filePath := path.Join(dir, hdr.Name)
+		if strings.HasPrefix(path.Base(hdr.Name), shimBinaryPrefix) {
+			if binPath != "" {
+				continue
+			}
+			filePath = path.Join(path.Dir(filePath), shimBinaryPrefix+shimName)
+			binPath = filePath
+		}
+		if err := extractFile(fs, tr, filePath, hdr.FileInfo().Mode().Perm()); err != nil {
+			return "", fmt.Errorf("failed to extract %s: %w", hdr.Name, err)
+		}
+	}
+
+	if binPath == "" {
+		return "", ErrShimNotInArchive
+	}
+	return binPath, nil
+}
+
+// bundleAssetPath returns the asset path to install a downloaded shim from.
+// Bundles are installed from the directory, as their manifest names the shim
+// binary, other archives only from the shim binary at binPath.
+func bundleAssetPath(fs afero.Fs, dir, binPath string) (string, error) {
+	isBundle, err := afero.Exists(fs, path.Join(dir, shim.ManifestFile))
+	if err != nil {
+		return "", err
+	}
+	if isBundle {
+		return dir, nil
+	}
+	return binPath, nil
+}
+
+func extractFile(fs afero.Fs, r io.Reader, filePath string, perm os.FileMode) error {
+	if err := fs.MkdirAll(path.Dir(filePath), 0o755); err != nil { //nolint:mnd // file permissions
+		return err
+	}
+	f, err := fs.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil { //nolint:gosec // the archive is trusted like in the downloader image
+		return err
+	}
+	return f.Close()
+}
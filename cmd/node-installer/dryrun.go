@@ -0,0 +1,289 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/afero"
+	"github.com/spinkube/runtime-class-manager/internal/containerd"
+	"github.com/spinkube/runtime-class-manager/internal/preset"
+	"github.com/spinkube/runtime-class-manager/internal/state"
+	"github.com/spinkube/runtime-class-manager/internal/termination"
+)
+
+const (
+	ShimActionInstall = "install"
+	ShimActionUpdate  = "update"
+	ShimActionRemove  = "remove"
+)
+
+// Operation is an install or uninstall run against the given host filesystem.
+type Operation func(config Config, hostFs afero.Fs, restarter containerd.Restarter) error
+
+// dryRunRestarter records restarts instead of restarting the runtime.
+type dryRunRestarter struct {
+	restarted bool
+}
+
+func (r *dryRunRestarter) String() string {
+	return "dry-run"
+}
+
+func (r *dryRunRestarter) Restart() error {
+	r.restarted = true
+	return nil
+}
+
+// DryRun runs op against an in-memory copy of the host files it may change
+// and returns the changes it would make. Nothing is written to the host.
+// The distro setup is part of the dry run, so it must not have been run on
+// the host before.
+func DryRun(config Config, hostFs afero.Fs, distro preset.Settings, op Operation) (*termination.Preflight, error) {
+	memFs := afero.NewMemMapFs()
+
+	// Only the current shim binaries are read by install, to check that they
+	// are still intact, so older versions are not copied. Empty placeholders
+	// show which versions exist.
+	binPath := path.Join(config.Kwasm.Path, "bin")
+	if err := copyTree(hostFs, memFs, config.Kwasm.Path, func(p string) bool { return p == binPath }); err != nil {
+		return nil, fmt.Errorf("failed to copy %s: %w", config.Kwasm.Path, err)
+	}
+	if err := placeholderTree(hostFs, memFs, binPath); err != nil {
+		return nil, fmt.Errorf("failed to copy %s: %w", binPath, err)
+	}
+	if err := copyCurrentShims(hostFs, memFs, config.Kwasm.Path); err != nil {
+		return nil, fmt.Errorf("failed to copy shims: %w", err)
+	}
+	configDir := path.Dir(config.Runtime.ConfigPath)
+	if err := copyTree(hostFs, memFs, configDir, func(p string) bool { return p != configDir }); err != nil {
+		return nil, fmt.Errorf("failed to copy %s: %w", configDir, err)
+	}
+
+	if err := distro.Setup(preset.Env{ConfigPath: config.Runtime.ConfigPath, HostFs: memFs}); err != nil {
+		return nil, fmt.Errorf("failed to run distro setup: %w", err)
+	}
+
+	// The runtime is not restarted, so there is nothing to verify.
+	config.Runtime.VerifyTimeout = 0
+	restarter := &dryRunRestarter{}
+	if err := op(config, memFs, restarter); err != nil {
+		return nil, err
+	}
+
+	shims, err := shimChanges(hostFs, memFs, config.Kwasm.Path)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := configDiff(hostFs, memFs, config.Runtime.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	wasPending, err := isRestartPending(hostFs, config.Kwasm.Path)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := isRestartPending(memFs, config.Kwasm.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &termination.Preflight{
+		Shims:      shims,
+		ConfigDiff: diff,
+		Restart:    restarter.restarted || (pending && !wasPending),
+	}, nil
+}
+
+// copyTree copies the files below root from src to dst. Directories for
+// which skip returns true are left out. A missing root is not an error.
+func copyTree(src, dst afero.Fs, root string, skip func(dir string) bool) error {
+	err := afero.Walk(src, root, func(p string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if skip(p) {
+				return filepath.SkipDir
+			}
+			return dst.MkdirAll(p, info.Mode().Perm())
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		data, err := afero.ReadFile(src, p)
+		if err != nil {
+			return err
+		}
+		return afero.WriteFile(dst, p, data, info.Mode().Perm())
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// placeholderTree creates an empty file in dst for every file below root in
+// src. A missing root is not an error.
+func placeholderTree(src, dst afero.Fs, root string) error {
+	err := afero.Walk(src, root, func(p string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return dst.MkdirAll(p, info.Mode().Perm())
+		}
+		return afero.WriteFile(dst, p, nil, info.Mode().Perm())
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// copyCurrentShims copies the binaries of the shims that are currently
+// installed according to the lock file from src to dst. Binaries that are
+// missing are left out.
+func copyCurrentShims(src, dst afero.Fs, kwasmPath string) error {
+	st, err := state.Get(src, kwasmPath)
+	if err != nil {
+		return err
+	}
+	for _, shim := range st.Shims {
+		info, err := src.Stat(shim.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		data, err := afero.ReadFile(src, shim.Path)
+		if err != nil {
+			return err
+		}
+		if err := afero.WriteFile(dst, shim.Path, data, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shimChanges compares the shims recorded in the lock files of both
+// filesystems.
+func shimChanges(before, after afero.Fs, kwasmPath string) ([]termination.ShimChange, error) {
+	oldState, err := state.Get(before, kwasmPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock file: %w", err)
+	}
+	newState, err := state.Get(after, kwasmPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock file: %w", err)
+	}
+
+	var changes []termination.ShimChange
+	for name, shim := range newState.Shims {
+		switch old, ok := oldState.Shims[name]; {
+		case !ok:
+			changes = append(changes, termination.ShimChange{Name: name, Action: ShimActionInstall})
+		case !bytes.Equal(old.Sha256, shim.Sha256) || old.Path != shim.Path:
+			changes = append(changes, termination.ShimChange{Name: name, Action: ShimActionUpdate})
+		}
+	}
+	for name := range oldState.Shims {
+		if _, ok := newState.Shims[name]; !ok {
+			changes = append(changes, termination.ShimChange{Name: name, Action: ShimActionRemove})
+		}
+	}
+	slices.SortFunc(changes, func(a, b termination.ShimChange) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return changes, nil
+}
+
+// configDiff returns the unified diff of the runtime config between both
+// filesystems. It is empty if the config is unchanged.
+func configDiff(before, after afero.Fs, configPath string) (string, error) {
+	oldConfig, err := afero.ReadFile(before, configPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	newConfig, err := afero.ReadFile(after, configPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	if bytes.Equal(oldConfig, newConfig) {
+		return "", nil
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(oldConfig)),
+		B:        difflib.SplitLines(string(newConfig)),
+		FromFile: configPath,
+		ToFile:   configPath,
+		Context:  3, //nolint:mnd // lines of context, as in diff -u
+	})
+}
+
+// PrintPreflight writes the result of a dry run in a human readable form.
+func PrintPreflight(w io.Writer, preflight *termination.Preflight) error {
+	if len(preflight.Shims) == 0 && preflight.ConfigDiff == "" {
+		if _, err := fmt.Fprintln(w, "no changes to shims or runtime config"); err != nil {
+			return err
+		}
+	}
+	for _, change := range preflight.Shims {
+		if _, err := fmt.Fprintf(w, "%s shim %s\n", change.Action, change.Name); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(w, preflight.ConfigDiff); err != nil {
+		return err
+	}
+
+	restart := "containerd would not be restarted"
+	if preflight.Restart {
+		restart = "containerd would be restarted"
+	}
+	_, err := fmt.Fprintln(w, restart)
+	return err
+}
+
+// reportPreflight prints the result of a dry run and passes it on to the
+// controller through the termination log.
+func reportPreflight(config Config, preflight *termination.Preflight) {
+	if err := PrintPreflight(os.Stdout, preflight); err != nil {
+		slog.Warn("failed to print dry run result", "error", err)
+	}
+	if config.Kwasm.TerminationLogPath == "" {
+		return
+	}
+	if err := termination.Write(config.Kwasm.TerminationLogPath, termination.Message{Preflight: preflight}); err != nil {
+		slog.Warn("failed to write termination message", "path", config.Kwasm.TerminationLogPath, "error", err)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	main "github.com/spinkube/runtime-class-manager/cmd/node-installer"
+	"github.com/spinkube/runtime-class-manager/internal/containerd"
+	"github.com/spinkube/runtime-class-manager/internal/preset"
+	"github.com/spinkube/runtime-class-manager/internal/termination"
+	tests "github.com/spinkube/runtime-class-manager/tests/node-installer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DryRun(t *testing.T) {
+	rootFs := tests.FixtureFs("../../testdata/node-installer")
+	install := func(config main.Config, hostFs afero.Fs, restarter containerd.Restarter) error {
+		return main.RunInstall(context.Background(), config, rootFs, hostFs, restarter)
+	}
+	uninstall := func(config main.Config, hostFs afero.Fs, restarter containerd.Restarter) error {
+		return main.RunUninstall(context.Background(), config, rootFs, hostFs, restarter)
+	}
+
+	tests := []struct {
+		name          string
+		runtime       string
+		hostFs        afero.Fs
+		op            main.Operation
+		wantShims     []termination.ShimChange
+		wantDiffLines []string
+		wantRestart   bool
+	}{
+		{
+			"install new shims",
+			"containerd",
+			tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-shim-config"),
+			install,
+			[]termination.ShimChange{
+				{Name: "slight-v1", Action: main.ShimActionInstall},
+				{Name: "spin-v1", Action: main.ShimActionInstall},
+			},
+			[]string{
+				"--- /etc/containerd/config.toml",
+				"+[plugins.\"io.containerd.grpc.v1.cri\".containerd.runtimes.slight-v1]",
+				"+[plugins.\"io.containerd.grpc.v1.cri\".containerd.runtimes.spin-v1]",
+			},
+			true,
+		},
+		{
+			"install with existing shim",
+			"containerd",
+			tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"),
+			install,
+			[]termination.ShimChange{
+				{Name: "slight-v1", Action: main.ShimActionInstall},
+			},
+			[]string{
+				"+[plugins.\"io.containerd.grpc.v1.cri\".containerd.runtimes.slight-v1]",
+			},
+			true,
+		},
+		{
+			"uninstall",
+			"spin-v1",
+			tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"),
+			uninstall,
+			[]termination.ShimChange{
+				{Name: "spin-v1", Action: main.ShimActionRemove},
+			},
+			[]string{
+				"-[plugins.\"io.containerd.grpc.v1.cri\".containerd.runtimes.spin-v1]",
+				"-runtime_type = \"/opt/kwasm/bin/containerd-shim-spin-v1\"",
+			},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig("/etc/containerd/config.toml", "")
+			config.Runtime.Name = tt.runtime
+			before := snapshot(t, tt.hostFs)
+
+			preflight, err := main.DryRun(config, tt.hostFs, preset.Default, tt.op)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantShims, preflight.Shims)
+			for _, line := range tt.wantDiffLines {
+				assert.Contains(t, preflight.ConfigDiff, line+"\n")
+			}
+			assert.Equal(t, tt.wantRestart, preflight.Restart)
+			assert.Equal(t, before, snapshot(t, tt.hostFs), "dry run must not change the host")
+		})
+	}
+}
+
+func Test_DryRunNoChanges(t *testing.T) {
+	rootFs := tests.FixtureFs("../../testdata/node-installer")
+	hostFs := tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config")
+	config := testConfig("/etc/containerd/config.toml", "")
+	config.Kwasm.AssetPath = "/assets/containerd-shim-spin-v1"
+
+	preflight, err := main.DryRun(config, hostFs, preset.Default, func(config main.Config, hostFs afero.Fs, restarter containerd.Restarter) error {
+		return main.RunInstall(context.Background(), config, rootFs, hostFs, restarter)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &termination.Preflight{}, preflight)
+
+	var out bytes.Buffer
+	require.NoError(t, main.PrintPreflight(&out, preflight))
+	assert.Equal(t, "no changes to shims or runtime config\ncontainerd would not be restarted\n", out.String())
+}
+
+func Test_DryRunImmutableDistro(t *testing.T) {
+	hostFs := tests.FixtureFs("../../testdata/node-installer/distros/talos")
+	config := testConfig(preset.Talos.ConfigPath, "")
+
+	_, err := main.DryRun(config, hostFs, preset.Talos, func(main.Config, afero.Fs, containerd.Restarter) error {
+		t.Fatal("operation must not run when the distro setup fails")
+		return nil
+	})
+	require.ErrorIs(t, err, preset.ErrImmutableConfig)
+}
+
+// snapshot returns the contents of all files on fs.
+func snapshot(t *testing.T, fs afero.Fs) map[string]string {
+	t.Helper()
+
+	files := map[string]string{}
+	err := afero.Walk(fs, "/", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := afero.ReadFile(fs, path)
+		files[path] = string(data)
+		return err
+	})
+	require.NoError(t, err)
+	return files
+}
@@ -0,0 +1,71 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+
+	"github.com/spinkube/runtime-class-manager/internal/preset"
+	"github.com/spinkube/runtime-class-manager/internal/state"
+	"github.com/spinkube/runtime-class-manager/internal/termination"
+	"github.com/spinkube/runtime-class-manager/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// NewFailure describes the error a run failed with for the controller. The
+// class is refined for errors that have a class of their own, e.g. lock
+// timeouts. distro is empty if it has not been detected yet.
+func NewFailure(config Config, distro preset.Settings, class string, err error) *termination.Failure {
+	switch {
+	case errors.Is(err, state.ErrLockTimeout):
+		class = termination.FailureLockTimeout
+	case errors.Is(err, ErrRestartFailed):
+		class = termination.FailureRuntimeRestart
+	}
+
+	configPath := config.Runtime.ConfigPath
+	if distro.ConfigPath != "" {
+		configPath = distro.ConfigPath
+	}
+
+	return &termination.Failure{
+		Class:      class,
+		Message:    err.Error(),
+		Distro:     distro.Name,
+		ConfigPath: configPath,
+	}
+}
+
+// exitWithFailure logs the error, reports it through the termination log
+// and exits. The span of the command in ctx is ended and flushed.
+func exitWithFailure(ctx context.Context, config Config, distro preset.Settings, class, msg string, err error) {
+	slog.Error(msg, "error", err)
+	tracing.End(trace.SpanFromContext(ctx), err)
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Warn("failed to flush traces", "error", err)
+	}
+	if config.Kwasm.TerminationLogPath != "" {
+		failure := NewFailure(config, distro, class, err)
+		if err := termination.Write(config.Kwasm.TerminationLogPath, termination.Message{Failure: failure}); err != nil {
+			slog.Warn("failed to write termination message", "path", config.Kwasm.TerminationLogPath, "error", err)
+		}
+	}
+	os.Exit(1)
+}
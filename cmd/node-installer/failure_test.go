@@ -0,0 +1,99 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	main "github.com/spinkube/runtime-class-manager/cmd/node-installer"
+	"github.com/spinkube/runtime-class-manager/internal/preset"
+	"github.com/spinkube/runtime-class-manager/internal/state"
+	"github.com/spinkube/runtime-class-manager/internal/termination"
+	tests "github.com/spinkube/runtime-class-manager/tests/node-installer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingRestarter struct{}
+
+func (failingRestarter) Restart() error {
+	return errors.New("exit status 1")
+}
+
+func Test_NewFailure(t *testing.T) {
+	config := testConfig("/etc/containerd/config.toml", "")
+	k3s := preset.Default.WithConfigPath("/var/lib/rancher/k3s/agent/etc/containerd/config.toml")
+	k3s.Name = "k3s"
+
+	tests := []struct {
+		name   string
+		distro preset.Settings
+		class  string
+		err    error
+		want   termination.Failure
+	}{
+		{
+			"distro not detected",
+			preset.Settings{},
+			termination.FailureDistroDetection,
+			errors.New("no containerd config found"),
+			termination.Failure{Class: termination.FailureDistroDetection, Message: "no containerd config found", ConfigPath: "/etc/containerd/config.toml"},
+		},
+		{
+			"install failed",
+			k3s,
+			termination.FailureInstall,
+			errors.New("failed to install shim 'spin-v2': permission denied"),
+			termination.Failure{Class: termination.FailureInstall, Message: "failed to install shim 'spin-v2': permission denied", Distro: "k3s", ConfigPath: k3s.ConfigPath},
+		},
+		{
+			"lock timeout",
+			k3s,
+			termination.FailureInstall,
+			fmt.Errorf("%w after 5m0s", state.ErrLockTimeout),
+			termination.Failure{Class: termination.FailureLockTimeout, Message: "timed out waiting for the host lock after 5m0s", Distro: "k3s", ConfigPath: k3s.ConfigPath},
+		},
+		{
+			"restart failed",
+			k3s,
+			termination.FailureUninstall,
+			fmt.Errorf("%w: exit status 1", main.ErrRestartFailed),
+			termination.Failure{Class: termination.FailureRuntimeRestart, Message: "failed to restart containerd: exit status 1", Distro: "k3s", ConfigPath: k3s.ConfigPath},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := main.NewFailure(config, tt.distro, tt.class, tt.err)
+			assert.Equal(t, tt.want, *got)
+		})
+	}
+}
+
+func Test_RunInstallRestartFailure(t *testing.T) {
+	rootFs := tests.FixtureFs("../../testdata/node-installer")
+	hostFs := tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-shim-config")
+	config := testConfig("/etc/containerd/config.toml", "")
+
+	err := main.RunInstall(context.Background(), config, rootFs, hostFs, failingRestarter{})
+	require.Error(t, err)
+
+	failure := main.NewFailure(config, preset.Default, termination.FailureInstall, err)
+	assert.Equal(t, termination.FailureRuntimeRestart, failure.Class)
+}
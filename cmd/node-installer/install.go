@@ -17,10 +17,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
 	"log/slog"
-	"os"
 	"path"
 
 	"github.com/spf13/afero"
@@ -28,53 +28,130 @@ import (
 	"github.com/spinkube/runtime-class-manager/internal/containerd"
 	"github.com/spinkube/runtime-class-manager/internal/preset"
 	"github.com/spinkube/runtime-class-manager/internal/shim"
+	"github.com/spinkube/runtime-class-manager/internal/state"
+	"github.com/spinkube/runtime-class-manager/internal/termination"
+	"github.com/spinkube/runtime-class-manager/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // installCmd represents the install command.
 var installCmd = &cobra.Command{
 	Use:   "install",
 	Short: "Install containerd shims",
-	Run: func(_ *cobra.Command, _ []string) {
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx, span := tracing.Start(cmd.Context(), "node-installer install", attribute.String("shim", config.Runtime.Name))
+		defer span.End()
+
+		if err := validateRestartPolicy(config.Runtime.RestartPolicy); err != nil {
+			exitWithFailure(ctx, config, preset.Settings{}, termination.FailureInvalidConfig, "invalid restart policy", err)
+		}
+
 		rootFs := afero.NewOsFs()
 		hostFs := afero.NewBasePathFs(rootFs, config.Host.RootPath)
 
-		distro, err := DetectDistro(config, hostFs)
+		distro, err := DetectDistro(ctx, config, hostFs)
 		if err != nil {
-			slog.Error("failed to detect containerd config", "error", err)
-			os.Exit(1)
+			exitWithFailure(ctx, config, distro, termination.FailureDistroDetection, "failed to detect containerd config", err)
+		}
+
+		restarter, err := SelectRestarter(config, distro)
+		if err != nil {
+			exitWithFailure(ctx, config, distro, termination.FailureInvalidConfig, "failed to select restarter", err)
 		}
 
 		config.Runtime.ConfigPath = distro.ConfigPath
+		if config.Runtime.SocketPath == "" {
+			config.Runtime.SocketPath = distro.SocketPath
+		}
+		if config.DryRun {
+			preflight, err := DryRun(config, hostFs, distro, func(config Config, hostFs afero.Fs, restarter containerd.Restarter) error {
+				return RunInstall(ctx, config, rootFs, hostFs, restarter)
+			})
+			if err != nil {
+				exitWithFailure(ctx, config, distro, termination.FailureInstall, "failed to dry run install", err)
+			}
+			reportPreflight(config, preflight)
+			return
+		}
+
 		if err = distro.Setup(preset.Env{ConfigPath: distro.ConfigPath, HostFs: hostFs}); err != nil {
-			slog.Error("failed to run distro setup", "error", err)
-			os.Exit(1)
+			exitWithFailure(ctx, config, distro, termination.FailureDistroSetup, "failed to run distro setup", err)
 		}
 
-		if err := RunInstall(config, rootFs, hostFs, distro.Restarter); err != nil {
-			slog.Error("failed to install", "error", err)
-			os.Exit(1)
+		counter := &restartCounter{Restarter: restarter}
+		if err := RunInstall(ctx, config, rootFs, hostFs, counter); err != nil {
+			exitWithFailure(ctx, config, distro, termination.FailureInstall, "failed to install", err)
 		}
+		reportInstall(config, rootFs, hostFs, counter.restarts)
 	},
 }
 
 func init() {
 	installCmd.Flags().StringVarP(&config.Kwasm.AssetPath, "asset-path", "a", "/assets", "Path to the asset to install")
+	installCmd.Flags().BoolVar(&config.DryRun, "dry-run", false, "Print the changes the install would make without changing anything")
+	installCmd.Flags().StringVar(&config.Source.URL, "source-url", "", "URL the shim has been downloaded from, recorded in the lock file")
+	installCmd.Flags().StringVar(&config.Source.FetchStrategy, "fetch-strategy", "", "Fetch strategy the shim has been downloaded with, recorded in the lock file")
+	installCmd.Flags().StringVar(&config.Source.ShimUID, "shim-uid", "", "UID of the Shim resource, recorded in the lock file")
+	installCmd.Flags().Int64Var(&config.Source.ShimGeneration, "shim-generation", 0, "Generation of the Shim resource, recorded in the lock file")
+	installCmd.Flags().StringVar(&config.Version, "pin", "", "Activate the kept version of the shim with this sha256 prefix instead of installing from the asset path")
+	installCmd.Flags().StringVar(&config.Runtime.RestartPolicy, "restart-policy", RestartPolicyImmediate, "When to restart the runtime after installing shims (immediate, deferred)")
 	rootCmd.AddCommand(installCmd)
 }
 
-func RunInstall(config Config, rootFs, hostFs afero.Fs, restarter containerd.Restarter) error {
+func RunInstall(ctx context.Context, config Config, rootFs, hostFs afero.Fs, restarter containerd.Restarter) (err error) {
+	ctx, span := tracing.Start(ctx, "install", attribute.String("shim", config.Runtime.Name))
+	defer func() { tracing.End(span, err) }()
+
+	unlock, err := state.Lock(hostFs, config.Kwasm.Path, config.Kwasm.LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock() //nolint:errcheck // closing the lock file releases the lock
+
+	containerdConfig := newContainerdConfig(config, hostFs, restarter)
+
+	var anythingChanged bool
+	if config.Version != "" {
+		anythingChanged, err = activateShim(ctx, config, hostFs, containerdConfig)
+	} else {
+		anythingChanged, err = installShims(ctx, config, rootFs, hostFs, containerdConfig)
+	}
+	if err != nil {
+		return err
+	}
+
+	return restartIfChanged(ctx, config, hostFs, containerdConfig, anythingChanged)
+}
+
+// installShims installs the shims from the asset path and configures them
+// in the runtime config.
+func installShims(ctx context.Context, config Config, rootFs, hostFs afero.Fs, containerdConfig *containerd.Config) (bool, error) {
 	// Get file or directory information.
 	info, err := rootFs.Stat(config.Kwasm.AssetPath)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	var files []fs.FileInfo
+	var manifest *shim.Manifest
 	// Check if the path is a directory.
 	if info.IsDir() {
-		files, err = afero.ReadDir(rootFs, config.Kwasm.AssetPath)
+		// A bundle manifest names the shim, all other files are auxiliary.
+		manifest, err = shim.ReadManifest(rootFs, config.Kwasm.AssetPath)
 		if err != nil {
-			return err
+			return false, err
+		}
+		if manifest != nil {
+			info, err = rootFs.Stat(path.Join(config.Kwasm.AssetPath, manifest.Shim))
+			if err != nil {
+				return false, fmt.Errorf("failed to find shim of bundle: %w", err)
+			}
+			files = append(files, info)
+		} else {
+			files, err = afero.ReadDir(rootFs, config.Kwasm.AssetPath)
+			if err != nil {
+				return false, err
+			}
 		}
 	} else {
 		// If the path is not a directory, add the file to the list of files.
@@ -82,8 +159,9 @@ func RunInstall(config Config, rootFs, hostFs afero.Fs, restarter containerd.Res
 		config.Kwasm.AssetPath = path.Dir(config.Kwasm.AssetPath)
 	}
 
-	containerdConfig := containerd.NewConfig(hostFs, config.Runtime.ConfigPath, restarter)
-	shimConfig := shim.NewConfig(rootFs, hostFs, config.Kwasm.AssetPath, config.Kwasm.Path)
+	shimConfig := shim.NewConfig(rootFs, hostFs, config.Kwasm.AssetPath, config.Kwasm.Path).
+		WithMetadata(config.Source, config.Runtime.ConfigPath).
+		WithKeepVersions(config.Kwasm.KeepVersions)
 
 	anythingChanged := false
 	for _, file := range files {
@@ -92,28 +170,95 @@ func RunInstall(config Config, rootFs, hostFs afero.Fs, restarter containerd.Res
 
 		binPath, changed, err := shimConfig.Install(fileName)
 		if err != nil {
-			return fmt.Errorf("failed to install shim '%s': %w", runtimeName, err)
+			return false, fmt.Errorf("failed to install shim '%s': %w", runtimeName, err)
 		}
 		anythingChanged = anythingChanged || changed
-		slog.Info("shim installed", "shim", runtimeName, "path", binPath, "new-version", changed)
+		slog.Info("shim installed", "path", binPath, "new-version", changed)
 
-		err = containerdConfig.AddRuntime(binPath)
+		if err := writeRuntimeConfig(ctx, runtimeName, func() error { return containerdConfig.AddRuntime(binPath) }); err != nil {
+			return false, fmt.Errorf("failed to write containerd config: %w", err)
+		}
+		slog.Info("shim configured", "path", config.Runtime.ConfigPath)
+
+		// Files of an earlier bundle are removed if the shim is no longer
+		// installed from a bundle.
+		var bundleFiles []shim.BundleFile
+		if manifest != nil {
+			bundleFiles = manifest.Files
+		}
+		changed, err = shimConfig.InstallFiles(runtimeName, bundleFiles)
 		if err != nil {
-			return fmt.Errorf("failed to write containerd config: %w", err)
+			return false, fmt.Errorf("failed to install files of shim '%s': %w", runtimeName, err)
 		}
-		slog.Info("shim configured", "shim", runtimeName, "path", config.Runtime.ConfigPath)
+		anythingChanged = anythingChanged || changed
+	}
+
+	return anythingChanged, nil
+}
+
+// activateShim makes the kept version config.Version of the shim the current
+// one and points the runtime config to it. An empty version activates the
+// most recent previous version.
+func activateShim(ctx context.Context, config Config, hostFs afero.Fs, containerdConfig *containerd.Config) (bool, error) {
+	shimName := config.Runtime.Name
+	// Kept versions are on the host, no assets are read.
+	shimConfig := shim.NewConfig(nil, hostFs, "", config.Kwasm.Path)
+
+	binPath, changed, err := shimConfig.Activate(shimName, config.Version)
+	if err != nil {
+		return false, fmt.Errorf("failed to activate version %q of shim '%s': %w", config.Version, shimName, err)
 	}
+	slog.Info("shim version activated", "path", binPath, "new-version", changed)
 
-	if !anythingChanged {
+	if err := writeRuntimeConfig(ctx, shimName, func() error { return containerdConfig.AddRuntime(binPath) }); err != nil {
+		return false, fmt.Errorf("failed to write containerd config: %w", err)
+	}
+	slog.Info("shim configured", "path", config.Runtime.ConfigPath)
+
+	return changed, nil
+}
+
+// restartIfChanged restarts the runtime after shims changed, or marks the
+// restart as pending if it is deferred.
+func restartIfChanged(ctx context.Context, config Config, hostFs afero.Fs, containerdConfig *containerd.Config, anythingChanged bool) error {
+	restartPending, err := isRestartPending(hostFs, config.Kwasm.Path)
+	if err != nil {
+		return err
+	}
+
+	if !anythingChanged && !restartPending {
 		slog.Info("nothing changed, nothing more to do")
 		return nil
 	}
 
-	slog.Info("restarting containerd")
-	err = containerdConfig.RestartRuntime()
-	if err != nil {
-		return fmt.Errorf("failed to restart containerd: %w", err)
+	if config.Runtime.RestartPolicy == RestartPolicyDeferred {
+		slog.Info("restart deferred, containerd needs to be restarted to use the installed shims")
+		return markRestartPending(hostFs, config.Kwasm.Path)
 	}
 
+	if err := restartRuntime(ctx, containerdConfig); err != nil {
+		return err
+	}
+
+	return clearRestartPending(hostFs, config.Kwasm.Path)
+}
+
+// writeRuntimeConfig changes the runtime config for the shim in a span.
+func writeRuntimeConfig(ctx context.Context, shimName string, write func() error) error {
+	_, span := tracing.Start(ctx, "write runtime config", attribute.String("shim", shimName))
+	err := write()
+	tracing.End(span, err)
+	return err
+}
+
+// restartRuntime restarts the runtime to apply a config change.
+func restartRuntime(ctx context.Context, containerdConfig *containerd.Config) (err error) {
+	_, span := tracing.Start(ctx, "restart runtime")
+	defer func() { tracing.End(span, err) }()
+
+	slog.Info("restarting containerd")
+	if err := containerdConfig.RestartRuntime(); err != nil {
+		return fmt.Errorf("%w: %w", ErrRestartFailed, err)
+	}
 	return nil
 }
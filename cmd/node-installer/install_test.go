@@ -17,12 +17,20 @@
 package main_test
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/spf13/afero"
 	main "github.com/spinkube/runtime-class-manager/cmd/node-installer"
+	"github.com/spinkube/runtime-class-manager/internal/state"
+	"github.com/spinkube/runtime-class-manager/internal/tracing"
 	tests "github.com/spinkube/runtime-class-manager/tests/node-installer"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 type nullRestarter struct{}
@@ -45,17 +53,7 @@ func Test_RunInstall(t *testing.T) {
 		{
 			"new shim",
 			args{
-				main.Config{
-					struct {
-						Name       string
-						ConfigPath string
-					}{"containerd", "/etc/containerd/config.toml"},
-					struct {
-						Path      string
-						AssetPath string
-					}{"/opt/kwasm", "/assets"},
-					struct{ RootPath string }{"/containerd/missing-containerd-shim-config"},
-				},
+				testConfig("/etc/containerd/config.toml", "/containerd/missing-containerd-shim-config"),
 				tests.FixtureFs("../../testdata/node-installer"),
 				tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-shim-config"),
 			},
@@ -64,17 +62,7 @@ func Test_RunInstall(t *testing.T) {
 		{
 			"existing shim",
 			args{
-				main.Config{
-					struct {
-						Name       string
-						ConfigPath string
-					}{"containerd", "/etc/containerd/config.toml"},
-					struct {
-						Path      string
-						AssetPath string
-					}{"/opt/kwasm", "/assets"},
-					struct{ RootPath string }{"/containerd/existing-containerd-shim-config"},
-				},
+				testConfig("/etc/containerd/config.toml", "/containerd/existing-containerd-shim-config"),
 				tests.FixtureFs("../../testdata/node-installer"),
 				tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"),
 			},
@@ -83,7 +71,7 @@ func Test_RunInstall(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := main.RunInstall(tt.args.config, tt.args.rootFs, tt.args.hostFs, nullRestarter{})
+			err := main.RunInstall(context.Background(), tt.args.config, tt.args.rootFs, tt.args.hostFs, nullRestarter{})
 			if tt.wantErr {
 				require.Error(t, err)
 			} else {
@@ -92,3 +80,182 @@ func Test_RunInstall(t *testing.T) {
 		})
 	}
 }
+
+func testConfig(runtimeConfigPath, hostRootPath string) main.Config {
+	var config main.Config
+	config.Runtime.Name = "containerd"
+	config.Runtime.ConfigPath = runtimeConfigPath
+	config.Kwasm.Path = "/opt/kwasm"
+	config.Kwasm.AssetPath = "/assets"
+	config.Host.RootPath = hostRootPath
+	return config
+}
+
+type countingRestarter struct {
+	calls int
+}
+
+func (c *countingRestarter) Restart() error {
+	c.calls++
+	return nil
+}
+
+func Test_RunInstallRestartPolicy(t *testing.T) {
+	tests := []struct {
+		name              string
+		restartPolicy     string
+		restartPending    bool
+		rootFs            afero.Fs
+		hostFs            afero.Fs
+		wantRestarts      int
+		wantPendingMarker bool
+	}{
+		{
+			"immediate restart",
+			main.RestartPolicyImmediate,
+			false,
+			tests.FixtureFs("../../testdata/node-installer"),
+			tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-shim-config"),
+			1,
+			false,
+		},
+		{
+			"deferred restart",
+			main.RestartPolicyDeferred,
+			false,
+			tests.FixtureFs("../../testdata/node-installer"),
+			tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-shim-config"),
+			0,
+			true,
+		},
+		{
+			"pending restart is caught up",
+			main.RestartPolicyImmediate,
+			true,
+			tests.FixtureFs("../../testdata/node-installer"),
+			tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-shim-config"),
+			1,
+			false,
+		},
+		{
+			"pending restart stays deferred",
+			main.RestartPolicyDeferred,
+			true,
+			tests.FixtureFs("../../testdata/node-installer"),
+			tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-shim-config"),
+			0,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig("/etc/containerd/config.toml", "/containerd/missing-containerd-shim-config")
+			config.Runtime.RestartPolicy = tt.restartPolicy
+			if tt.restartPending {
+				require.NoError(t, afero.WriteFile(tt.hostFs, "/opt/kwasm/restart-pending", nil, 0o644))
+			}
+
+			restarter := &countingRestarter{}
+			err := main.RunInstall(context.Background(), config, tt.rootFs, tt.hostFs, restarter)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantRestarts, restarter.calls)
+
+			pending, err := afero.Exists(tt.hostFs, "/opt/kwasm/restart-pending")
+			require.NoError(t, err)
+			require.Equal(t, tt.wantPendingMarker, pending)
+		})
+	}
+}
+
+// fakeVerifier reports the handlers it has been asked for as registered if
+// err is nil.
+type fakeVerifier struct {
+	err      error
+	handlers []string
+}
+
+func (f *fakeVerifier) Verify(handlers []string) error {
+	f.handlers = handlers
+	return f.err
+}
+
+func Test_CheckPendingRestart(t *testing.T) {
+	tests := []struct {
+		name              string
+		restartPending    bool
+		hostFs            afero.Fs
+		verifyErr         error
+		wantPending       bool
+		wantVerified      []string
+		wantPendingMarker bool
+	}{
+		{"no restart pending", false, tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"), nil, false, nil, false},
+		{"containerd restarted", true, tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"), nil, false, []string{"spin-v1"}, false},
+		{"containerd not restarted", true, tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"), errors.New("runtime handlers spin-v1 are not registered"), true, []string{"spin-v1"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hostFs := tt.hostFs
+			if tt.restartPending {
+				require.NoError(t, afero.WriteFile(hostFs, "/opt/kwasm/restart-pending", nil, 0o644))
+			}
+
+			verifier := &fakeVerifier{err: tt.verifyErr}
+			pending, err := main.CheckPendingRestart(testConfig("/etc/containerd/config.toml", ""), hostFs, verifier)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantPending, pending)
+			assert.Equal(t, tt.wantVerified, verifier.handlers)
+
+			marker, err := afero.Exists(hostFs, "/opt/kwasm/restart-pending")
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantPendingMarker, marker)
+		})
+	}
+}
+
+func Test_RunInstallBundle(t *testing.T) {
+	rootFs := afero.NewMemMapFs()
+	for name, content := range map[string]string{
+		"kwasm-manifest.json": `{"files":[{"source":"bin/wws-helper","destination":"bin/wws-helper","executable":true}]}`,
+		"containerd-shim-wws": "shim",
+		"bin/wws-helper":      "helper",
+		"README.md":           "readme",
+	} {
+		require.NoError(t, afero.WriteFile(rootFs, "/assets/"+name, []byte(content), 0o755))
+	}
+	hostFs := tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-shim-config")
+
+	require.NoError(t, main.RunInstall(context.Background(), testConfig("/etc/containerd/config.toml", ""), rootFs, hostFs, nullRestarter{}))
+
+	st, err := state.Get(hostFs, "/opt/kwasm")
+	require.NoError(t, err)
+	assert.Len(t, st.Shims, 1, "only the shim of the bundle is installed")
+	require.Contains(t, st.Shims, "wws")
+	require.Len(t, st.Shims["wws"].Files, 1)
+	assert.Equal(t, "/opt/kwasm/bin/wws-helper", st.Shims["wws"].Files[0].Path)
+
+	data, err := afero.ReadFile(hostFs, "/etc/containerd/config.toml")
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "runtimes.wws]")
+	assert.NotContains(t, string(data), "README")
+}
+
+func Test_RunInstallSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+	rootFs := tests.FixtureFs("../../testdata/node-installer")
+	hostFs := tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-shim-config")
+	// The job continues the trace of the reconcile that created it.
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx := tracing.ContextWithTraceParent(context.Background(), traceParent)
+
+	require.NoError(t, main.RunInstall(ctx, testConfig("/etc/containerd/config.toml", ""), rootFs, hostFs, nullRestarter{}))
+
+	names := map[string]int{}
+	for _, span := range exporter.GetSpans() {
+		names[span.Name]++
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String())
+	}
+	assert.Equal(t, map[string]int{"install": 1, "write runtime config": 2, "restart runtime": 1}, names)
+}
@@ -0,0 +1,163 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spinkube/runtime-class-manager/internal/containerd"
+	"github.com/spinkube/runtime-class-manager/internal/state"
+	"github.com/spinkube/runtime-class-manager/internal/termination"
+)
+
+const (
+	RestartPolicyImmediate = "immediate"
+	RestartPolicyDeferred  = "deferred"
+)
+
+// ErrRestartFailed is returned if the runtime could not be restarted after
+// a config change.
+var ErrRestartFailed = errors.New("failed to restart containerd")
+
+// restartCheckTimeout bounds how long verify waits for containerd to serve
+// the runtime handlers of the installed shims.
+const restartCheckTimeout = 5 * time.Second
+
+// restartCheckInterval is the interval in which the agent checks whether
+// containerd has been restarted on a node with a pending restart.
+const restartCheckInterval = time.Minute
+
+// restartPendingFile marks that the runtime config has been changed without
+// restarting the runtime. It is removed by the next run that restarts it.
+const restartPendingFile = "restart-pending"
+
+func validateRestartPolicy(policy string) error {
+	switch policy {
+	case RestartPolicyImmediate, RestartPolicyDeferred:
+		return nil
+	default:
+		return fmt.Errorf("unknown restart policy %q, must be one of %s, %s", policy, RestartPolicyImmediate, RestartPolicyDeferred)
+	}
+}
+
+func isRestartPending(hostFs afero.Fs, kwasmPath string) (bool, error) {
+	return afero.Exists(hostFs, path.Join(kwasmPath, restartPendingFile))
+}
+
+func markRestartPending(hostFs afero.Fs, kwasmPath string) error {
+	if err := hostFs.MkdirAll(kwasmPath, 0o775); err != nil { //nolint:mnd // file permissions
+		return err
+	}
+	return afero.WriteFile(hostFs, path.Join(kwasmPath, restartPendingFile), []byte(time.Now().UTC().Format(time.RFC3339)), 0o644) //nolint:mnd // file permissions
+}
+
+func clearRestartPending(hostFs afero.Fs, kwasmPath string) error {
+	err := hostFs.Remove(path.Join(kwasmPath, restartPendingFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// CheckPendingRestart clears a pending restart once containerd serves the
+// runtime handlers of all installed shims, i.e. once it has been restarted
+// with the changed config outside of node-installer. It returns whether the
+// restart is still pending.
+func CheckPendingRestart(config Config, hostFs afero.Fs, verifier containerd.Verifier) (bool, error) {
+	pending, err := isRestartPending(hostFs, config.Kwasm.Path)
+	if err != nil || !pending {
+		return pending, err
+	}
+
+	st, err := state.Get(hostFs, config.Kwasm.Path)
+	if err != nil {
+		return true, fmt.Errorf("failed to read lock file: %w", err)
+	}
+	handlers := make([]string, 0, len(st.Shims))
+	for _, shim := range st.Shims {
+		handlers = append(handlers, shim.Handler)
+	}
+	slices.Sort(handlers)
+
+	if err := verifier.Verify(handlers); err != nil {
+		slog.Info("containerd restart still pending", "error", err)
+		return true, nil
+	}
+	slog.Info("containerd has been restarted, clearing pending restart", "handlers", handlers)
+	return false, clearRestartPending(hostFs, config.Kwasm.Path)
+}
+
+// restartCounter counts the restarts of the runtime.
+type restartCounter struct {
+	containerd.Restarter
+	restarts int
+}
+
+func (r *restartCounter) Restart() error {
+	r.restarts++
+	return r.Restarter.Restart()
+}
+
+// reportInstall tells the controller through the termination log whether
+// the runtime still needs to be restarted, how often it has been restarted
+// and the size of the installed assets.
+func reportInstall(config Config, rootFs, hostFs afero.Fs, restarts int) {
+	if config.Kwasm.TerminationLogPath == "" {
+		return
+	}
+	msg := termination.Message{Restarts: restarts}
+
+	pending, err := isRestartPending(hostFs, config.Kwasm.Path)
+	if err != nil {
+		slog.Warn("failed to check for pending restart", "error", err)
+	}
+	msg.RestartPending = pending
+
+	// Pinned versions are installed without assets.
+	if config.Version == "" {
+		msg.AssetBytes, err = assetSize(rootFs, config.Kwasm.AssetPath)
+		if err != nil {
+			slog.Warn("failed to determine asset size", "path", config.Kwasm.AssetPath, "error", err)
+		}
+	}
+
+	if err := termination.Write(config.Kwasm.TerminationLogPath, msg); err != nil {
+		slog.Warn("failed to write termination message", "path", config.Kwasm.TerminationLogPath, "error", err)
+	}
+}
+
+// assetSize returns the total size of the files at assetPath.
+func assetSize(fs afero.Fs, assetPath string) (int64, error) {
+	var size int64
+	err := afero.Walk(fs, assetPath, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
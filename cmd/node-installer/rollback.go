@@ -0,0 +1,82 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/spinkube/runtime-class-manager/internal/containerd"
+	"github.com/spinkube/runtime-class-manager/internal/state"
+)
+
+// rollbackCmd represents the rollback command.
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Roll a shim back to a previously installed version",
+	Run: func(cmd *cobra.Command, _ []string) {
+		hostFs := afero.NewBasePathFs(afero.NewOsFs(), config.Host.RootPath)
+
+		distro, err := DetectDistro(cmd.Context(), config, hostFs)
+		if err != nil {
+			slog.Error("failed to detect containerd config", "error", err)
+			os.Exit(1)
+		}
+
+		restarter, err := SelectRestarter(config, distro)
+		if err != nil {
+			slog.Error("failed to select restarter", "error", err)
+			os.Exit(1)
+		}
+
+		config.Runtime.ConfigPath = distro.ConfigPath
+		if config.Runtime.SocketPath == "" {
+			config.Runtime.SocketPath = distro.SocketPath
+		}
+
+		if err := RunRollback(cmd.Context(), config, hostFs, restarter); err != nil {
+			slog.Error("failed to roll back", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rollbackCmd.Flags().StringVar(&config.Version, "to", "", "sha256 prefix of the version to roll back to. Defaults to the most recent previous version")
+	rootCmd.AddCommand(rollbackCmd)
+}
+
+// RunRollback activates a kept version of the shim config.Runtime.Name and
+// restarts the runtime to use it.
+func RunRollback(ctx context.Context, config Config, hostFs afero.Fs, restarter containerd.Restarter) error {
+	unlock, err := state.Lock(hostFs, config.Kwasm.Path, config.Kwasm.LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock() //nolint:errcheck // closing the lock file releases the lock
+
+	containerdConfig := newContainerdConfig(config, hostFs, restarter)
+	changed, err := activateShim(ctx, config, hostFs, containerdConfig)
+	if err != nil {
+		return err
+	}
+
+	return restartIfChanged(ctx, config, hostFs, containerdConfig, changed)
+}
@@ -0,0 +1,87 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	main "github.com/spinkube/runtime-class-manager/cmd/node-installer"
+	"github.com/spinkube/runtime-class-manager/internal/state"
+	tests "github.com/spinkube/runtime-class-manager/tests/node-installer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RunRollback(t *testing.T) {
+	rootFs := afero.NewMemMapFs()
+	hostFs := tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-shim-config")
+	config := testConfig("/etc/containerd/config.toml", "")
+	config.Runtime.Name = "spin-v2"
+	config.Kwasm.KeepVersions = 2
+	restarter := &countingRestarter{}
+
+	var sums []string
+	for _, content := range []string{"v1", "v2"} {
+		require.NoError(t, afero.WriteFile(rootFs, "/assets/containerd-shim-spin-v2", []byte(content), 0o755))
+		require.NoError(t, main.RunInstall(context.Background(), config, rootFs, hostFs, restarter))
+		sum := sha256.Sum256([]byte(content))
+		sums = append(sums, hex.EncodeToString(sum[:]))
+	}
+
+	assertCurrent := func(t *testing.T, sum string) {
+		t.Helper()
+		st, err := state.Get(hostFs, "/opt/kwasm")
+		require.NoError(t, err)
+		assert.Equal(t, sum, hex.EncodeToString(st.Shims["spin-v2"].Sha256))
+
+		data, err := afero.ReadFile(hostFs, "/etc/containerd/config.toml")
+		require.NoError(t, err)
+		assert.Contains(t, string(data), `runtime_type = "/opt/kwasm/bin/spin-v2/`+sum+`/containerd-shim-spin-v2"`)
+		assert.Equal(t, 1, strings.Count(string(data), "runtimes.spin-v2]"))
+	}
+	assertCurrent(t, sums[1])
+
+	t.Run("rollback to previous version", func(t *testing.T) {
+		require.NoError(t, main.RunRollback(context.Background(), config, hostFs, restarter))
+		assertCurrent(t, sums[0])
+		assert.Equal(t, 3, restarter.calls)
+	})
+
+	t.Run("install pinned version", func(t *testing.T) {
+		pinned := config
+		pinned.Version = sums[1][:12]
+		require.NoError(t, main.RunInstall(context.Background(), pinned, afero.NewMemMapFs(), hostFs, restarter))
+		assertCurrent(t, sums[1])
+		assert.Equal(t, 4, restarter.calls)
+
+		// The pinned version is already active.
+		require.NoError(t, main.RunInstall(context.Background(), pinned, afero.NewMemMapFs(), hostFs, restarter))
+		assert.Equal(t, 4, restarter.calls)
+	})
+
+	t.Run("unknown version", func(t *testing.T) {
+		unknown := config
+		unknown.Version = "ffffffff"
+		require.Error(t, main.RunRollback(context.Background(), unknown, hostFs, restarter))
+		assertCurrent(t, sums[1])
+	})
+}
@@ -17,18 +17,28 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"github.com/spinkube/runtime-class-manager/internal/logging"
+	"github.com/spinkube/runtime-class-manager/internal/shim"
+	"github.com/spinkube/runtime-class-manager/internal/termination"
+	"github.com/spinkube/runtime-class-manager/internal/tracing"
 )
 
 var (
 	config Config
+	// shutdownTracing flushes the spans of the run.
+	shutdownTracing = func(context.Context) error { return nil }
 )
 
 // rootCmd represents the base command when called without any subcommands.
@@ -36,7 +46,23 @@ var rootCmd = &cobra.Command{
 	Use:   "kwasm-node-installer",
 	Short: "kwasm-node-installer manages containerd shims",
 	PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
-		return initializeConfig(cmd)
+		if err := initializeConfig(cmd); err != nil {
+			return err
+		}
+		if err := setupLogging(cmd.Name(), config); err != nil {
+			return err
+		}
+		shutdown, err := tracing.Setup(cmd.Context(), "node-installer")
+		if err != nil {
+			return err
+		}
+		shutdownTracing = shutdown
+		// Jobs continue the trace of the controller reconcile that created them.
+		cmd.SetContext(tracing.ContextWithTraceParent(cmd.Context(), os.Getenv(tracing.EnvTraceParent)))
+		return setSystemBusAddress(config.Host.RootPath)
+	},
+	PersistentPostRunE: func(cmd *cobra.Command, _ []string) error {
+		return shutdownTracing(cmd.Context())
 	},
 }
 
@@ -51,9 +77,18 @@ func Execute() {
 
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&config.Runtime.Name, "runtime", "r", "containerd", "Set the container runtime to configure (containerd, cri-o)")
+	rootCmd.PersistentFlags().StringVar(&config.Runtime.Distro, "distro", "", "Name of the distro preset to use, e.g. k3s. Will try to autodetect if left empty")
 	rootCmd.PersistentFlags().StringVarP(&config.Runtime.ConfigPath, "runtime-config", "c", "", "Path to the runtime config file. Will try to autodetect if left empty")
+	rootCmd.PersistentFlags().StringVar(&config.Runtime.SocketPath, "runtime-socket", "", "Path to the CRI socket of the runtime. Will try to autodetect if left empty")
+	rootCmd.PersistentFlags().StringVar(&config.Runtime.Restarter, "restarter", "auto", "How to restart the runtime after a config change (auto, systemd, signal). auto uses the default of the detected distro")
+	rootCmd.PersistentFlags().DurationVar(&config.Runtime.VerifyTimeout, "verify-timeout", time.Minute, "Time to wait for the runtime to become healthy after a restart. Set to 0 to skip verification")
 	rootCmd.PersistentFlags().StringVarP(&config.Kwasm.Path, "kwasm-path", "k", "/opt/kwasm", "Working directory for kwasm on the host")
+	rootCmd.PersistentFlags().DurationVar(&config.Kwasm.LockTimeout, "lock-timeout", 5*time.Minute, "Time to wait for other installations on the node to finish") //nolint:mnd // default timeout
+	rootCmd.PersistentFlags().IntVar(&config.Kwasm.KeepVersions, "keep-versions", shim.DefaultKeepVersions, "Number of previous versions kept per shim for rollbacks")
+	rootCmd.PersistentFlags().StringVar(&config.Kwasm.TerminationLogPath, "termination-log", termination.DefaultPath, "Path to report the result of the run to. Set to empty to disable")
 	rootCmd.PersistentFlags().StringVarP(&config.Host.RootPath, "host-root", "H", "/", "Path to the host root path")
+	rootCmd.PersistentFlags().StringVar(&config.Log.Format, "log-format", logging.FormatText, "Format of log records (text, json)")
+	rootCmd.PersistentFlags().StringVar(&config.Log.Level, "log-level", "info", "Minimum level of log records (debug, info, warn, error)")
 }
 
 func initializeConfig(cmd *cobra.Command) error {
@@ -84,6 +119,34 @@ func initializeConfig(cmd *cobra.Command) error {
 	return nil
 }
 
+// setupLogging sets the default logger to write records in the configured
+// format. Records of commands that work on a single shim carry the shim and
+// the operation, records of the agent carry its node.
+func setupLogging(command string, config Config) error {
+	handler, err := logging.NewHandler(os.Stderr, config.Log.Format, config.Log.Level)
+	if err != nil {
+		return err
+	}
+	logger := slog.New(handler)
+	switch command {
+	case installCmd.Name(), uninstallCmd.Name(), verifyCmd.Name(), rollbackCmd.Name():
+		logger = logger.With(logging.KeyShim, config.Runtime.Name, logging.KeyOperation, command)
+	case agentCmd.Name():
+		logger = logger.With(logging.KeyNode, config.Agent.NodeName)
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// setSystemBusAddress points the D-Bus client to the system bus of the host,
+// unless DBUS_SYSTEM_BUS_ADDRESS has been set explicitly.
+func setSystemBusAddress(hostRoot string) error {
+	if _, ok := os.LookupEnv("DBUS_SYSTEM_BUS_ADDRESS"); ok {
+		return nil
+	}
+	return os.Setenv("DBUS_SYSTEM_BUS_ADDRESS", "unix:path="+path.Join(hostRoot, "/run/dbus/system_bus_socket"))
+}
+
 // bindFlags binds each cobra flag to its associated viper configuration.
 func bindFlags(cmd *cobra.Command, v *viper.Viper) {
 	cmd.Flags().VisitAll(func(f *pflag.Flag) {
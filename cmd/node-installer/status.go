@@ -0,0 +1,320 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/spinkube/runtime-class-manager/internal/containerd"
+	"github.com/spinkube/runtime-class-manager/internal/state"
+	"github.com/spinkube/runtime-class-manager/internal/termination"
+	"github.com/spinkube/runtime-class-manager/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+)
+
+// Kinds of drift between the lock file and the node.
+const (
+	DriftNotInstalled   = "not-installed"
+	DriftBinaryMissing  = "binary-missing"
+	DriftBinaryModified = "binary-modified"
+	DriftConfigMissing  = "config-missing"
+)
+
+var statusOutput string
+
+// statusCmd represents the status command.
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the installed shims and whether they drifted from the lock file",
+	Run: func(cmd *cobra.Command, _ []string) {
+		if statusOutput != OutputTable && statusOutput != OutputJSON {
+			slog.Error("invalid output format", "output", statusOutput)
+			os.Exit(1)
+		}
+
+		hostFs := afero.NewBasePathFs(afero.NewOsFs(), config.Host.RootPath)
+
+		distro, err := DetectDistro(cmd.Context(), config, hostFs)
+		if err != nil {
+			slog.Error("failed to detect containerd config", "error", err)
+			os.Exit(1)
+		}
+		config.Runtime.ConfigPath = distro.ConfigPath
+
+		status, err := GetStatus(config, hostFs)
+		if err != nil {
+			slog.Error("failed to get status", "error", err)
+			os.Exit(1)
+		}
+
+		if err := WriteStatus(os.Stdout, status, statusOutput); err != nil {
+			slog.Error("failed to write status", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// verifyCmd represents the verify command.
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify a shim against the lock file and report drift and pending restarts to the controller",
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx, span := tracing.Start(cmd.Context(), "node-installer verify", attribute.String("shim", config.Runtime.Name))
+		defer span.End()
+
+		hostFs := afero.NewBasePathFs(afero.NewOsFs(), config.Host.RootPath)
+
+		distro, err := DetectDistro(ctx, config, hostFs)
+		if err != nil {
+			exitWithFailure(ctx, config, distro, termination.FailureDistroDetection, "failed to detect containerd config", err)
+		}
+		config.Runtime.ConfigPath = distro.ConfigPath
+		if config.Runtime.SocketPath == "" {
+			config.Runtime.SocketPath = distro.SocketPath
+		}
+
+		drift, err := VerifyShim(config, hostFs)
+		if err != nil {
+			exitWithFailure(ctx, config, distro, termination.FailureVerify, "failed to verify shim", err)
+		}
+
+		if len(drift) == 0 {
+			slog.Info("shim in sync with lock file")
+		} else {
+			slog.Warn("shim drifted from lock file", "drift", drift)
+		}
+
+		// The controller waits for the restart of nodes with a pending
+		// restart by verifying them.
+		socketPath := path.Join(config.Host.RootPath, config.Runtime.SocketPath)
+		restartPending, err := CheckPendingRestart(config, hostFs, containerd.NewCRIVerifier(socketPath, restartCheckTimeout))
+		if err != nil {
+			slog.Warn("failed to check for pending restart", "error", err)
+		}
+		if config.Kwasm.TerminationLogPath == "" {
+			return
+		}
+		if err := termination.Write(config.Kwasm.TerminationLogPath, termination.Message{Drift: drift, RestartPending: restartPending}); err != nil {
+			slog.Warn("failed to write termination message", "path", config.Kwasm.TerminationLogPath, "error", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	statusCmd.Flags().StringVarP(&statusOutput, "output", "o", OutputTable, "Output format (table, json)")
+	rootCmd.AddCommand(statusCmd)
+}
+
+// Status describes the shims installed on a node.
+type Status struct {
+	ConfigPath     string       `json:"configPath"`
+	RestartPending bool         `json:"restartPending"`
+	Shims          []ShimStatus `json:"shims"`
+}
+
+// ShimStatus compares a shim recorded in the lock file with the node.
+type ShimStatus struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Sha256 string `json:"sha256"`
+	// Handler is the runtime handler of the shim in the containerd config.
+	Handler string `json:"handler,omitempty"`
+	// Source and InstalledAt are only known for shims installed by
+	// node-installer versions that record them in the lock file.
+	Source      *state.Source `json:"source,omitempty"`
+	InstalledAt *time.Time    `json:"installedAt,omitempty"`
+	// BinaryMissing is set if the shim binary does not exist anymore.
+	BinaryMissing bool `json:"binaryMissing"`
+	// BinaryModified is set if the hash of the shim binary differs from
+	// the one in the lock file.
+	BinaryModified bool `json:"binaryModified"`
+	// ConfigMissing is set if the runtime entry of the shim is missing in
+	// the containerd config.
+	ConfigMissing bool `json:"configMissing"`
+}
+
+// Drifted returns whether the shim on the node differs from the lock file.
+func (s ShimStatus) Drifted() bool {
+	return len(s.Drift()) > 0
+}
+
+// Drift lists how the shim on the node differs from the lock file.
+func (s ShimStatus) Drift() []string {
+	var drift []string
+	if s.BinaryMissing {
+		drift = append(drift, DriftBinaryMissing)
+	}
+	if s.BinaryModified {
+		drift = append(drift, DriftBinaryModified)
+	}
+	if s.ConfigMissing {
+		drift = append(drift, DriftConfigMissing)
+	}
+	return drift
+}
+
+// VerifyShim returns how the shim config.Runtime.Name on the node differs
+// from the lock file. It is empty if the shim is in sync.
+func VerifyShim(config Config, hostFs afero.Fs) ([]string, error) {
+	status, err := GetStatus(config, hostFs)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range status.Shims {
+		if s.Name == config.Runtime.Name {
+			return s.Drift(), nil
+		}
+	}
+	return []string{DriftNotInstalled}, nil
+}
+
+// MarshalJSON adds the drift summary to the JSON output.
+func (s ShimStatus) MarshalJSON() ([]byte, error) {
+	type shimStatus ShimStatus
+	return json.Marshal(struct {
+		shimStatus
+		Drifted bool `json:"drifted"`
+	}{shimStatus(s), s.Drifted()})
+}
+
+// GetStatus checks every shim in the lock file against its binary and the
+// containerd config.
+func GetStatus(config Config, hostFs afero.Fs) (*Status, error) {
+	st, err := state.Get(hostFs, config.Kwasm.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock file: %w", err)
+	}
+
+	restartPending, err := isRestartPending(hostFs, config.Kwasm.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{
+		ConfigPath:     config.Runtime.ConfigPath,
+		RestartPending: restartPending,
+		Shims:          []ShimStatus{},
+	}
+	containerdConfig := newContainerdConfig(config, hostFs, nil)
+
+	for name, shim := range st.Shims {
+		shimStatus := ShimStatus{
+			Name:    name,
+			Path:    shim.Path,
+			Sha256:  hex.EncodeToString(shim.Sha256),
+			Handler: shim.Handler,
+		}
+		if shim.Source != (state.Source{}) {
+			shimStatus.Source = &shim.Source
+		}
+		if !shim.InstalledAt.IsZero() {
+			shimStatus.InstalledAt = &shim.InstalledAt
+		}
+
+		sum, err := fileSha256(hostFs, shim.Path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			shimStatus.BinaryMissing = true
+		case err != nil:
+			return nil, fmt.Errorf("failed to hash shim '%s': %w", name, err)
+		default:
+			shimStatus.BinaryModified = sum != shimStatus.Sha256
+		}
+
+		configured, err := containerdConfig.HasRuntime(shim.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read containerd config: %w", err)
+		}
+		shimStatus.ConfigMissing = !configured
+
+		status.Shims = append(status.Shims, shimStatus)
+	}
+	slices.SortFunc(status.Shims, func(a, b ShimStatus) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return status, nil
+}
+
+func fileSha256(fs afero.Fs, path string) (string, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// WriteStatus writes the status as a table or as JSON.
+func WriteStatus(w io.Writer, status *Status, output string) error {
+	if output == OutputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(status)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0) //nolint:mnd // column padding
+	fmt.Fprintln(tw, "NAME\tPATH\tSHA256\tBINARY\tCONFIG\tDRIFTED")
+	for _, s := range status.Shims {
+		binary := "ok"
+		switch {
+		case s.BinaryMissing:
+			binary = "missing"
+		case s.BinaryModified:
+			binary = "modified"
+		}
+		cfg := "ok"
+		if s.ConfigMissing {
+			cfg = "missing"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.12s\t%s\t%s\t%t\n", s.Name, s.Path, s.Sha256, binary, cfg, s.Drifted())
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if status.RestartPending {
+		_, err := fmt.Fprintln(w, "containerd restart pending")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/spf13/afero"
+	main "github.com/spinkube/runtime-class-manager/cmd/node-installer"
+	tests "github.com/spinkube/runtime-class-manager/tests/node-installer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const spinV1Sha256 = "6da5e8f17a9bfa9cb04cf22c87b6475394ecec3af4fdc337f72d6dbf3319ea52"
+
+func Test_GetStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		hostFs      afero.Fs
+		modify      func(t *testing.T, hostFs afero.Fs)
+		want        []main.ShimStatus
+		wantDrifted bool
+	}{
+		{
+			"in sync",
+			tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"),
+			func(*testing.T, afero.Fs) {},
+			[]main.ShimStatus{{Name: "spin-v1", Path: "/opt/kwasm/bin/containerd-shim-spin-v1", Sha256: spinV1Sha256, Handler: "spin-v1"}},
+			false,
+		},
+		{
+			"binary missing",
+			tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"),
+			func(t *testing.T, hostFs afero.Fs) {
+				require.NoError(t, hostFs.Remove("/opt/kwasm/bin/containerd-shim-spin-v1"))
+			},
+			[]main.ShimStatus{{Name: "spin-v1", Path: "/opt/kwasm/bin/containerd-shim-spin-v1", Sha256: spinV1Sha256, Handler: "spin-v1", BinaryMissing: true}},
+			true,
+		},
+		{
+			"binary modified",
+			tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"),
+			func(t *testing.T, hostFs afero.Fs) {
+				require.NoError(t, afero.WriteFile(hostFs, "/opt/kwasm/bin/containerd-shim-spin-v1", []byte("other"), 0o755))
+			},
+			[]main.ShimStatus{{Name: "spin-v1", Path: "/opt/kwasm/bin/containerd-shim-spin-v1", Sha256: spinV1Sha256, Handler: "spin-v1", BinaryModified: true}},
+			true,
+		},
+		{
+			"config overwritten",
+			tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"),
+			func(t *testing.T, hostFs afero.Fs) {
+				require.NoError(t, afero.WriteFile(hostFs, "/etc/containerd/config.toml", []byte("version = 2\n"), 0o644))
+			},
+			[]main.ShimStatus{{Name: "spin-v1", Path: "/opt/kwasm/bin/containerd-shim-spin-v1", Sha256: spinV1Sha256, Handler: "spin-v1", ConfigMissing: true}},
+			true,
+		},
+		{
+			"nothing installed",
+			tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-shim-config"),
+			func(*testing.T, afero.Fs) {},
+			[]main.ShimStatus{},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.modify(t, tt.hostFs)
+
+			status, err := main.GetStatus(testConfig("/etc/containerd/config.toml", ""), tt.hostFs)
+			require.NoError(t, err)
+			assert.Equal(t, "/etc/containerd/config.toml", status.ConfigPath)
+			assert.Equal(t, tt.want, status.Shims)
+			for _, s := range status.Shims {
+				assert.Equal(t, tt.wantDrifted, s.Drifted())
+			}
+		})
+	}
+}
+
+func Test_VerifyShim(t *testing.T) {
+	tests := []struct {
+		name      string
+		shim      string
+		hostFs    afero.Fs
+		modify    func(t *testing.T, hostFs afero.Fs)
+		wantDrift []string
+	}{
+		{
+			"in sync",
+			"spin-v1",
+			tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"),
+			func(*testing.T, afero.Fs) {},
+			nil,
+		},
+		{
+			"not installed",
+			"slight-v1",
+			tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"),
+			func(*testing.T, afero.Fs) {},
+			[]string{main.DriftNotInstalled},
+		},
+		{
+			"binary missing and config overwritten",
+			"spin-v1",
+			tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"),
+			func(t *testing.T, hostFs afero.Fs) {
+				require.NoError(t, hostFs.Remove("/opt/kwasm/bin/containerd-shim-spin-v1"))
+				require.NoError(t, afero.WriteFile(hostFs, "/etc/containerd/config.toml", []byte("version = 2\n"), 0o644))
+			},
+			[]string{main.DriftBinaryMissing, main.DriftConfigMissing},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.modify(t, tt.hostFs)
+			config := testConfig("/etc/containerd/config.toml", "")
+			config.Runtime.Name = tt.shim
+
+			drift, err := main.VerifyShim(config, tt.hostFs)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantDrift, drift)
+		})
+	}
+}
+
+func Test_RunInstallRemediatesDrift(t *testing.T) {
+	rootFs := tests.FixtureFs("../../testdata/node-installer")
+
+	tests := []struct {
+		name      string
+		hostFs    afero.Fs
+		modify    func(t *testing.T, hostFs afero.Fs)
+		wantDrift []string
+	}{
+		{
+			"binary missing",
+			tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"),
+			func(t *testing.T, hostFs afero.Fs) {
+				require.NoError(t, hostFs.Remove("/opt/kwasm/bin/containerd-shim-spin-v1"))
+			},
+			[]string{main.DriftBinaryMissing},
+		},
+		{
+			"binary modified",
+			tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"),
+			func(t *testing.T, hostFs afero.Fs) {
+				require.NoError(t, afero.WriteFile(hostFs, "/opt/kwasm/bin/containerd-shim-spin-v1", []byte("modified"), 0o755))
+			},
+			[]string{main.DriftBinaryModified},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig("/etc/containerd/config.toml", "")
+			config.Kwasm.AssetPath = "/assets/containerd-shim-spin-v1"
+			verifyConfig := config
+			verifyConfig.Runtime.Name = "spin-v1"
+
+			tt.modify(t, tt.hostFs)
+			drift, err := main.VerifyShim(verifyConfig, tt.hostFs)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantDrift, drift)
+
+			require.NoError(t, main.RunInstall(context.Background(), config, rootFs, tt.hostFs, nullRestarter{}))
+
+			drift, err = main.VerifyShim(verifyConfig, tt.hostFs)
+			require.NoError(t, err)
+			assert.Empty(t, drift, "reinstall replaces the drifted binary")
+		})
+	}
+}
+
+func Test_WriteStatus(t *testing.T) {
+	status := &main.Status{
+		ConfigPath:     "/etc/containerd/config.toml",
+		RestartPending: true,
+		Shims: []main.ShimStatus{
+			{Name: "spin-v1", Path: "/opt/kwasm/bin/containerd-shim-spin-v1", Sha256: spinV1Sha256, Handler: "spin-v1", ConfigMissing: true},
+		},
+	}
+
+	t.Run("table", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, main.WriteStatus(&out, status, main.OutputTable))
+		assert.Equal(t, `NAME     PATH                                    SHA256        BINARY  CONFIG   DRIFTED
+spin-v1  /opt/kwasm/bin/containerd-shim-spin-v1  6da5e8f17a9b  ok      missing  true
+containerd restart pending
+`, out.String())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, main.WriteStatus(&out, status, main.OutputJSON))
+		assert.JSONEq(t, `{
+			"configPath": "/etc/containerd/config.toml",
+			"restartPending": true,
+			"shims": [{
+				"name": "spin-v1",
+				"path": "/opt/kwasm/bin/containerd-shim-spin-v1",
+				"sha256": "`+spinV1Sha256+`",
+				"handler": "spin-v1",
+				"binaryMissing": false,
+				"binaryModified": false,
+				"configMissing": true,
+				"drifted": true
+			}]
+		}`, out.String())
+	})
+}
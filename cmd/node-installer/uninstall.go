@@ -17,51 +17,91 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
-	"os"
 	"path"
 
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 
 	"github.com/spinkube/runtime-class-manager/internal/containerd"
+	"github.com/spinkube/runtime-class-manager/internal/preset"
 	"github.com/spinkube/runtime-class-manager/internal/shim"
+	"github.com/spinkube/runtime-class-manager/internal/state"
+	"github.com/spinkube/runtime-class-manager/internal/termination"
+	"github.com/spinkube/runtime-class-manager/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // uninstallCmd represents the uninstall command.
 var uninstallCmd = &cobra.Command{
 	Use:   "uninstall",
 	Short: "Uninstall containerd shims",
-	Run: func(_ *cobra.Command, _ []string) {
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx, span := tracing.Start(cmd.Context(), "node-installer uninstall", attribute.String("shim", config.Runtime.Name))
+		defer span.End()
+
 		rootFs := afero.NewOsFs()
 		hostFs := afero.NewBasePathFs(rootFs, config.Host.RootPath)
 
-		distro, err := DetectDistro(config, hostFs)
+		distro, err := DetectDistro(ctx, config, hostFs)
+		if err != nil {
+			exitWithFailure(ctx, config, distro, termination.FailureDistroDetection, "failed to detect containerd config", err)
+		}
+
+		restarter, err := SelectRestarter(config, distro)
 		if err != nil {
-			slog.Error("failed to detect containerd config", "error", err)
-			os.Exit(1)
+			exitWithFailure(ctx, config, distro, termination.FailureInvalidConfig, "failed to select restarter", err)
 		}
 
 		config.Runtime.ConfigPath = distro.ConfigPath
+		if config.Runtime.SocketPath == "" {
+			config.Runtime.SocketPath = distro.SocketPath
+		}
+
+		if config.DryRun {
+			preflight, err := DryRun(config, hostFs, distro, func(config Config, hostFs afero.Fs, restarter containerd.Restarter) error {
+				return RunUninstall(ctx, config, rootFs, hostFs, restarter)
+			})
+			if err != nil {
+				exitWithFailure(ctx, config, distro, termination.FailureUninstall, "failed to dry run uninstall", err)
+			}
+			reportPreflight(config, preflight)
+			return
+		}
 
-		if err := RunUninstall(config, rootFs, hostFs, distro.Restarter); err != nil {
-			slog.Error("failed to uninstall", "error", err)
-			os.Exit(1)
+		if err = distro.Setup(preset.Env{ConfigPath: distro.ConfigPath, HostFs: hostFs}); err != nil {
+			exitWithFailure(ctx, config, distro, termination.FailureDistroSetup, "failed to run distro setup", err)
+		}
+
+		if err := RunUninstall(ctx, config, rootFs, hostFs, restarter); err != nil {
+			exitWithFailure(ctx, config, distro, termination.FailureUninstall, "failed to uninstall", err)
 		}
 	},
 }
 
 func init() {
+	uninstallCmd.Flags().BoolVar(&config.DryRun, "dry-run", false, "Print the changes the uninstall would make without changing anything")
 	rootCmd.AddCommand(uninstallCmd)
 }
 
-func RunUninstall(config Config, rootFs, hostFs afero.Fs, restarter containerd.Restarter) error {
-	slog.Info("uninstall called", "shim", config.Runtime.Name)
-	shimName := config.Runtime.Name
+func RunUninstall(ctx context.Context, config Config, rootFs, hostFs afero.Fs, restarter containerd.Restarter) (err error) {
+	ctx, span := tracing.Start(ctx, "uninstall", attribute.String("shim", config.Runtime.Name))
+	defer func() { tracing.End(span, err) }()
+
+	slog.Info("uninstall called")
+	unlock, err := state.Lock(hostFs, config.Kwasm.Path, config.Kwasm.LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock() //nolint:errcheck // closing the lock file releases the lock
+
+	// The lock file records shims by the runtime handler install configured.
+	shimName := shim.RuntimeName(config.Runtime.Name)
 	runtimeName := path.Join(config.Kwasm.Path, "bin", shimName)
 
-	containerdConfig := containerd.NewConfig(hostFs, config.Runtime.ConfigPath, restarter)
+	containerdConfig := newContainerdConfig(config, hostFs, restarter)
 	shimConfig := shim.NewConfig(rootFs, hostFs, config.Kwasm.AssetPath, config.Kwasm.Path)
 
 	binPath, err := shimConfig.Uninstall(shimName)
@@ -69,7 +109,11 @@ func RunUninstall(config Config, rootFs, hostFs afero.Fs, restarter containerd.R
 		return fmt.Errorf("failed to delete shim '%s': %w", runtimeName, err)
 	}
 
-	configChanged, err := containerdConfig.RemoveRuntime(binPath)
+	var configChanged bool
+	err = writeRuntimeConfig(ctx, shimName, func() (err error) {
+		configChanged, err = containerdConfig.RemoveRuntime(binPath)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to write containerd config for shim '%s': %w", runtimeName, err)
 	}
@@ -79,11 +123,9 @@ func RunUninstall(config Config, rootFs, hostFs afero.Fs, restarter containerd.R
 		return nil
 	}
 
-	slog.Info("restarting containerd")
-	err = containerdConfig.RestartRuntime()
-	if err != nil {
-		return fmt.Errorf("failed to restart containerd: %w", err)
+	if err := restartRuntime(ctx, containerdConfig); err != nil {
+		return err
 	}
 
-	return nil
+	return clearRestartPending(hostFs, config.Kwasm.Path)
 }
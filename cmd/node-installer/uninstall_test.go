@@ -0,0 +1,59 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/afero"
+	main "github.com/spinkube/runtime-class-manager/cmd/node-installer"
+	"github.com/spinkube/runtime-class-manager/internal/state"
+	tests "github.com/spinkube/runtime-class-manager/tests/node-installer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RunUninstall(t *testing.T) {
+	rootFs := tests.FixtureFs("../../testdata/node-installer")
+
+	tests := []struct {
+		name    string
+		runtime string
+		hostFs  afero.Fs
+	}{
+		{"runtime handler", "spin-v1", tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config")},
+		{"shim binary name", "containerd-shim-spin-v1", tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig("/etc/containerd/config.toml", "")
+			config.Runtime.Name = tt.runtime
+			restarter := &countingRestarter{}
+
+			require.NoError(t, main.RunUninstall(context.Background(), config, rootFs, tt.hostFs, restarter))
+
+			st, err := state.Get(tt.hostFs, "/opt/kwasm")
+			require.NoError(t, err)
+			assert.NotContains(t, st.Shims, "spin-v1")
+			runtimeConfig, err := afero.ReadFile(tt.hostFs, "/etc/containerd/config.toml")
+			require.NoError(t, err)
+			assert.NotContains(t, string(runtimeConfig), "runtimes.spin-v1")
+			assert.Equal(t, 1, restarter.calls)
+		})
+	}
+}
@@ -17,11 +17,15 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"log/slog"
 	"os"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
+	"github.com/go-logr/logr"
 	"github.com/prometheus/common/version"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
@@ -30,11 +34,12 @@ import (
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
-	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	runtimev1alpha1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
 	"github.com/spinkube/runtime-class-manager/internal/controller"
+	"github.com/spinkube/runtime-class-manager/internal/logging"
+	"github.com/spinkube/runtime-class-manager/internal/tracing"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -54,18 +59,33 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var logFormat string
+	var logLevel string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8082", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
-	opts := zap.Options{
-		Development: true,
-	}
-	opts.BindFlags(flag.CommandLine)
+	flag.StringVar(&logFormat, "log-format", logging.FormatText, "The format of log records, one of text or json.")
+	flag.StringVar(&logLevel, "log-level", "info", "The minimum level of log records, one of debug, info, warn or error.")
 	flag.Parse()
 
-	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	handler, err := logging.NewHandler(os.Stderr, logFormat, logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	slog.SetDefault(slog.New(handler))
+	ctrl.SetLogger(logr.FromSlogHandler(handler))
+
+	config, err := controller.LoadConfig(os.Getenv)
+	if err != nil {
+		setupLog.Error(err, "invalid controller config")
+		os.Exit(1)
+	}
+	config.LogFormat = logFormat
+	config.LogLevel = logLevel
+	setupLog.Info("shims are installed in " + config.InstallMode + " mode")
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
@@ -91,8 +111,10 @@ func main() {
 	}
 
 	if err = (&controller.ShimReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("shim-controller"),
+		Config:   config,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Shim")
 		os.Exit(1)
@@ -105,8 +127,9 @@ func main() {
 	// 	os.Exit(1)
 	// }
 	if err = (&controller.JobReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("job-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Job")
 		os.Exit(1)
@@ -122,10 +145,20 @@ func main() {
 		os.Exit(1)
 	}
 
+	shutdownTracing, err := tracing.Setup(context.Background(), "runtime-class-manager")
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+	setupLog.Info("tracing", "enabled", tracing.Enabled())
+
 	setupLog.Info("starting manager")
 	setupLog.Info("version", "version", version.Version, "branch", version.Branch, "revision", version.Revision, "builddate", version.BuildDate)
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		setupLog.Error(err, "unable to flush traces")
+	}
 }
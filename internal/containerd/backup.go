@@ -0,0 +1,148 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package containerd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// maxBackups is the number of containerd config backups kept per config file.
+const maxBackups = 5
+
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+var now = time.Now
+
+// backupConfig copies the current containerd config into the backup
+// directory. Only the first call per Config takes a backup, so that a
+// rollback always restores the config as it was before this run.
+func (c *Config) backupConfig() error {
+	if c.backup != "" || c.backupPath == "" {
+		return nil
+	}
+
+	data, err := afero.ReadFile(c.hostFs, c.configPath)
+	if err != nil {
+		return err
+	}
+
+	if err := c.hostFs.MkdirAll(c.backupPath, 0o755); err != nil { //nolint:mnd // file permissions
+		return err
+	}
+
+	prefix := path.Base(c.configPath) + "."
+	backup := path.Join(c.backupPath, prefix+now().UTC().Format(backupTimeFormat))
+	if err := writeFileAtomic(c.hostFs, backup, data); err != nil {
+		return err
+	}
+	c.backup = backup
+	slog.Info("backed up containerd config", "config", c.configPath, "backup", backup)
+
+	c.pruneBackups(prefix)
+
+	return nil
+}
+
+// restoreConfig atomically replaces the containerd config with the backup
+// taken by backupConfig.
+func (c *Config) restoreConfig() error {
+	data, err := afero.ReadFile(c.hostFs, c.backup)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(c.hostFs, c.configPath, data)
+}
+
+// pruneBackups removes all but the newest maxBackups backups with the given
+// prefix. Failures are logged only, as stale backups are harmless.
+func (c *Config) pruneBackups(prefix string) {
+	entries, err := afero.ReadDir(c.hostFs, c.backupPath)
+	if err != nil {
+		slog.Warn("failed to list containerd config backups", "path", c.backupPath, "error", err)
+		return
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), prefix) {
+			backups = append(backups, entry.Name())
+		}
+	}
+	if len(backups) <= maxBackups {
+		return
+	}
+
+	// The timestamp format sorts lexically in chronological order.
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-maxBackups] {
+		if err := c.hostFs.Remove(path.Join(c.backupPath, name)); err != nil {
+			slog.Warn("failed to remove old containerd config backup", "backup", name, "error", err)
+		}
+	}
+}
+
+// writeFileAtomic writes data to a temporary file next to filePath and
+// renames it into place, so that readers never observe a partially written
+// file. The mode of an existing file is preserved.
+func writeFileAtomic(fs afero.Fs, filePath string, data []byte) error {
+	mode := os.FileMode(0o644) //nolint:mnd // file permissions
+	if info, err := fs.Stat(filePath); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := afero.TempFile(fs, path.Dir(filePath), "."+path.Base(filePath)+".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	if err := writeAndSync(tmp, data); err != nil {
+		_ = fs.Remove(tmpPath)
+		return err
+	}
+	if err := fs.Chmod(tmpPath, mode); err != nil {
+		_ = fs.Remove(tmpPath)
+		return err
+	}
+	if err := fs.Rename(tmpPath, filePath); err != nil {
+		_ = fs.Remove(tmpPath)
+		return fmt.Errorf("failed to move %s into place: %w", filePath, err)
+	}
+
+	return nil
+}
+
+func writeAndSync(f afero.File, data []byte) error {
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,94 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package containerd //nolint:testpackage // whitebox test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	tests "github.com/spinkube/runtime-class-manager/tests/node-installer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_backupConfig(t *testing.T) {
+	hostFs := tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config")
+	original, err := afero.ReadFile(hostFs, "/etc/containerd/config.toml")
+	require.NoError(t, err)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	calls := 0
+	now = func() time.Time {
+		calls++
+		return start.Add(time.Duration(calls) * time.Second)
+	}
+	t.Cleanup(func() { now = time.Now })
+
+	for range maxBackups + 2 {
+		c := NewConfig(hostFs, "/etc/containerd/config.toml", "/opt/kwasm", nil)
+		require.NoError(t, c.backupConfig())
+		// only the first backup of a run is kept
+		first := c.backup
+		require.NoError(t, c.backupConfig())
+		assert.Equal(t, first, c.backup)
+
+		content, err := afero.ReadFile(hostFs, c.backup)
+		require.NoError(t, err)
+		assert.Equal(t, string(original), string(content))
+	}
+
+	entries, err := afero.ReadDir(hostFs, "/opt/kwasm/backup")
+	require.NoError(t, err)
+	require.Len(t, entries, maxBackups)
+	assert.Equal(t, "config.toml.20240101T000003.000000000Z", entries[0].Name())
+	assert.Equal(t, "config.toml.20240101T000007.000000000Z", entries[maxBackups-1].Name())
+}
+
+func Test_writeFileAtomic(t *testing.T) {
+	tests := []struct {
+		name    string
+		fs      afero.Fs
+		path    string
+		wantErr bool
+	}{
+		{"replace existing file", tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"), "/etc/containerd/config.toml", false},
+		{"create new file", tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-config"), "/etc/containerd/config.toml", false},
+		{"read-only fs", afero.NewReadOnlyFs(tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config")), "/etc/containerd/config.toml", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := writeFileAtomic(tt.fs, tt.path, []byte("version = 2\n"))
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			content, err := afero.ReadFile(tt.fs, tt.path)
+			require.NoError(t, err)
+			assert.Equal(t, "version = 2\n", string(content))
+
+			// no temporary files are left behind
+			entries, err := afero.ReadDir(tt.fs, "/etc/containerd")
+			require.NoError(t, err)
+			for _, entry := range entries {
+				assert.NotContains(t, entry.Name(), ".tmp-")
+			}
+		})
+	}
+}
@@ -17,10 +17,12 @@
 package containerd
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"log/slog"
-	"os"
 	"path"
+	"regexp"
 	"strings"
 
 	"github.com/spf13/afero"
@@ -34,17 +36,33 @@ type Restarter interface {
 type Config struct {
 	hostFs     afero.Fs
 	configPath string
+	backupPath string
 	restarter  Restarter
+	verifier   Verifier
+	// handlers are the runtime handlers added in this run, which need to be
+	// served by containerd after the restart.
+	handlers []string
+	// backup is the path of the backup taken before the first change to
+	// the containerd config in this run. It is restored if containerd does
+	// not come back healthy after a restart.
+	backup string
 }
 
-func NewConfig(hostFs afero.Fs, configPath string, restarter Restarter) *Config {
+func NewConfig(hostFs afero.Fs, configPath string, kwasmPath string, restarter Restarter) *Config {
 	return &Config{
 		hostFs:     hostFs,
 		configPath: configPath,
+		backupPath: path.Join(kwasmPath, "backup"),
 		restarter:  restarter,
 	}
 }
 
+// WithVerifier sets the Verifier used to check containerd after a restart.
+func (c *Config) WithVerifier(verifier Verifier) *Config {
+	c.verifier = verifier
+	return c
+}
+
 func (c *Config) AddRuntime(shimPath string) error {
 	runtimeName := shim.RuntimeName(path.Base(shimPath))
 	l := slog.With("runtime", runtimeName)
@@ -57,26 +75,33 @@ func (c *Config) AddRuntime(shimPath string) error {
 		return err
 	}
 
-	// Warn if config.toml already contains runtimeName
-	if strings.Contains(string(data), runtimeName) {
+	c.handlers = append(c.handlers, runtimeName)
+
+	if strings.Contains(string(data), cfg) {
 		l.Info("runtime config already exists, skipping")
 		return nil
 	}
 
-	// Open file in append mode
-	file, err := c.hostFs.OpenFile(c.configPath, os.O_APPEND|os.O_WRONLY, 0o644) //nolint:mnd // file permissions
-	if err != nil {
-		return err
+	// A runtime config written for another version of the shim is pointed
+	// to the shim at shimPath.
+	existing := runtimeConfigPattern(runtimeName).Find(data)
+	if existing == nil && strings.Contains(string(data), runtimeName) {
+		// Warn if config.toml already contains runtimeName
+		l.Info("runtime config already exists, skipping")
+		return nil
 	}
-	defer file.Close()
 
-	// Append config
-	_, err = file.WriteString(cfg)
-	if err != nil {
-		return err
+	if err := c.backupConfig(); err != nil {
+		return fmt.Errorf("failed to back up containerd config: %w", err)
 	}
 
-	return nil
+	if existing != nil {
+		l.Info("updating runtime config", "path", shimPath)
+		return writeFileAtomic(c.hostFs, c.configPath, bytes.Replace(data, existing, []byte(cfg), 1))
+	}
+
+	// Append config
+	return writeFileAtomic(c.hostFs, c.configPath, append(data, cfg...))
 }
 
 func (c *Config) RemoveRuntime(shimPath string) (changed bool, err error) {
@@ -99,9 +124,17 @@ func (c *Config) RemoveRuntime(shimPath string) (changed bool, err error) {
 
 	// Convert the file data to a string and replace the target string with an empty string.
 	modifiedData := strings.ReplaceAll(string(data), cfg, "")
+	if modifiedData == string(data) {
+		l.Warn("runtime config differs from the generated one, skipping")
+		return false, nil
+	}
+
+	if err := c.backupConfig(); err != nil {
+		return false, fmt.Errorf("failed to back up containerd config: %w", err)
+	}
 
 	// Write the modified data back to the file.
-	err = afero.WriteFile(c.hostFs, c.configPath, []byte(modifiedData), 0o644) //nolint:mnd // file permissions
+	err = writeFileAtomic(c.hostFs, c.configPath, []byte(modifiedData))
 	if err != nil {
 		return false, err
 	}
@@ -109,8 +142,54 @@ func (c *Config) RemoveRuntime(shimPath string) (changed bool, err error) {
 	return true, nil
 }
 
+// HasRuntime returns whether the runtime config for the shim at shimPath is
+// present in the containerd config.
+func (c *Config) HasRuntime(shimPath string) (bool, error) {
+	runtimeName := shim.RuntimeName(path.Base(shimPath))
+
+	data, err := afero.ReadFile(c.hostFs, c.configPath)
+	if err != nil {
+		return false, err
+	}
+
+	return strings.Contains(string(data), generateConfig(shimPath, runtimeName)), nil
+}
+
+// RestartRuntime restarts containerd to pick up the changed config. If the
+// restart fails or containerd does not come back healthy with all added
+// runtime handlers, the config backup taken before the first change is
+// restored and containerd is restarted again with the previous config.
 func (c *Config) RestartRuntime() error {
-	return c.restarter.Restart()
+	err := c.restarter.Restart()
+	if err == nil && c.verifier != nil {
+		err = c.verifier.Verify(c.handlers)
+	}
+	if err == nil {
+		return nil
+	}
+
+	if c.backup == "" {
+		return err
+	}
+
+	slog.Warn("containerd did not restart cleanly, restoring previous config", "backup", c.backup, "error", err)
+	if rerr := c.restoreConfig(); rerr != nil {
+		return errors.Join(err, fmt.Errorf("failed to restore containerd config from %s: %w", c.backup, rerr))
+	}
+	if rerr := c.restarter.Restart(); rerr != nil {
+		return errors.Join(err, fmt.Errorf("failed to restart containerd with restored config: %w", rerr))
+	}
+
+	return fmt.Errorf("restored previous containerd config from %s: %w", c.backup, err)
+}
+
+// runtimeConfigPattern matches the runtime config generated for runtimeName,
+// regardless of the shim path.
+func runtimeConfigPattern(runtimeName string) *regexp.Regexp {
+	return regexp.MustCompile(regexp.QuoteMeta(fmt.Sprintf(`
+# KWASM runtime config for %s
+[plugins."io.containerd.grpc.v1.cri".containerd.runtimes.%s]
+`, runtimeName, runtimeName)) + `runtime_type = "[^"\n]*"\n`)
 }
 
 func generateConfig(shimPath string, runtimeName string) string {
@@ -17,6 +17,7 @@
 package containerd //nolint:testpackage // whitebox test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/spf13/afero"
@@ -93,6 +94,31 @@ runtime_type = "/opt/kwasm/bin/containerd-shim-spin-v1"
 # KWASM runtime config for spin-v1
 [plugins."io.containerd.grpc.v1.cri".containerd.runtimes.spin-v1]
 runtime_type = "/opt/kwasm/bin/containerd-shim-spin-v1"
+`},
+		{"existing shim config of another version", fields{
+			hostFs:     tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"),
+			configPath: "/etc/containerd/config.toml",
+		}, args{"/opt/kwasm/bin/spin-v1/0123/containerd-shim-spin-v1"}, false, `[plugins]
+  [plugins."io.containerd.monitor.v1.cgroups"]
+    no_prometheus = false
+  [plugins."io.containerd.service.v1.diff-service"]
+    default = ["walking"]
+  [plugins."io.containerd.gc.v1.scheduler"]
+    pause_threshold = 0.02
+    deletion_threshold = 0
+    mutation_threshold = 100
+    schedule_delay = 0
+    startup_delay = "100ms"
+  [plugins."io.containerd.runtime.v2.task"]
+    platforms = ["linux/amd64"]
+    sched_core = true
+  [plugins."io.containerd.service.v1.tasks-service"]
+    blockio_config_file = ""
+    rdt_config_file = ""
+
+# KWASM runtime config for spin-v1
+[plugins."io.containerd.grpc.v1.cri".containerd.runtimes.spin-v1]
+runtime_type = "/opt/kwasm/bin/spin-v1/0123/containerd-shim-spin-v1"
 `},
 	}
 	for _, tt := range tests {
@@ -100,6 +126,7 @@ runtime_type = "/opt/kwasm/bin/containerd-shim-spin-v1"
 			c := &Config{
 				hostFs:     tt.fields.hostFs,
 				configPath: tt.fields.configPath,
+				backupPath: "/opt/kwasm/backup",
 			}
 			err := c.AddRuntime(tt.args.shimPath)
 
@@ -126,17 +153,21 @@ func TestConfig_RemoveRuntime(t *testing.T) {
 	type args struct {
 		shimPath string
 	}
+	existingConfig, err := afero.ReadFile(tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"), "/etc/containerd/config.toml")
+	require.NoError(t, err)
+
 	tests := []struct {
 		name            string
 		fields          fields
 		args            args
 		wantErr         bool
+		wantChanged     bool
 		wantFileContent string
 	}{
 		{"missing shim config", fields{
 			hostFs:     tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-shim-config"),
 			configPath: "/etc/containerd/config.toml",
-		}, args{"/opt/kwasm/bin/containerd-shim-spin-v1"}, false, `[plugins]
+		}, args{"/opt/kwasm/bin/containerd-shim-spin-v1"}, false, false, `[plugins]
   [plugins."io.containerd.monitor.v1.cgroups"]
     no_prometheus = false
   [plugins."io.containerd.service.v1.diff-service"]
@@ -157,11 +188,11 @@ func TestConfig_RemoveRuntime(t *testing.T) {
 		{"missing config", fields{
 			hostFs:     tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-config"),
 			configPath: "/etc/containerd/config.toml",
-		}, args{"/opt/kwasm/bin/containerd-shim-spin-v1"}, true, ``},
+		}, args{"/opt/kwasm/bin/containerd-shim-spin-v1"}, true, false, ``},
 		{"existing shim config", fields{
 			hostFs:     tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"),
 			configPath: "/etc/containerd/config.toml",
-		}, args{"/opt/kwasm/bin/containerd-shim-spin-v1"}, false, `[plugins]
+		}, args{"/opt/kwasm/bin/containerd-shim-spin-v1"}, false, true, `[plugins]
   [plugins."io.containerd.monitor.v1.cgroups"]
     no_prometheus = false
   [plugins."io.containerd.service.v1.diff-service"]
@@ -179,6 +210,10 @@ func TestConfig_RemoveRuntime(t *testing.T) {
     blockio_config_file = ""
     rdt_config_file = ""
 `},
+		{"shim config of another path", fields{
+			hostFs:     tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"),
+			configPath: "/etc/containerd/config.toml",
+		}, args{"/opt/kwasm/bin/spin-v1/abc/containerd-shim-spin-v1"}, false, false, string(existingConfig)},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -186,13 +221,14 @@ func TestConfig_RemoveRuntime(t *testing.T) {
 				hostFs:     tt.fields.hostFs,
 				configPath: tt.fields.configPath,
 			}
-			_, err := c.RemoveRuntime(tt.args.shimPath)
+			changed, err := c.RemoveRuntime(tt.args.shimPath)
 			if tt.wantErr {
 				require.Error(t, err)
 				return
 			}
 
 			require.NoError(t, err)
+			assert.Equal(t, tt.wantChanged, changed)
 
 			gotContent, err := afero.ReadFile(c.hostFs, c.configPath)
 			require.NoError(t, err)
@@ -201,3 +237,128 @@ func TestConfig_RemoveRuntime(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_HasRuntime(t *testing.T) {
+	tests := []struct {
+		name     string
+		hostFs   afero.Fs
+		shimPath string
+		want     bool
+		wantErr  bool
+	}{
+		{
+			"configured",
+			tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"),
+			"/opt/kwasm/bin/containerd-shim-spin-v1",
+			true,
+			false,
+		},
+		{
+			"configured with other path",
+			tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config"),
+			"/usr/local/bin/containerd-shim-spin-v1",
+			false,
+			false,
+		},
+		{
+			"not configured",
+			tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-shim-config"),
+			"/opt/kwasm/bin/containerd-shim-spin-v1",
+			false,
+			false,
+		},
+		{
+			"missing config",
+			tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-config"),
+			"/opt/kwasm/bin/containerd-shim-spin-v1",
+			false,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewConfig(tt.hostFs, "/etc/containerd/config.toml", "/opt/kwasm", nil)
+
+			got, err := c.HasRuntime(tt.shimPath)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+type fakeRestarter struct {
+	errs  []error
+	calls int
+}
+
+func (r *fakeRestarter) Restart() error {
+	r.calls++
+	if len(r.errs) == 0 {
+		return nil
+	}
+	err := r.errs[0]
+	r.errs = r.errs[1:]
+	return err
+}
+
+type fakeVerifier struct {
+	err      error
+	handlers []string
+}
+
+func (v *fakeVerifier) Verify(handlers []string) error {
+	v.handlers = handlers
+	return v.err
+}
+
+func TestConfig_RestartRuntime(t *testing.T) {
+	tests := []struct {
+		name           string
+		hostFs         afero.Fs
+		restartErrs    []error
+		verifyErr      error
+		wantErr        bool
+		wantCalls      int
+		wantRolledBack bool
+	}{
+		{"healthy restart", tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-shim-config"), nil, nil, false, 1, false},
+		{"failed restart is rolled back", tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-shim-config"), []error{errors.New("containerd crashed")}, nil, true, 2, true},
+		{"unhealthy restart is rolled back", tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-shim-config"), nil, errors.New("handler not registered"), true, 2, true},
+		{"failed restart after rollback", tests.FixtureFs("../../testdata/node-installer/containerd/missing-containerd-shim-config"), []error{errors.New("containerd crashed"), errors.New("still broken")}, nil, true, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hostFs := tt.hostFs
+			original, err := afero.ReadFile(hostFs, "/etc/containerd/config.toml")
+			require.NoError(t, err)
+
+			restarter := &fakeRestarter{errs: tt.restartErrs}
+			verifier := &fakeVerifier{err: tt.verifyErr}
+			c := NewConfig(hostFs, "/etc/containerd/config.toml", "/opt/kwasm", restarter).WithVerifier(verifier)
+			require.NoError(t, c.AddRuntime("/opt/kwasm/bin/containerd-shim-spin-v1"))
+
+			err = c.RestartRuntime()
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantCalls, restarter.calls)
+			if len(tt.restartErrs) == 0 {
+				assert.Equal(t, []string{"spin-v1"}, verifier.handlers)
+			}
+
+			gotContent, err := afero.ReadFile(hostFs, "/etc/containerd/config.toml")
+			require.NoError(t, err)
+			if tt.wantRolledBack {
+				assert.Equal(t, string(original), string(gotContent))
+			} else {
+				assert.Contains(t, string(gotContent), "containerd.runtimes.spin-v1")
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package containerd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// fallbackRestarter tries a list of restarters in order and succeeds as soon
+// as one of them does.
+type fallbackRestarter struct {
+	restarters []Restarter
+}
+
+// NewFallbackRestarter returns a Restarter that tries each of the given
+// restarters in order until one of them succeeds. It only fails if all of
+// them fail.
+func NewFallbackRestarter(restarters ...Restarter) Restarter {
+	return fallbackRestarter{restarters: restarters}
+}
+
+func (f fallbackRestarter) String() string {
+	names := make([]string, 0, len(f.restarters))
+	for _, r := range f.restarters {
+		names = append(names, fmt.Sprint(r))
+	}
+	return "fallback(" + strings.Join(names, ", ") + ")"
+}
+
+func (f fallbackRestarter) Restart() error {
+	var errs []error
+	for _, r := range f.restarters {
+		err := r.Restart()
+		if err == nil {
+			return nil
+		}
+		slog.Warn("restart strategy failed, trying next", "strategy", fmt.Sprint(r), "error", err)
+		errs = append(errs, err)
+	}
+
+	return fmt.Errorf("all restart strategies failed: %w", errors.Join(errs...))
+}
@@ -0,0 +1,112 @@
+//go:build unix
+// +build unix
+
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+)
+
+// systemdTimeout is the time systemd has to restart a unit.
+var systemdTimeout = 2 * time.Minute
+
+type systemdConn interface {
+	ListUnitsByNamesContext(ctx context.Context, units []string) ([]dbus.UnitStatus, error)
+	RestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	Close()
+}
+
+// newSystemdConn connects to the system bus. The bus address can be set with
+// DBUS_SYSTEM_BUS_ADDRESS, e.g. to reach the host bus from within a container.
+var newSystemdConn = func(ctx context.Context) (systemdConn, error) {
+	return dbus.NewSystemConnectionContext(ctx)
+}
+
+type systemdRestarter struct {
+	units []string
+}
+
+// NewSystemdRestarter returns a Restarter that restarts the first active
+// unit of the given systemd units via D-Bus.
+func NewSystemdRestarter(units ...string) Restarter {
+	return systemdRestarter{units: units}
+}
+
+func (r systemdRestarter) String() string {
+	return "systemd(" + strings.Join(r.units, ", ") + ")"
+}
+
+func (r systemdRestarter) Restart() error {
+	ctx, cancel := context.WithTimeout(context.Background(), systemdTimeout)
+	defer cancel()
+
+	conn, err := newSystemdConn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to systemd: %w", err)
+	}
+	defer conn.Close()
+
+	unit, err := r.activeUnit(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("restarting systemd unit", "unit", unit)
+	done := make(chan string, 1)
+	if _, err := conn.RestartUnitContext(ctx, unit, "replace", done); err != nil {
+		return fmt.Errorf("failed to restart %s: %w", unit, err)
+	}
+
+	select {
+	case result := <-done:
+		if result != "done" {
+			return fmt.Errorf("restart of %s finished with result %q", unit, result)
+		}
+	case <-ctx.Done():
+		return fmt.Errorf("restart of %s did not finish: %w", unit, ctx.Err())
+	}
+
+	return nil
+}
+
+// activeUnit returns the first of the configured units that is active.
+func (r systemdRestarter) activeUnit(ctx context.Context, conn systemdConn) (string, error) {
+	statuses, err := conn.ListUnitsByNamesContext(ctx, r.units)
+	if err != nil {
+		return "", fmt.Errorf("failed to list systemd units: %w", err)
+	}
+
+	active := make(map[string]bool, len(statuses))
+	for _, status := range statuses {
+		active[status.Name] = status.ActiveState == "active"
+	}
+	for _, unit := range r.units {
+		if active[unit] {
+			return unit, nil
+		}
+	}
+
+	return "", fmt.Errorf("none of the systemd units %s is active", strings.Join(r.units, ", "))
+}
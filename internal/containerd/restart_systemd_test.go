@@ -0,0 +1,122 @@
+//go:build unix
+// +build unix
+
+package containerd //nolint:testpackage // whitebox test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockSystemdConn struct {
+	units     []dbus.UnitStatus
+	result    string
+	restartFn func(name string) error
+	restarted []string
+}
+
+func (c *mockSystemdConn) ListUnitsByNamesContext(_ context.Context, _ []string) ([]dbus.UnitStatus, error) {
+	return c.units, nil
+}
+
+func (c *mockSystemdConn) RestartUnitContext(_ context.Context, name string, _ string, ch chan<- string) (int, error) {
+	if c.restartFn != nil {
+		if err := c.restartFn(name); err != nil {
+			return 0, err
+		}
+	}
+	c.restarted = append(c.restarted, name)
+	ch <- c.result
+	return 1, nil
+}
+
+func (c *mockSystemdConn) Close() {}
+
+func Test_systemdRestarter_Restart(t *testing.T) {
+	tests := []struct {
+		name          string
+		units         []string
+		conn          *mockSystemdConn
+		wantRestarted []string
+		wantErr       bool
+	}{
+		{
+			"restart active unit",
+			[]string{"containerd.service"},
+			&mockSystemdConn{
+				units:  []dbus.UnitStatus{{Name: "containerd.service", ActiveState: "active"}},
+				result: "done",
+			},
+			[]string{"containerd.service"},
+			false,
+		},
+		{
+			"restart first active unit",
+			[]string{"k3s-agent.service", "k3s.service"},
+			&mockSystemdConn{
+				units: []dbus.UnitStatus{
+					{Name: "k3s-agent.service", ActiveState: "inactive"},
+					{Name: "k3s.service", ActiveState: "active"},
+				},
+				result: "done",
+			},
+			[]string{"k3s.service"},
+			false,
+		},
+		{
+			"no active unit",
+			[]string{"rke2-agent.service"},
+			&mockSystemdConn{
+				units:  []dbus.UnitStatus{{Name: "rke2-agent.service", LoadState: "not-found", ActiveState: "inactive"}},
+				result: "done",
+			},
+			nil,
+			true,
+		},
+		{
+			"restart job failed",
+			[]string{"containerd.service"},
+			&mockSystemdConn{
+				units:  []dbus.UnitStatus{{Name: "containerd.service", ActiveState: "active"}},
+				result: "failed",
+			},
+			[]string{"containerd.service"},
+			true,
+		},
+		{
+			"restart rejected",
+			[]string{"containerd.service"},
+			&mockSystemdConn{
+				units:     []dbus.UnitStatus{{Name: "containerd.service", ActiveState: "active"}},
+				restartFn: func(_ string) error { return errors.New("access denied") },
+			},
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newSystemdConn = func(_ context.Context) (systemdConn, error) {
+				return tt.conn, nil
+			}
+			t.Cleanup(func() {
+				newSystemdConn = func(ctx context.Context) (systemdConn, error) {
+					return dbus.NewSystemConnectionContext(ctx)
+				}
+			})
+
+			err := NewSystemdRestarter(tt.units...).Restart()
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantRestarted, tt.conn.restarted)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package containerd //nolint:testpackage // whitebox test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_fallbackRestarter_Restart(t *testing.T) {
+	tests := []struct {
+		name      string
+		errs      [][]error
+		wantErr   bool
+		wantCalls []int
+	}{
+		{"first strategy succeeds", [][]error{nil, nil}, false, []int{1, 0}},
+		{"falls back to second strategy", [][]error{{errors.New("no active unit")}, nil}, false, []int{1, 1}},
+		{"all strategies fail", [][]error{{errors.New("no active unit")}, {errors.New("found 2 containerd processes")}}, true, []int{1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var restarters []Restarter
+			var fakes []*fakeRestarter
+			for _, errs := range tt.errs {
+				fake := &fakeRestarter{errs: errs}
+				fakes = append(fakes, fake)
+				restarters = append(restarters, fake)
+			}
+
+			err := NewFallbackRestarter(restarters...).Restart()
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			for i, fake := range fakes {
+				assert.Equal(t, tt.wantCalls[i], fake.calls)
+			}
+		})
+	}
+}
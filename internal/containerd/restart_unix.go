@@ -23,18 +23,33 @@ import (
 	"fmt"
 	"log/slog"
 	"syscall"
+	"time"
 
 	"github.com/mitchellh/go-ps"
 )
 
 var psProcesses = ps.Processes
 
+var (
+	// settleDelay is the time containerd is given to act on the signal
+	// before checking that it is still running.
+	settleDelay = 2 * time.Second
+	// healthTimeout is the time containerd has to come back up after a restart.
+	healthTimeout = 30 * time.Second
+	pollInterval  = time.Second
+	sleep         = time.Sleep
+)
+
 type restarter struct{}
 
 func NewRestarter() Restarter {
 	return restarter{}
 }
 
+func (c restarter) String() string {
+	return "signal"
+}
+
 func (c restarter) Restart() error {
 	pid, err := getPid()
 	if err != nil {
@@ -47,7 +62,24 @@ func (c restarter) Restart() error {
 	if err != nil {
 		return fmt.Errorf("failed to send SIGHUP to containerd: %w", err)
 	}
-	return nil
+
+	return waitForContainerd()
+}
+
+// waitForContainerd waits until exactly one containerd process is running
+// again after a restart.
+func waitForContainerd() error {
+	sleep(settleDelay)
+
+	var err error
+	for waited := time.Duration(0); waited <= healthTimeout; waited += pollInterval {
+		if _, err = getPid(); err == nil {
+			return nil
+		}
+		sleep(pollInterval)
+	}
+
+	return fmt.Errorf("containerd did not come back after restart: %w", err)
 }
 
 func getPid() (int, error) {
@@ -6,6 +6,7 @@ package containerd //nolint:testpackage // whitebox test
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/mitchellh/go-ps"
 	"github.com/stretchr/testify/assert"
@@ -69,3 +70,39 @@ func Test_getPid(t *testing.T) {
 		})
 	}
 }
+
+func Test_waitForContainerd(t *testing.T) {
+	sleep = func(time.Duration) {}
+	t.Cleanup(func() { sleep = time.Sleep })
+
+	tests := []struct {
+		name    string
+		found   []int
+		wantErr bool
+	}{
+		{"containerd keeps running", []int{1}, false},
+		{"containerd comes back", []int{0, 0, 1}, false},
+		{"containerd does not come back", []int{0}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			polls := 0
+			psProcesses = func() ([]ps.Process, error) {
+				n := tt.found[min(polls, len(tt.found)-1)]
+				polls++
+				processes := []ps.Process{}
+				for range n {
+					processes = append(processes, &mockProcess{executable: "containerd", pid: 123})
+				}
+				return processes, nil
+			}
+
+			err := waitForContainerd()
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package containerd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// Verifier checks that containerd is healthy after a restart and serves the
+// given runtime handlers.
+type Verifier interface {
+	Verify(handlers []string) error
+}
+
+// criVerifier verifies containerd through its CRI socket.
+type criVerifier struct {
+	socketPath string
+	timeout    time.Duration
+	interval   time.Duration
+}
+
+// NewCRIVerifier returns a Verifier that polls the CRI runtime service at
+// socketPath until it reports ready and lists the expected runtime handlers,
+// or until timeout has passed.
+func NewCRIVerifier(socketPath string, timeout time.Duration) Verifier {
+	return criVerifier{
+		socketPath: socketPath,
+		timeout:    timeout,
+		interval:   time.Second,
+	}
+}
+
+func (v criVerifier) Verify(handlers []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), v.timeout)
+	defer cancel()
+
+	conn, err := grpc.NewClient("unix://"+v.socketPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("failed to connect to containerd socket %s: %w", v.socketPath, err)
+	}
+	defer conn.Close()
+	client := runtimeapi.NewRuntimeServiceClient(conn)
+
+	for {
+		err = checkStatus(ctx, client, handlers)
+		if err == nil {
+			slog.Info("containerd is healthy", "socket", v.socketPath, "handlers", handlers)
+			return nil
+		}
+		slog.Debug("containerd not ready yet", "socket", v.socketPath, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("containerd did not become healthy within %s: %w", v.timeout, err)
+		case <-time.After(v.interval):
+		}
+	}
+}
+
+func checkStatus(ctx context.Context, client runtimeapi.RuntimeServiceClient, handlers []string) error {
+	resp, err := client.Status(ctx, &runtimeapi.StatusRequest{Verbose: true})
+	if err != nil {
+		return fmt.Errorf("CRI status request failed: %w", err)
+	}
+
+	for _, condition := range resp.GetStatus().GetConditions() {
+		if condition.GetType() == runtimeapi.RuntimeReady && !condition.GetStatus() {
+			return fmt.Errorf("runtime is not ready: %s: %s", condition.GetReason(), condition.GetMessage())
+		}
+	}
+
+	if len(handlers) == 0 {
+		return nil
+	}
+
+	registered, err := runtimeHandlers(resp)
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, handler := range handlers {
+		if !slices.Contains(registered, handler) {
+			missing = append(missing, handler)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("runtime handlers %s are not registered in containerd (registered: %s)",
+			strings.Join(missing, ", "), strings.Join(registered, ", "))
+	}
+
+	return nil
+}
+
+// runtimeHandlers returns the runtime handlers known to containerd. Newer
+// versions list them in the status response, older versions only expose them
+// as part of the verbose CRI plugin config.
+func runtimeHandlers(resp *runtimeapi.StatusResponse) ([]string, error) {
+	if len(resp.GetRuntimeHandlers()) > 0 {
+		handlers := make([]string, 0, len(resp.GetRuntimeHandlers()))
+		for _, handler := range resp.GetRuntimeHandlers() {
+			handlers = append(handlers, handler.GetName())
+		}
+		return handlers, nil
+	}
+
+	raw, ok := resp.GetInfo()["config"]
+	if !ok {
+		return nil, errors.New("containerd does not report its runtime handlers")
+	}
+
+	var cfg struct {
+		Containerd struct {
+			Runtimes map[string]json.RawMessage `json:"runtimes"`
+		} `json:"containerd"`
+	}
+	if err := json.Unmarshal([]byte(raw), &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse containerd CRI config: %w", err)
+	}
+
+	handlers := make([]string, 0, len(cfg.Containerd.Runtimes))
+	for name := range cfg.Containerd.Runtimes {
+		handlers = append(handlers, name)
+	}
+	slices.Sort(handlers)
+
+	return handlers, nil
+}
@@ -0,0 +1,144 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package containerd //nolint:testpackage // whitebox test
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// fakeRuntimeService stands in for the CRI runtime service of containerd.
+type fakeRuntimeService struct {
+	runtimeapi.UnimplementedRuntimeServiceServer
+	resp *runtimeapi.StatusResponse
+}
+
+func (f *fakeRuntimeService) Status(_ context.Context, _ *runtimeapi.StatusRequest) (*runtimeapi.StatusResponse, error) {
+	return f.resp, nil
+}
+
+func serveFakeCRI(t *testing.T, resp *runtimeapi.StatusResponse) string {
+	t.Helper()
+
+	socketPath := filepath.Join(t.TempDir(), "containerd.sock")
+	lis, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+
+	srv := grpc.NewServer()
+	runtimeapi.RegisterRuntimeServiceServer(srv, &fakeRuntimeService{resp: resp})
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	return socketPath
+}
+
+func readyStatus() *runtimeapi.RuntimeStatus {
+	return &runtimeapi.RuntimeStatus{Conditions: []*runtimeapi.RuntimeCondition{
+		{Type: runtimeapi.RuntimeReady, Status: true},
+		{Type: runtimeapi.NetworkReady, Status: true},
+	}}
+}
+
+func Test_criVerifier_Verify(t *testing.T) {
+	tests := []struct {
+		name     string
+		resp     *runtimeapi.StatusResponse
+		handlers []string
+		wantErr  bool
+	}{
+		{
+			"runtime handlers listed",
+			&runtimeapi.StatusResponse{
+				Status:          readyStatus(),
+				RuntimeHandlers: []*runtimeapi.RuntimeHandler{{Name: "runc"}, {Name: "spin-v1"}},
+			},
+			[]string{"spin-v1"},
+			false,
+		},
+		{
+			"runtime handlers from verbose config",
+			&runtimeapi.StatusResponse{
+				Status: readyStatus(),
+				Info:   map[string]string{"config": `{"containerd":{"runtimes":{"runc":{},"spin-v1":{}}}}`},
+			},
+			[]string{"spin-v1"},
+			false,
+		},
+		{
+			"runtime handler missing",
+			&runtimeapi.StatusResponse{
+				Status:          readyStatus(),
+				RuntimeHandlers: []*runtimeapi.RuntimeHandler{{Name: "runc"}},
+			},
+			[]string{"spin-v1"},
+			true,
+		},
+		{
+			"runtime handlers unknown",
+			&runtimeapi.StatusResponse{Status: readyStatus()},
+			[]string{"spin-v1"},
+			true,
+		},
+		{
+			"runtime not ready",
+			&runtimeapi.StatusResponse{Status: &runtimeapi.RuntimeStatus{Conditions: []*runtimeapi.RuntimeCondition{
+				{Type: runtimeapi.RuntimeReady, Status: false, Reason: "Crashed"},
+			}}},
+			nil,
+			true,
+		},
+		{
+			"health check only",
+			&runtimeapi.StatusResponse{Status: readyStatus()},
+			nil,
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := criVerifier{
+				socketPath: serveFakeCRI(t, tt.resp),
+				timeout:    500 * time.Millisecond,
+				interval:   100 * time.Millisecond,
+			}
+
+			err := v.Verify(tt.handlers)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_criVerifier_VerifyNoSocket(t *testing.T) {
+	v := criVerifier{
+		socketPath: filepath.Join(t.TempDir(), "containerd.sock"),
+		timeout:    300 * time.Millisecond,
+		interval:   100 * time.Millisecond,
+	}
+
+	require.Error(t, v.Verify(nil))
+}
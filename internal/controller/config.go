@@ -0,0 +1,147 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+)
+
+// Environment variables the controller is configured with.
+const (
+	EnvControllerNamespace = "CONTROLLER_NAMESPACE"
+	EnvDownloaderImage     = "SHIM_DOWNLOADER_IMAGE"
+	EnvNodeInstallerImage  = "SHIM_NODE_INSTALLER_IMAGE"
+	EnvJobTTL              = "SHIM_NODE_INSTALLER_JOB_TTL"
+	EnvInstallMode         = "SHIM_INSTALL_MODE"
+	EnvJobTemplate         = "SHIM_NODE_INSTALLER_JOB_TEMPLATE"
+	EnvPrivilegedJobs      = "SHIM_NODE_INSTALLER_PRIVILEGED"
+	EnvHostPaths           = "SHIM_NODE_INSTALLER_HOST_PATHS"
+)
+
+// Config configures the reconcilers. It is loaded once on startup, so that
+// a misconfigured controller fails before it creates any Job.
+type Config struct {
+	// Namespace is the namespace the node-installer Jobs are created in.
+	Namespace string
+	// DownloaderImage is the image of the init container that downloads
+	// the shim.
+	DownloaderImage string
+	// NodeInstallerImage is the image of the node-installer Jobs.
+	NodeInstallerImage string
+	// JobTTLSeconds is the time finished Jobs are kept for. Zero keeps them.
+	JobTTLSeconds int32
+	// JobTemplate customizes the pods of all node-installer Jobs. The job
+	// template of a Shim is merged into it.
+	JobTemplate rcmv1.JobTemplateSpec
+	// PrivilegedJobs runs node-installer privileged with the whole host
+	// mounted. It has to be opted into, unprivileged Jobs only access the
+	// host paths of the distro of their node and HostPaths.
+	PrivilegedJobs bool
+	// HostPaths are the host directories unprivileged node-installer Jobs
+	// can access besides the kwasm path and the paths of the distro.
+	HostPaths []string
+	// InstallMode is either InstallModeJob or InstallModeAgent.
+	InstallMode string
+	// LogFormat and LogLevel are passed on to node-installer, so that Jobs
+	// log like the controller. Empty values keep the node-installer defaults.
+	LogFormat string
+	LogLevel  string
+}
+
+// LoadConfig loads the config from the environment variables returned by
+// getenv and validates it.
+func LoadConfig(getenv func(string) string) (Config, error) {
+	config := Config{
+		Namespace:          getenv(EnvControllerNamespace),
+		DownloaderImage:    getenv(EnvDownloaderImage),
+		NodeInstallerImage: getenv(EnvNodeInstallerImage),
+		InstallMode:        getenv(EnvInstallMode),
+	}
+	if config.InstallMode == "" {
+		config.InstallMode = InstallModeJob
+	}
+
+	var errs []error
+	if ttl := getenv(EnvJobTTL); ttl != "" {
+		seconds, err := strconv.ParseInt(ttl, 10, 32)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s %q, must be a number of seconds", EnvJobTTL, ttl))
+		}
+		config.JobTTLSeconds = int32(seconds)
+	}
+	if tmpl := getenv(EnvJobTemplate); tmpl != "" {
+		if err := yaml.UnmarshalStrict([]byte(tmpl), &config.JobTemplate); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", EnvJobTemplate, err))
+		}
+	}
+	if privileged := getenv(EnvPrivilegedJobs); privileged != "" {
+		var err error
+		if config.PrivilegedJobs, err = strconv.ParseBool(privileged); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s %q, must be true or false", EnvPrivilegedJobs, privileged))
+		}
+	}
+	if hostPaths := getenv(EnvHostPaths); hostPaths != "" {
+		for _, hostPath := range strings.Split(hostPaths, ",") {
+			if hostPath = strings.TrimSpace(hostPath); hostPath != "" {
+				config.HostPaths = append(config.HostPaths, hostPath)
+			}
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return config, errors.Join(errs...)
+}
+
+// Validate returns an error for every missing or invalid value.
+func (c Config) Validate() error {
+	var errs []error
+	if c.Namespace == "" {
+		errs = append(errs, fmt.Errorf("%s must be set", EnvControllerNamespace))
+	}
+	switch c.InstallMode {
+	case InstallModeJob:
+		// Only job mode creates Jobs that need the images.
+		if c.DownloaderImage == "" {
+			errs = append(errs, fmt.Errorf("%s must be set", EnvDownloaderImage))
+		}
+		if c.NodeInstallerImage == "" {
+			errs = append(errs, fmt.Errorf("%s must be set", EnvNodeInstallerImage))
+		}
+	case InstallModeAgent:
+	default:
+		errs = append(errs, fmt.Errorf("invalid %s %q, must be %s or %s", EnvInstallMode, c.InstallMode, InstallModeJob, InstallModeAgent))
+	}
+	for _, hostPath := range c.HostPaths {
+		if !path.IsAbs(hostPath) || path.Clean(hostPath) != hostPath || hostPath == "/" {
+			errs = append(errs, fmt.Errorf("invalid host path %q in %s, must be a clean absolute path below /", hostPath, EnvHostPaths))
+		}
+	}
+	if c.JobTTLSeconds < 0 {
+		errs = append(errs, fmt.Errorf("invalid %s %d, must not be negative", EnvJobTTL, c.JobTTLSeconds))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,159 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller //nolint:testpackage // whitebox test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+)
+
+func TestLoadConfig(t *testing.T) {
+	valid := map[string]string{
+		EnvControllerNamespace: "rcm",
+		EnvDownloaderImage:     "downloader:v1",
+		EnvNodeInstallerImage:  "node-installer:v1",
+	}
+	with := func(key, value string) map[string]string {
+		env := map[string]string{}
+		for k, v := range valid {
+			env[k] = v
+		}
+		env[key] = value
+		return env
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    Config
+		wantErr []string
+	}{
+		{"defaults", valid, Config{
+			Namespace:          "rcm",
+			DownloaderImage:    "downloader:v1",
+			NodeInstallerImage: "node-installer:v1",
+			InstallMode:        InstallModeJob,
+		}, nil},
+		{"job ttl", with(EnvJobTTL, "300"), Config{
+			Namespace:          "rcm",
+			DownloaderImage:    "downloader:v1",
+			NodeInstallerImage: "node-installer:v1",
+			JobTTLSeconds:      300,
+			InstallMode:        InstallModeJob,
+		}, nil},
+		{"job template", with(EnvJobTemplate, `{"tolerations":[{"key":"wasm","operator":"Exists"}],"priorityClassName":"high"}`), Config{
+			Namespace:          "rcm",
+			DownloaderImage:    "downloader:v1",
+			NodeInstallerImage: "node-installer:v1",
+			JobTemplate: rcmv1.JobTemplateSpec{
+				Tolerations:       []corev1.Toleration{{Key: "wasm", Operator: corev1.TolerationOpExists}},
+				PriorityClassName: "high",
+			},
+			InstallMode: InstallModeJob,
+		}, nil},
+		{"agent mode without images", map[string]string{
+			EnvControllerNamespace: "rcm",
+			EnvInstallMode:         InstallModeAgent,
+		}, Config{Namespace: "rcm", InstallMode: InstallModeAgent}, nil},
+		{"privileged jobs", with(EnvPrivilegedJobs, "true"), Config{
+			Namespace:          "rcm",
+			DownloaderImage:    "downloader:v1",
+			NodeInstallerImage: "node-installer:v1",
+			PrivilegedJobs:     true,
+			InstallMode:        InstallModeJob,
+		}, nil},
+		{"host paths", with(EnvHostPaths, "/var/lib/rancher/k3s/agent/etc/containerd, /run/k3s/containerd,"), Config{
+			Namespace:          "rcm",
+			DownloaderImage:    "downloader:v1",
+			NodeInstallerImage: "node-installer:v1",
+			HostPaths:          []string{"/var/lib/rancher/k3s/agent/etc/containerd", "/run/k3s/containerd"},
+			InstallMode:        InstallModeJob,
+		}, nil},
+		{"nothing set", map[string]string{}, Config{}, []string{
+			"CONTROLLER_NAMESPACE must be set",
+			"SHIM_DOWNLOADER_IMAGE must be set",
+			"SHIM_NODE_INSTALLER_IMAGE must be set",
+		}},
+		{"invalid install mode", with(EnvInstallMode, "daemon"), Config{}, []string{`invalid SHIM_INSTALL_MODE "daemon"`}},
+		{"invalid job ttl", with(EnvJobTTL, "1h"), Config{}, []string{`invalid SHIM_NODE_INSTALLER_JOB_TTL "1h"`}},
+		{"invalid job template", with(EnvJobTemplate, "tolerations: wasm"), Config{}, []string{"invalid SHIM_NODE_INSTALLER_JOB_TEMPLATE"}},
+		{"unknown job template field", with(EnvJobTemplate, "nodeName: node-1"), Config{}, []string{"invalid SHIM_NODE_INSTALLER_JOB_TEMPLATE"}},
+		{"invalid privileged", with(EnvPrivilegedJobs, "sometimes"), Config{}, []string{`invalid SHIM_NODE_INSTALLER_PRIVILEGED "sometimes"`}},
+		{"relative host path", with(EnvHostPaths, "etc/containerd"), Config{}, []string{`invalid host path "etc/containerd"`}},
+		{"host root as host path", with(EnvHostPaths, "/"), Config{}, []string{`invalid host path "/"`}},
+		{"negative job ttl", with(EnvJobTTL, "-1"), Config{}, []string{"invalid SHIM_NODE_INSTALLER_JOB_TTL -1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := LoadConfig(func(key string) string { return tt.env[key] })
+			if tt.wantErr != nil {
+				require.Error(t, err)
+				for _, want := range tt.wantErr {
+					assert.Contains(t, err.Error(), want)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, config)
+		})
+	}
+}
+
+func TestLoadConfigUnprivilegedByDefault(t *testing.T) {
+	config, _ := LoadConfig(func(string) string { return "" })
+	assert.False(t, config.PrivilegedJobs, "privileged jobs must be opted into")
+}
+
+func TestJobManifestConfig(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, rcmv1.AddToScheme(scheme))
+	sr := &ShimReconciler{Scheme: scheme, Config: Config{
+		Namespace:          "rcm",
+		DownloaderImage:    "downloader:v1",
+		NodeInstallerImage: "node-installer:v1",
+		JobTTLSeconds:      300,
+		InstallMode:        InstallModeJob,
+	}}
+	shim := &rcmv1.Shim{
+		ObjectMeta: metav1.ObjectMeta{Name: "spin", UID: "uid"},
+		Spec: rcmv1.ShimSpec{
+			FetchStrategy: rcmv1.FetchStrategy{
+				Type:     "anonymousHttp",
+				AnonHTTP: rcmv1.AnonHTTPSpec{Location: "https://example.com/shim.tar.gz"},
+			},
+		},
+	}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+
+	job, err := sr.createJobManifest(shim, node, INSTALL)
+	require.NoError(t, err)
+
+	assert.Equal(t, "rcm", job.Namespace)
+	assert.Equal(t, "node-installer:v1", job.Spec.Template.Spec.Containers[0].Image)
+	require.Len(t, job.Spec.Template.Spec.InitContainers, 1)
+	assert.Equal(t, "downloader:v1", job.Spec.Template.Spec.InitContainers[0].Image)
+	assert.Equal(t, ptr(int32(300)), job.Spec.TTLSecondsAfterFinished)
+}
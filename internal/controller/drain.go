@@ -0,0 +1,162 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+	"github.com/spinkube/runtime-class-manager/internal/logging"
+)
+
+const (
+	// CordonedByAnnotation marks nodes cordoned by the controller. Its value
+	// is the name of the Shim the node has been cordoned for.
+	CordonedByAnnotation = "rcm.spinkube.dev/cordoned-by"
+	// JobPodLabel is set on the pods of installer jobs.
+	JobPodLabel = "kwasm.sh/job"
+	// mirrorPodAnnotation is set on static pods managed by the kubelet.
+	mirrorPodAnnotation = "kubernetes.io/config.mirror"
+	// podNodeNameField is the field index of pods by node name.
+	podNodeNameField = "spec.nodeName"
+)
+
+// drainNode cordons the node and evicts all pods that would be disrupted by
+// a containerd restart. It returns true once no such pods are left on the
+// node. Evictions respect PodDisruptionBudgets, so draining may take
+// several reconciliations.
+func (sr *ShimReconciler) drainNode(ctx context.Context, shim *rcmv1.Shim, node *corev1.Node) (bool, error) {
+	log := logging.FromContext(ctx)
+
+	if err := sr.Client.Get(ctx, types.NamespacedName{Name: node.Name}, node); err != nil {
+		return false, fmt.Errorf("failed to fetch node: %w", err)
+	}
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
+
+	if !node.Spec.Unschedulable {
+		log.Info("Cordoning node", logging.KeyNode, node.Name, logging.KeyShim, shim.Name)
+		node.Spec.Unschedulable = true
+		if node.Annotations == nil {
+			node.Annotations = map[string]string{}
+		}
+		node.Annotations[CordonedByAnnotation] = shim.Name
+		node.Labels[shim.Name] = ProvisioningStatusDraining
+		if err := sr.Update(ctx, node); err != nil {
+			return false, fmt.Errorf("failed to cordon node: %w", err)
+		}
+	} else if node.Labels[shim.Name] != ProvisioningStatusDraining {
+		node.Labels[shim.Name] = ProvisioningStatusDraining
+		if err := sr.Update(ctx, node); err != nil {
+			return false, fmt.Errorf("failed to update node labels: %w", err)
+		}
+	}
+
+	pods := &corev1.PodList{}
+	if err := sr.List(ctx, pods, client.MatchingFields{podNodeNameField: node.Name}); err != nil {
+		return false, fmt.Errorf("failed to list pods on node: %w", err)
+	}
+
+	remaining := 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !needsEviction(pod) {
+			continue
+		}
+		remaining++
+
+		eviction := &policyv1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      pod.Name,
+				Namespace: pod.Namespace,
+			},
+		}
+		err := sr.Client.SubResource("eviction").Create(ctx, pod, eviction)
+		switch {
+		case err == nil, apierrors.IsNotFound(err):
+			log.Debug("Evicting pod", "pod", client.ObjectKeyFromObject(pod), logging.KeyNode, node.Name)
+		case apierrors.IsTooManyRequests(err):
+			log.Info("Eviction of pod blocked by disruption budget, retrying later", "pod", client.ObjectKeyFromObject(pod))
+		default:
+			return false, fmt.Errorf("failed to evict pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+	}
+
+	if remaining > 0 {
+		log.Info("Waiting for pods to leave node", "pods", remaining, logging.KeyNode, node.Name)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// needsEviction returns whether a pod has to be evicted before containerd is
+// restarted. DaemonSet pods, static pods, finished pods and installer pods
+// stay on the node, just like with kubectl drain.
+func needsEviction(pod *corev1.Pod) bool {
+	if !pod.DeletionTimestamp.IsZero() {
+		return false
+	}
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+	if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+		return false
+	}
+	if _, ok := pod.Labels[JobPodLabel]; ok {
+		return false
+	}
+	if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == "DaemonSet" {
+		return false
+	}
+	return true
+}
+
+// uncordonNode makes the node schedulable again if it has been cordoned for
+// the given shim.
+func uncordonNode(ctx context.Context, c client.Client, node *corev1.Node, shimName string) error {
+	if node.Annotations[CordonedByAnnotation] != shimName {
+		return nil
+	}
+
+	logging.FromContext(ctx).Info("Uncordoning node", logging.KeyNode, node.Name)
+	node.Spec.Unschedulable = false
+	delete(node.Annotations, CordonedByAnnotation)
+	if err := c.Update(ctx, node); err != nil {
+		return fmt.Errorf("failed to uncordon node: %w", err)
+	}
+
+	return nil
+}
+
+// indexPodsByNodeName allows listing the pods running on a node from the cache.
+func indexPodsByNodeName(obj client.Object) []string {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.NodeName == "" {
+		return nil
+	}
+	return []string{pod.Spec.NodeName}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+	"github.com/spinkube/runtime-class-manager/internal/logging"
+)
+
+// verifyIfDue deploys a verify Job to a provisioned node if the last
+// verification is older than the drift detection interval of the shim. It
+// returns the time until the node is due for its next verification.
+func (sr *ShimReconciler) verifyIfDue(ctx context.Context, shim *rcmv1.Shim, node corev1.Node) (time.Duration, error) {
+	interval := shim.Spec.DriftDetection.Interval.Duration
+
+	if last := findNodeVerification(shim.Status.Verifications, node.Name); last != nil {
+		if wait := time.Until(last.LastVerifyTime.Add(interval)); wait > 0 {
+			return wait, nil
+		}
+	}
+
+	running, err := sr.verifyJobExists(ctx, shim, node.Name)
+	if err != nil {
+		return 0, err
+	}
+	if !running {
+		if err := sr.deployJobOnNode(ctx, shim, node, VERIFY); err != nil {
+			return 0, err
+		}
+	}
+	return interval, nil
+}
+
+// checkRestart deploys a verify Job to a node waiting for a containerd
+// restart, unless one exists already. The Job reports whether the restart is
+// still pending and the JobReconciler marks the node as provisioned once it
+// is not. It returns the time until the node is checked again.
+func (sr *ShimReconciler) checkRestart(ctx context.Context, shim *rcmv1.Shim, node corev1.Node) (time.Duration, error) {
+	running, err := sr.verifyJobExists(ctx, shim, node.Name)
+	if err != nil {
+		return 0, err
+	}
+	if !running {
+		if err := sr.deployJobOnNode(ctx, shim, node, VERIFY); err != nil {
+			return 0, err
+		}
+	}
+	return restartCheckInterval, nil
+}
+
+// verifyJobExists returns whether a verify Job of the shim exists for the node.
+func (sr *ShimReconciler) verifyJobExists(ctx context.Context, shim *rcmv1.Shim, nodeName string) (bool, error) {
+	jobs, err := findJobs(ctx, sr.Client, sr.Config.Namespace, nodeName, shim.Name, VERIFY)
+	if err != nil {
+		return false, err
+	}
+	return len(jobs) > 0, nil
+}
+
+// remediateDrift reinstalls the shim on a drifted node. The node label is
+// removed, so that the node goes through the regular installation again,
+// including preflight and draining. The finished install Job of the node is
+// replaced when the install is deployed, and the install replaces binaries
+// that are missing or have been modified on the host.
+func (sr *ShimReconciler) remediateDrift(ctx context.Context, shim *rcmv1.Shim, node corev1.Node) error {
+	log := logging.FromContext(ctx)
+
+	log.Info("Reinstalling drifted shim", logging.KeyShim, shim.Name, logging.KeyNode, node.Name)
+	delete(node.Labels, shim.Name)
+	if err := sr.Update(ctx, &node); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to delete node label: %w", err)
+	}
+	return nil
+}
+
+// recordVerification stores the result of a verify Job in the status of the
+// Shim. A failed verification is recorded with verifyErr set.
+func recordVerification(ctx context.Context, c client.Client, shimName string, nodeName string, drift []string, verifyErr string) error {
+	shim := &rcmv1.Shim{}
+	if err := c.Get(ctx, types.NamespacedName{Name: shimName}, shim); err != nil {
+		return fmt.Errorf("failed to fetch shim: %w", err)
+	}
+
+	result := rcmv1.NodeVerification{
+		Node:           nodeName,
+		Drift:          drift,
+		Error:          verifyErr,
+		LastVerifyTime: metav1.NewTime(time.Now()),
+	}
+
+	if existing := findNodeVerification(shim.Status.Verifications, nodeName); existing != nil {
+		*existing = result
+	} else {
+		shim.Status.Verifications = append(shim.Status.Verifications, result)
+	}
+
+	if err := c.Update(ctx, shim); err != nil {
+		return fmt.Errorf("failed to update verification status: %w", err)
+	}
+	return nil
+}
+
+// findNodeVerification returns the last verification of the node.
+func findNodeVerification(results []rcmv1.NodeVerification, nodeName string) *rcmv1.NodeVerification {
+	for i := range results {
+		if results[i].Node == nodeName {
+			return &results[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller //nolint:testpackage // whitebox test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+)
+
+// verifyPod returns the pod of job that terminated with message.
+func verifyPod(job *batchv1.Job, message string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      job.Name + "-pod",
+			Namespace: job.Namespace,
+			Labels:    map[string]string{batchv1.JobNameLabel: job.Name},
+		},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name:  "provisioner",
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: message}},
+		}}},
+	}
+}
+
+func TestCheckRestart(t *testing.T) {
+	scheme := lifecycleScheme(t)
+	shim := &rcmv1.Shim{ObjectMeta: metav1.ObjectMeta{Name: "spin", UID: "uid"}}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"spin": ProvisioningStatusPendingRestart}}}
+	sr := &ShimReconciler{Scheme: scheme, Config: Config{Namespace: "rcm"}}
+
+	verify, err := sr.createJobManifest(shim, node, VERIFY)
+	require.NoError(t, err)
+	sr.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(shim, node, verify).Build()
+
+	next, err := sr.checkRestart(context.Background(), shim, *node)
+	require.NoError(t, err)
+	assert.Equal(t, restartCheckInterval, next)
+
+	jobs, err := findJobs(context.Background(), sr.Client, "rcm", node.Name, shim.Name, VERIFY)
+	require.NoError(t, err)
+	assert.Len(t, jobs, 1, "running verify job is not replaced")
+}
+
+func TestFinishVerifyPendingRestart(t *testing.T) {
+	scheme := lifecycleScheme(t)
+
+	tests := []struct {
+		name         string
+		status       string
+		finishedType batchv1.JobConditionType
+		message      string
+		want         string
+	}{
+		{"restarted", ProvisioningStatusPendingRestart, batchv1.JobComplete, `{}`, ProvisioningStatusProvisioned},
+		{"restarted with drift", ProvisioningStatusPendingRestart, batchv1.JobComplete, `{"drift":["binary-missing"]}`, ProvisioningStatusDrifted},
+		{"still pending", ProvisioningStatusPendingRestart, batchv1.JobComplete, `{"restartPending":true}`, ProvisioningStatusPendingRestart},
+		{"verify failed", ProvisioningStatusPendingRestart, batchv1.JobFailed, ``, ProvisioningStatusPendingRestart},
+		{"provisioned", ProvisioningStatusProvisioned, batchv1.JobComplete, `{"restartPending":true}`, ProvisioningStatusProvisioned},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shim := &rcmv1.Shim{ObjectMeta: metav1.ObjectMeta{Name: "spin", UID: "uid"}}
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"spin": tt.status}}}
+			sr := &ShimReconciler{Scheme: scheme, Config: Config{Namespace: "rcm"}}
+			job, err := sr.createJobManifest(shim, node, VERIFY)
+			require.NoError(t, err)
+			finishJob(job, tt.finishedType, time.Now())
+
+			jr := &JobReconciler{Scheme: scheme, Client: fake.NewClientBuilder().WithScheme(scheme).
+				WithObjects(shim, node, job, verifyPod(job, tt.message)).Build()}
+			require.NoError(t, jr.finishVerify(context.Background(), job, node, shim.Name, tt.finishedType))
+
+			updated := &corev1.Node{}
+			require.NoError(t, jr.Get(context.Background(), types.NamespacedName{Name: node.Name}, updated))
+			assert.Equal(t, tt.want, updated.Labels["spin"])
+			err = jr.Get(context.Background(), client.ObjectKeyFromObject(job), &batchv1.Job{})
+			assert.True(t, apierrors.IsNotFound(err), "verify job deleted")
+		})
+	}
+}
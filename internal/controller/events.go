@@ -0,0 +1,57 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+)
+
+// Reasons of the Events emitted on Shims and Nodes.
+const (
+	EventReasonJobCreated          = "JobCreated"
+	EventReasonJobSucceeded        = "JobSucceeded"
+	EventReasonJobFailed           = "JobFailed"
+	EventReasonRuntimeClassCreated = "RuntimeClassCreated"
+	EventReasonRuntimeClassUpdated = "RuntimeClassUpdated"
+	EventReasonUninstallStarted    = "UninstallStarted"
+	EventReasonUninstallCompleted  = "UninstallCompleted"
+	EventReasonRolloutPaused       = "RolloutPaused"
+	EventReasonReinstallRequested  = "ReinstallRequested"
+	EventReasonUnknownDistro       = "UnknownDistro"
+)
+
+// recordEvent emits an Event on the Shim and, if set, on the Node. Events on
+// the Node name the Shim, as they are listed with all other Node events. A
+// Shim without UID, e.g. of a Job that outlived its Shim, is only named in
+// the Node event. Nothing is emitted without a recorder.
+func recordEvent(recorder record.EventRecorder, shim *rcmv1.Shim, node *corev1.Node, eventtype, reason, messageFmt string, args ...any) {
+	if recorder == nil {
+		return
+	}
+	message := fmt.Sprintf(messageFmt, args...)
+	if shim.UID != "" {
+		recorder.Event(shim, eventtype, reason, message)
+	}
+	if node != nil && node.Name != "" {
+		recorder.Eventf(node, eventtype, reason, "Shim %s: %s", shim.Name, message)
+	}
+}
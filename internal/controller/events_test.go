@@ -0,0 +1,167 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+	"github.com/spinkube/runtime-class-manager/internal/controller"
+	"github.com/spinkube/runtime-class-manager/internal/termination"
+)
+
+// drainEvents returns the reasons of all Events recorded so far.
+func drainEvents(recorder *record.FakeRecorder) []string {
+	var reasons []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			// Events are recorded as "<type> <reason> <message>".
+			reasons = append(reasons, strings.Fields(event)[1])
+		default:
+			return reasons
+		}
+	}
+}
+
+var _ = Describe("Events", func() {
+	var (
+		ctx      context.Context
+		recorder *record.FakeRecorder
+		shimName string
+		specs    int
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		recorder = record.NewFakeRecorder(100) //nolint:mnd // buffer for all events of a test
+
+		specs++
+		shimName = fmt.Sprintf("events-%d", specs)
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   shimName + "-node",
+				Labels: map[string]string{"events": shimName},
+			},
+		}
+		Expect(k8sClient.Create(ctx, node)).To(Succeed())
+
+		shim := &rcmv1.Shim{
+			ObjectMeta: metav1.ObjectMeta{Name: shimName},
+			Spec: rcmv1.ShimSpec{
+				NodeSelector: map[string]string{"events": shimName},
+				FetchStrategy: rcmv1.FetchStrategy{
+					Type:     "anonymousHttp",
+					AnonHTTP: rcmv1.AnonHTTPSpec{Location: "https://example.com/shim.tar.gz"},
+				},
+				RuntimeClass: rcmv1.RuntimeClassSpec{Name: shimName, Handler: "spin"},
+				RolloutStrategy: rcmv1.RolloutStrategy{
+					Type: rcmv1.RolloutStrategyTypeRecreate,
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, shim)).To(Succeed())
+	})
+
+	// reconcileShim runs the ShimReconciler once and returns the install job.
+	reconcileShim := func() *batchv1.Job {
+		shimReconciler := &controller.ShimReconciler{
+			Client:   k8sClient,
+			Scheme:   scheme.Scheme,
+			Recorder: recorder,
+			Config: controller.Config{
+				Namespace:          "default",
+				DownloaderImage:    "downloader:test",
+				NodeInstallerImage: "node-installer:test",
+				InstallMode:        controller.InstallModeJob,
+			},
+		}
+		_, err := shimReconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: shimName}})
+		Expect(err).NotTo(HaveOccurred())
+
+		jobs := &batchv1.JobList{}
+		Expect(k8sClient.List(ctx, jobs, client.InNamespace("default"), client.MatchingLabels{"kwasm.sh/shimName": shimName})).To(Succeed())
+		Expect(jobs.Items).To(HaveLen(1))
+		return &jobs.Items[0]
+	}
+
+	// finishJob sets the job condition and runs the JobReconciler once.
+	finishJob := func(job *batchv1.Job, conditionType batchv1.JobConditionType) {
+		now := metav1.Now()
+		job.Status.StartTime = &now
+		if conditionType == batchv1.JobComplete {
+			job.Status.CompletionTime = &now
+		}
+		job.Status.Conditions = append(job.Status.Conditions, batchv1.JobCondition{
+			Type:               conditionType,
+			Status:             corev1.ConditionTrue,
+			Reason:             "BackoffLimitExceeded",
+			Message:            "Job has reached the specified backoff limit",
+			LastTransitionTime: now,
+		})
+		Expect(k8sClient.Status().Update(ctx, job)).To(Succeed())
+
+		jobReconciler := &controller.JobReconciler{
+			Client:   k8sClient,
+			Scheme:   scheme.Scheme,
+			Recorder: recorder,
+		}
+		_, err := jobReconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(job)})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("reports successful installs", func() {
+		job := reconcileShim()
+		Expect(drainEvents(recorder)).To(ContainElements(
+			controller.EventReasonRuntimeClassCreated,
+			controller.EventReasonJobCreated,
+		))
+
+		finishJob(job, batchv1.JobComplete)
+		Expect(drainEvents(recorder)).To(ContainElement(controller.EventReasonJobSucceeded))
+	})
+
+	It("reports failed installs", func() {
+		job := reconcileShim()
+		drainEvents(recorder)
+
+		finishJob(job, batchv1.JobFailed)
+		events := drainEvents(recorder)
+		Expect(events).To(ContainElement(controller.EventReasonJobFailed))
+		Expect(events).NotTo(ContainElement(controller.EventReasonJobSucceeded))
+
+		shim := &rcmv1.Shim{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: shimName}, shim)).To(Succeed())
+		Expect(shim.Status.Failures).To(HaveLen(1))
+		Expect(shim.Status.Failures[0].Node).To(Equal(shimName + "-node"))
+		Expect(shim.Status.Failures[0].Class).To(Equal(termination.FailureUnknown))
+	})
+})
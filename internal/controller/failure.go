@@ -0,0 +1,107 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+	"github.com/spinkube/runtime-class-manager/internal/logging"
+	"github.com/spinkube/runtime-class-manager/internal/termination"
+)
+
+// jobFailure returns why a failed Job failed. The failure node-installer
+// reported in its termination message is preferred, Jobs that failed without
+// reporting one, e.g. because their pod has been evicted, are described by
+// their Failed condition.
+func (jr *JobReconciler) jobFailure(ctx context.Context, job *batchv1.Job) termination.Failure {
+	msg, err := jr.getTerminationMessage(ctx, job)
+	if err != nil {
+		logging.FromContext(ctx).Error("Unable to get result of Job", logging.KeyJob, job.Name, "error", err)
+	}
+	if msg.Failure != nil {
+		return *msg.Failure
+	}
+
+	failure := termination.Failure{Class: termination.FailureUnknown, Message: "job failed"}
+	if c := jobFailedCondition(job); c != nil {
+		failure.Message = fmt.Sprintf("%s: %s", c.Reason, c.Message)
+	}
+	return failure
+}
+
+// jobFailedCondition returns the Failed condition of a Job.
+func jobFailedCondition(job *batchv1.Job) *batchv1.JobCondition {
+	for i, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			return &job.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// recordFailure stores the failure of an install or uninstall on the node
+// in the status of the Shim. A nil failure removes the failure of the node.
+// Shims that do not exist anymore are left alone.
+func recordFailure(ctx context.Context, c client.Client, shim *rcmv1.Shim, nodeName string, failure *rcmv1.NodeFailure) error {
+	if shim.UID == "" {
+		return nil
+	}
+
+	i := slices.IndexFunc(shim.Status.Failures, func(f rcmv1.NodeFailure) bool {
+		return f.Node == nodeName
+	})
+	switch {
+	case failure == nil && i < 0:
+		return nil
+	case failure == nil:
+		shim.Status.Failures = slices.Delete(shim.Status.Failures, i, i+1)
+	case i < 0:
+		shim.Status.Failures = append(shim.Status.Failures, *failure)
+	default:
+		shim.Status.Failures[i] = *failure
+	}
+
+	if err := c.Update(ctx, shim); err != nil {
+		return fmt.Errorf("failed to update failure status: %w", err)
+	}
+	return nil
+}
+
+// nodeFailure describes the failure of a Job in the status of a Shim.
+func nodeFailure(job *batchv1.Job, nodeName string, failure termination.Failure) *rcmv1.NodeFailure {
+	failedAt := metav1.Now()
+	if c := jobFailedCondition(job); c != nil {
+		failedAt = c.LastTransitionTime
+	}
+	return &rcmv1.NodeFailure{
+		Node:            nodeName,
+		Operation:       job.Annotations["kwasm.sh/operation"],
+		Class:           failure.Class,
+		Message:         failure.Message,
+		Distro:          failure.Distro,
+		ConfigPath:      failure.ConfigPath,
+		LastFailureTime: failedAt,
+	}
+}
@@ -0,0 +1,138 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller //nolint:testpackage // whitebox test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+	"github.com/spinkube/runtime-class-manager/internal/termination"
+)
+
+func provisionerPod(name, jobName string, finishedAt time.Time, message string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "rcm", Name: name, Labels: map[string]string{batchv1.JobNameLabel: jobName}},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name: "provisioner",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+					ExitCode:   1,
+					FinishedAt: metav1.Time{Time: finishedAt},
+					Message:    message,
+				}},
+			}},
+		},
+	}
+}
+
+func TestJobFailure(t *testing.T) {
+	failedAt := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "rcm", Name: "worker-1-spin-install"},
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{{
+				Type:    batchv1.JobFailed,
+				Status:  corev1.ConditionTrue,
+				Reason:  "BackoffLimitExceeded",
+				Message: "Job has reached the specified backoff limit",
+			}},
+		},
+	}
+
+	tests := []struct {
+		name string
+		pods []client.Object
+		want termination.Failure
+	}{
+		{
+			"no failure reported",
+			nil,
+			termination.Failure{Class: termination.FailureUnknown, Message: "BackoffLimitExceeded: Job has reached the specified backoff limit"},
+		},
+		{
+			"failure of last pod",
+			[]client.Object{
+				provisionerPod("pod-2", job.Name, failedAt.Add(time.Minute), `{"failure":{"class":"RuntimeRestart","message":"failed to restart containerd: exit status 1","distro":"k3s"}}`),
+				provisionerPod("pod-1", job.Name, failedAt, `{"failure":{"class":"LockTimeout","message":"timed out waiting for the host lock"}}`),
+			},
+			termination.Failure{Class: termination.FailureRuntimeRestart, Message: "failed to restart containerd: exit status 1", Distro: "k3s"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(tt.pods...).Build()
+			jr := &JobReconciler{Client: c}
+			assert.Equal(t, tt.want, jr.jobFailure(context.Background(), job))
+		})
+	}
+}
+
+func TestRecordFailure(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, rcmv1.AddToScheme(scheme))
+
+	shim := &rcmv1.Shim{
+		ObjectMeta: metav1.ObjectMeta{Name: "spin", UID: "1"},
+		Status: rcmv1.ShimStatus{
+			Failures: []rcmv1.NodeFailure{{Node: "worker-2", Operation: INSTALL, Class: termination.FailureUnknown}},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(shim).Build()
+	ctx := context.Background()
+
+	get := func(t *testing.T) *rcmv1.Shim {
+		t.Helper()
+		got := &rcmv1.Shim{}
+		require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "spin"}, got))
+		return got
+	}
+
+	failure := &rcmv1.NodeFailure{Node: "worker-1", Operation: INSTALL, Class: termination.FailureLockTimeout}
+	require.NoError(t, recordFailure(ctx, c, get(t), "worker-1", failure))
+	assert.Equal(t, []string{termination.FailureUnknown, termination.FailureLockTimeout}, failureClasses(get(t)))
+
+	failure.Class = termination.FailureRuntimeRestart
+	require.NoError(t, recordFailure(ctx, c, get(t), "worker-1", failure))
+	assert.Equal(t, []string{termination.FailureUnknown, termination.FailureRuntimeRestart}, failureClasses(get(t)))
+
+	require.NoError(t, recordFailure(ctx, c, get(t), "worker-2", nil))
+	assert.Equal(t, []string{termination.FailureRuntimeRestart}, failureClasses(get(t)))
+
+	require.NoError(t, recordFailure(ctx, c, &rcmv1.Shim{ObjectMeta: metav1.ObjectMeta{Name: "deleted"}}, "worker-1", failure), "deleted shims are left alone")
+}
+
+func failureClasses(shim *rcmv1.Shim) []string {
+	var classes []string
+	for _, f := range shim.Status.Failures {
+		classes = append(classes, f.Class)
+	}
+	return classes
+}
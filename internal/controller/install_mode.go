@@ -0,0 +1,41 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Modes in which shims are installed on nodes, selected with the
+// SHIM_INSTALL_MODE environment variable.
+const (
+	// InstallModeJob deploys an install or uninstall Job per node and Shim.
+	InstallModeJob = "job"
+	// InstallModeAgent leaves the installation to node-installer agents
+	// running as a DaemonSet on the nodes.
+	InstallModeAgent = "agent"
+)
+
+// shimOnAnyNode returns whether any node still reports a status for the shim.
+func shimOnAnyNode(shimName string, nodes *corev1.NodeList) bool {
+	for _, node := range nodes.Items {
+		if _, exists := node.Labels[shimName]; exists {
+			return true
+		}
+	}
+	return false
+}
@@ -20,34 +20,55 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+	"github.com/spinkube/runtime-class-manager/internal/logging"
+	"github.com/spinkube/runtime-class-manager/internal/termination"
+	"github.com/spinkube/runtime-class-manager/internal/tracing"
 )
 
 // JobReconciler reconciles a Job object
 type JobReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	jobs jobObserver
 }
 
 //+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=batch,resources=jobs/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=batch,resources=jobs/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // SetupWithManager sets up the controller with the Manager.
 func (jr *JobReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		// Uncomment the following line adding a pointer to an instance of the controlled resource as an argument
-		For(&batchv1.Job{}).
+		// Only the node-installer Jobs are of interest, they carry the labels
+		// of their Shim.
+		For(&batchv1.Job{}, builder.WithPredicates(predicate.NewPredicateFuncs(isShimJob))).
 		Complete(jr)
 }
 
+// isShimJob returns whether obj is a node-installer Job of a Shim.
+func isShimJob(obj client.Object) bool {
+	_, exists := obj.GetLabels()[LabelShimName]
+	return exists
+}
+
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
 // TODO(user): Modify the Reconcile function to compare the state specified by
@@ -57,9 +78,9 @@ func (jr *JobReconciler) SetupWithManager(mgr ctrl.Manager) error {
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.16.3/pkg/reconcile
-func (jr *JobReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	log := log.With().Str("job", req.Name).Logger()
-	log.Debug().Msg("Job Reconciliation started!")
+func (jr *JobReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	log := logging.FromContext(ctx).With(logging.KeyJob, req.Name)
+	log.Debug("Job Reconciliation started!")
 
 	job := &batchv1.Job{}
 
@@ -68,66 +89,256 @@ func (jr *JobReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 			// we'll ignore not-found errors, since they can't be fixed by an immediate
 			// requeue (we'll need to wait for a new notification), and we can get them
 			// on deleted requests.
+			jr.jobs.forget(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
-		log.Error().Msgf("Unable to get Job: %s", err)
+		log.Error("Unable to get Job", "error", err)
 		return ctrl.Result{}, fmt.Errorf("failed to get Job: %w", err)
 	}
 
-	if _, exists := job.Labels["kwasm.sh/shimName"]; !exists {
+	if _, exists := job.Labels[LabelShimName]; !exists {
 		return ctrl.Result{}, nil
 	}
 
-	shimName := job.Labels["kwasm.sh/shimName"]
+	// Finishing the job continues the trace of the rollout that created it.
+	ctx, span := tracing.Start(tracing.ContextWithTraceParent(ctx, job.Annotations[tracing.Annotation]), "JobReconciler.Reconcile",
+		attribute.String("job", job.Name), attribute.String("operation", job.Annotations["kwasm.sh/operation"]))
+	defer func() { tracing.End(span, err) }()
+
+	// Label values may be shortened, the annotation has the full name.
+	shimName := job.Annotations["kwasm.sh/shimName"]
+	log = log.With(logging.KeyShim, shimName, logging.KeyNode, job.Spec.Template.Spec.NodeName,
+		logging.KeyOperation, job.Annotations["kwasm.sh/operation"])
+	ctx = logging.IntoContext(ctx, log)
 
 	node, err := jr.getNode(ctx, job.Spec.Template.Spec.NodeName)
+	if apierrors.IsNotFound(err) {
+		// The node is gone and with it the label the job would update.
+		log.Info("Node of Job not found")
+		return ctrl.Result{}, nil
+	}
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	_, finishedType := jr.isJobFinished(job)
+	_, finishedType := isJobFinished(job)
+	if finishedType != "" {
+		first, err := jr.jobs.observe(ctx, jr.Client, job, shimName, finishedType)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if first {
+			jr.reportJob(ctx, job, node, shimName, finishedType)
+		}
+	}
+	if finishedType != "" && job.Annotations["kwasm.sh/operation"] != VERIFY {
+		if err := jr.cleanupJobHistory(ctx, shimName, job); err != nil {
+			log.Error("Unable to clean up job history", "error", err)
+		}
+	}
+	if job.Annotations["kwasm.sh/operation"] == VERIFY {
+		return ctrl.Result{}, jr.finishVerify(ctx, job, node, shimName, finishedType)
+	}
+	if superseded, err := jr.superseded(ctx, job, node); err != nil || superseded {
+		return ctrl.Result{}, err
+	}
+
 	switch finishedType {
 	case "": // ongoing
-		log.Info().Msgf("Job %s is still Ongoing", job.Name)
+		log.Info("Job is still Ongoing")
 		return ctrl.Result{}, nil
 	case batchv1.JobFailed:
-		log.Info().Msgf("Job %s is still failing...", job.Name)
-		if err := jr.updateNodeLabels(ctx, node, shimName, "failed"); err != nil {
-			log.Error().Msgf("Unable to update node label %s: %s", shimName, err)
+		log.Info("Job is still failing...")
+		if job.Annotations["kwasm.sh/operation"] == PREFLIGHT {
+			return ctrl.Result{}, jr.finishPreflight(ctx, job, node, shimName, nil)
+		}
+		if err := jr.updateNodeLabels(ctx, node, shimName, ProvisioningStatusFailed); err != nil {
+			log.Error("Unable to update node label", "error", err)
+		}
+		if err := uncordonNode(ctx, jr.Client, node, shimName); err != nil {
+			log.Error("Unable to uncordon node", "error", err)
 		}
 		return ctrl.Result{}, nil
 	case batchv1.JobFailureTarget:
-		log.Info().Msgf("Job %s is about to fail", job.Name)
-		if err := jr.updateNodeLabels(ctx, node, shimName, "failed"); err != nil {
-			log.Error().Msgf("Unable to update node label %s: %s", shimName, err)
+		log.Info("Job is about to fail")
+		if err := jr.updateNodeLabels(ctx, node, shimName, ProvisioningStatusFailed); err != nil {
+			log.Error("Unable to update node label", "error", err)
 		}
 		return ctrl.Result{}, nil
 	case batchv1.JobComplete:
-		log.Info().Msgf("Job %s is Completed.", job.Name)
+		log.Info("Job is Completed.")
 
 		installOrUninstall := job.Annotations["kwasm.sh/operation"]
 
 		switch installOrUninstall {
 		case INSTALL:
-			if err := jr.updateNodeLabels(ctx, node, shimName, "provisioned"); err != nil {
-				log.Error().Msgf("Unable to update node label %s: %s", shimName, err)
+			status := ProvisioningStatusProvisioned
+			msg, err := jr.getTerminationMessage(ctx, job)
+			if err != nil {
+				log.Error("Unable to get result of Job", "error", err)
+			}
+			if msg.RestartPending {
+				log.Info("Shim installed, containerd restart pending")
+				status = ProvisioningStatusPendingRestart
+			}
+			if err := jr.updateNodeLabels(ctx, node, shimName, status); err != nil {
+				log.Error("Unable to update node label", "error", err)
 			}
 		case UNINSTALL:
+			forgetInstall(node, shimName)
 			if err := jr.deleteNodeLabel(ctx, node, shimName); err != nil {
-				log.Error().Msgf("Unable to delete node label %s: %s", shimName, err)
+				log.Error("Unable to delete node label", "error", err)
+			}
+		case PREFLIGHT:
+			msg, err := jr.getTerminationMessage(ctx, job)
+			if err != nil {
+				log.Error("Unable to get result of Job", "error", err)
+			}
+			preflight := msg.Preflight
+			if preflight == nil {
+				// The dry run succeeded but its result got lost, record an
+				// empty result rather than a failure.
+				preflight = &termination.Preflight{}
 			}
+			return ctrl.Result{}, jr.finishPreflight(ctx, job, node, shimName, preflight)
+		}
+
+		if err := uncordonNode(ctx, jr.Client, node, shimName); err != nil {
+			log.Error("Unable to uncordon node", "error", err)
 		}
 
 		return ctrl.Result{}, err
 	case batchv1.JobSuspended:
-		log.Info().Msgf("Job %s is suspended", job.Name)
+		log.Info("Job is suspended")
 		return ctrl.Result{}, nil
 	}
 
 	return ctrl.Result{}, nil
 }
 
+// reportJob emits the Events of a finished Job. Failures of installs and
+// uninstalls are kept in the status of the Shim until the operation
+// succeeds on the node.
+func (jr *JobReconciler) reportJob(ctx context.Context, job *batchv1.Job, node *corev1.Node, shimName string, finishedType batchv1.JobConditionType) {
+	log := logging.FromContext(ctx)
+
+	shim := &rcmv1.Shim{}
+	if err := jr.Get(ctx, types.NamespacedName{Name: shimName}, shim); err != nil {
+		// The Shim is only named in the Node event.
+		shim = &rcmv1.Shim{ObjectMeta: metav1.ObjectMeta{Name: shimName}}
+	}
+	operation := job.Annotations["kwasm.sh/operation"]
+	keepFailure := operation == INSTALL || operation == UNINSTALL
+
+	if finishedType == batchv1.JobFailed {
+		failure := jr.jobFailure(ctx, job)
+		if keepFailure {
+			if err := recordFailure(ctx, jr.Client, shim, node.Name, nodeFailure(job, node.Name, failure)); err != nil {
+				log.Error("Unable to record failure of Job", logging.KeyJob, job.Name, "error", err)
+			}
+		}
+		recordEvent(jr.Recorder, shim, node, corev1.EventTypeWarning, EventReasonJobFailed, "%s job %s failed on node %s: %s: %s", operation, job.Name, node.Name, failure.Class, failure.Message)
+		return
+	}
+
+	if keepFailure {
+		if err := recordFailure(ctx, jr.Client, shim, node.Name, nil); err != nil {
+			log.Error("Unable to clear failure of Job", logging.KeyJob, job.Name, "error", err)
+		}
+	}
+	recordEvent(jr.Recorder, shim, node, corev1.EventTypeNormal, EventReasonJobSucceeded, "%s job %s succeeded on node %s", operation, job.Name, node.Name)
+	if operation == UNINSTALL {
+		recordEvent(jr.Recorder, shim, node, corev1.EventTypeNormal, EventReasonUninstallCompleted, "Uninstalled shim from node %s", node.Name)
+	}
+}
+
+// finishPreflight records the result of a preflight Job in the Shim status.
+// Nodes that passed the preflight lose their preflight label, so that the
+// ShimReconciler installs the shim on them. A nil preflight marks a failed
+// dry run, which is recorded with the failure the Job reported, and pauses
+// the rollout on the node. Nodes that have moved on since are left alone.
+func (jr *JobReconciler) finishPreflight(ctx context.Context, job *batchv1.Job, node *corev1.Node, shimName string, preflight *termination.Preflight) error {
+	if node.Labels[shimName] != ProvisioningStatusPreflight {
+		return nil
+	}
+
+	preflightErr := ""
+	if preflight == nil {
+		failure := jr.jobFailure(ctx, job)
+		preflightErr = fmt.Sprintf("%s: %s", failure.Class, failure.Message)
+	}
+	if err := recordPreflight(ctx, jr.Client, shimName, node.Name, jobGeneration(job.Annotations), preflight, preflightErr); err != nil {
+		return err
+	}
+
+	if preflight == nil {
+		if err := jr.updateNodeLabels(ctx, node, shimName, ProvisioningStatusFailed); err != nil {
+			return err
+		}
+		shim := &rcmv1.Shim{}
+		if err := jr.Get(ctx, types.NamespacedName{Name: shimName}, shim); err != nil {
+			// The Shim is only named in the Node event.
+			shim = &rcmv1.Shim{ObjectMeta: metav1.ObjectMeta{Name: shimName}}
+		}
+		recordEvent(jr.Recorder, shim, node, corev1.EventTypeWarning, EventReasonRolloutPaused, "Rollout paused on node %s, preflight failed: %s", node.Name, preflightErr)
+		return nil
+	}
+	return jr.deleteNodeLabel(ctx, node, shimName)
+}
+
+// finishVerify records the result of a finished verify Job in the Shim
+// status and deletes the Job, so that the next verification can be run.
+// Nodes waiting for a containerd restart are labeled as provisioned once the
+// Job no longer reports the restart as pending. Provisioned nodes on which
+// drift has been found are labeled as drifted.
+func (jr *JobReconciler) finishVerify(ctx context.Context, job *batchv1.Job, node *corev1.Node, shimName string, finishedType batchv1.JobConditionType) error {
+	var drift []string
+	verifyErr := ""
+	restartPending := true
+
+	switch finishedType {
+	case batchv1.JobComplete:
+		msg, err := jr.getTerminationMessage(ctx, job)
+		if err != nil {
+			return fmt.Errorf("failed to get result of verify job: %w", err)
+		}
+		drift = msg.Drift
+		restartPending = msg.RestartPending
+	case batchv1.JobFailed:
+		failure := jr.jobFailure(ctx, job)
+		verifyErr = fmt.Sprintf("%s: %s", failure.Class, failure.Message)
+	default:
+		return nil
+	}
+
+	if err := recordVerification(ctx, jr.Client, shimName, node.Name, drift, verifyErr); err != nil {
+		return err
+	}
+
+	if !restartPending && node.Labels[shimName] == ProvisioningStatusPendingRestart {
+		logging.FromContext(ctx).Info("Containerd restarted, shim provisioned", logging.KeyShim, shimName, logging.KeyNode, node.Name)
+		if err := jr.updateNodeLabels(ctx, node, shimName, ProvisioningStatusProvisioned); err != nil {
+			return err
+		}
+	}
+
+	if len(drift) > 0 && node.Labels[shimName] == ProvisioningStatusProvisioned {
+		logging.FromContext(ctx).Info("Shim drifted", logging.KeyShim, shimName, logging.KeyNode, node.Name, "drift", drift)
+		if err := jr.updateNodeLabels(ctx, node, shimName, ProvisioningStatusDrifted); err != nil {
+			return err
+		}
+	}
+
+	if err := jr.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to delete verify job: %w", err)
+	}
+	return nil
+}
+
 func (jr *JobReconciler) updateNodeLabels(ctx context.Context, node *corev1.Node, shimName string, status string) error {
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
 	node.Labels[shimName] = status
 
 	if err := jr.Update(ctx, node); err != nil {
@@ -150,18 +361,41 @@ func (jr *JobReconciler) deleteNodeLabel(ctx context.Context, node *corev1.Node,
 func (jr *JobReconciler) getNode(ctx context.Context, nodeName string) (*corev1.Node, error) {
 	node := corev1.Node{}
 	if err := jr.Client.Get(ctx, types.NamespacedName{Name: nodeName}, &node); err != nil {
-		log.Err(err).Msg("Unable to fetch node")
-		return &corev1.Node{}, client.IgnoreNotFound(err)
+		if !apierrors.IsNotFound(err) {
+			logging.FromContext(ctx).Error("Unable to fetch node", logging.KeyNode, nodeName, "error", err)
+		}
+		return nil, fmt.Errorf("failed to fetch node: %w", err)
 	}
 	return &node, nil
 }
 
-func (jr *JobReconciler) isJobFinished(job *batchv1.Job) (bool, batchv1.JobConditionType) {
-	for _, c := range job.Status.Conditions {
-		if (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) && c.Status == corev1.ConditionTrue {
-			return true, c.Type
+// getTerminationMessage returns the result node-installer reported in the
+// termination log of the Job's pod.
+func (jr *JobReconciler) getTerminationMessage(ctx context.Context, job *batchv1.Job) (termination.Message, error) {
+	pods := &corev1.PodList{}
+	if err := jr.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{batchv1.JobNameLabel: job.Name}); err != nil {
+		return termination.Message{}, fmt.Errorf("failed to list pods of job: %w", err)
+	}
+
+	// Failed pods are retried, the last pod to terminate has the result.
+	var last *corev1.ContainerStateTerminated
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name != "provisioner" || status.State.Terminated == nil {
+				continue
+			}
+			if last == nil || status.State.Terminated.FinishedAt.After(last.FinishedAt.Time) {
+				last = status.State.Terminated
+			}
 		}
 	}
+	if last == nil {
+		return termination.Message{}, nil
+	}
 
-	return false, ""
+	msg, err := termination.Parse(last.Message)
+	if err != nil {
+		return termination.Message{}, fmt.Errorf("failed to parse termination message: %w", err)
+	}
+	return msg, nil
 }
@@ -0,0 +1,112 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller //nolint:testpackage // whitebox test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+)
+
+func TestReconcileJobNodeNotFound(t *testing.T) {
+	scheme := lifecycleScheme(t)
+	shim := &rcmv1.Shim{ObjectMeta: metav1.ObjectMeta{Name: "spin", UID: "uid"}}
+	sr := &ShimReconciler{Scheme: scheme, Config: Config{Namespace: "rcm"}}
+
+	for _, finishedType := range []batchv1.JobConditionType{batchv1.JobComplete, batchv1.JobFailed} {
+		t.Run(string(finishedType), func(t *testing.T) {
+			job, err := sr.createJobManifest(shim, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gone"}}, INSTALL)
+			require.NoError(t, err)
+			finishJob(job, finishedType, time.Now())
+			jr := &JobReconciler{Scheme: scheme, Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(shim, job).Build()}
+
+			_, err = jr.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(job)})
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestUpdateNodeLabelsWithoutLabels(t *testing.T) {
+	scheme := lifecycleScheme(t)
+	shim := &rcmv1.Shim{ObjectMeta: metav1.ObjectMeta{Name: "spin"}}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+
+	jr := &JobReconciler{Scheme: scheme, Client: c}
+	require.NoError(t, jr.updateNodeLabels(context.Background(), node.DeepCopy(), shim.Name, ProvisioningStatusProvisioned))
+
+	updated := &corev1.Node{}
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: node.Name}, updated))
+	assert.Equal(t, ProvisioningStatusProvisioned, updated.Labels[shim.Name])
+
+	sr := &ShimReconciler{Scheme: scheme, Client: c}
+	updated.Labels = nil
+	require.NoError(t, sr.updateNodeLabels(context.Background(), updated, shim, ProvisioningStatusPending))
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: node.Name}, updated))
+	assert.Equal(t, ProvisioningStatusPending, updated.Labels[shim.Name])
+}
+
+func TestFinishPreflightFailed(t *testing.T) {
+	scheme := lifecycleScheme(t)
+	shim := &rcmv1.Shim{
+		ObjectMeta: metav1.ObjectMeta{Name: "spin", UID: "uid", Generation: 2},
+		Spec:       rcmv1.ShimSpec{Preflight: true},
+	}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"spin": ProvisioningStatusPreflight}}}
+	sr := &ShimReconciler{Scheme: scheme, Config: Config{Namespace: "rcm"}}
+	job, err := sr.createJobManifest(shim, node, PREFLIGHT)
+	require.NoError(t, err)
+	finishJob(job, batchv1.JobFailed, time.Now())
+
+	recorder := record.NewFakeRecorder(10)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(shim, node, job).Build()
+	jr := &JobReconciler{Scheme: scheme, Client: c, Recorder: recorder}
+
+	for range 2 {
+		updated := &corev1.Node{}
+		require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: node.Name}, updated))
+		require.NoError(t, jr.finishPreflight(context.Background(), job, updated, shim.Name, nil))
+	}
+
+	updated := &corev1.Node{}
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: node.Name}, updated))
+	assert.Equal(t, ProvisioningStatusFailed, updated.Labels["spin"])
+
+	// The event is emitted once, on the Shim and on the Node.
+	require.Len(t, recorder.Events, 2)
+	for range 2 {
+		assert.Contains(t, <-recorder.Events, EventReasonRolloutPaused)
+	}
+
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: shim.Name}, shim))
+	assert.True(t, preflightFailed(shim, updated), "rollout paused for the current generation")
+	shim.Generation++
+	assert.False(t, preflightFailed(shim, updated), "preflight is run again once the shim changed")
+}
@@ -0,0 +1,273 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+	"github.com/spinkube/runtime-class-manager/internal/logging"
+)
+
+const (
+	// ReinstallAnnotation on a Shim or a Node requests a fresh install of
+	// the shim on the nodes. Every new value requests another reinstall.
+	ReinstallAnnotation = "rcm.spinkube.dev/reinstall"
+	// RunAnnotation is set on Jobs to the run they have been created for. A
+	// run is a generation of a Shim with the reinstalls requested for it.
+	RunAnnotation = "kwasm.sh/run"
+	// reinstalledAnnotationSuffix follows the name of the Shim in the
+	// annotation of a Node that holds the last reinstall request handled.
+	reinstalledAnnotationSuffix = ".rcm.spinkube.dev/reinstalled"
+	// runAnnotationSuffix follows the name of the Shim in the annotation of
+	// a Node that holds the run of the last install.
+	runAnnotationSuffix = ".rcm.spinkube.dev/run"
+)
+
+// reinstallToken returns the reinstall requests of the Shim and the Node.
+// It is empty if no reinstall has been requested.
+func reinstallToken(shim *rcmv1.Shim, node *corev1.Node) string {
+	shimToken, nodeToken := shim.Annotations[ReinstallAnnotation], node.Annotations[ReinstallAnnotation]
+	if shimToken == "" && nodeToken == "" {
+		return ""
+	}
+	return shimToken + "," + nodeToken
+}
+
+// jobRun returns the run of the Shim on the node. It changes with the
+// generation of the Shim and with every reinstall request.
+func jobRun(shim *rcmv1.Shim, node *corev1.Node) string {
+	sum := sha256.Sum256([]byte(string(shim.UID) + "\x00" + strconv.FormatInt(shim.Generation, 10) + "\x00" + reinstallToken(shim, node)))
+	return hex.EncodeToString(sum[:])[:hashLength]
+}
+
+// recordInstall records the run of an install and the reinstall request it
+// handles on the node, so that a failed install is not retried for the same
+// run even if its Job has been deleted.
+func recordInstall(shim *rcmv1.Shim, node *corev1.Node) {
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	node.Annotations[shim.Name+runAnnotationSuffix] = jobRun(shim, node)
+	if token := reinstallToken(shim, node); token != "" {
+		node.Annotations[shim.Name+reinstalledAnnotationSuffix] = token
+	}
+}
+
+// forgetInstall removes what recordInstall recorded on the node.
+func forgetInstall(node *corev1.Node, shimName string) {
+	delete(node.Annotations, shimName+runAnnotationSuffix)
+	delete(node.Annotations, shimName+reinstalledAnnotationSuffix)
+}
+
+// installFailed returns whether the last install of the shim failed on the
+// node for the current run.
+func installFailed(shim *rcmv1.Shim, node *corev1.Node) bool {
+	return node.Labels[shim.Name] == ProvisioningStatusFailed &&
+		node.Annotations[shim.Name+runAnnotationSuffix] == jobRun(shim, node)
+}
+
+// reinstallIfRequested starts a fresh install of the shim on a node it has
+// been installed on, or failed to install on, if a reinstall has been
+// requested since. Like for drift remediation, the node label is removed, so
+// that the node goes through the regular installation again.
+func (sr *ShimReconciler) reinstallIfRequested(ctx context.Context, shim *rcmv1.Shim, node *corev1.Node) (bool, error) {
+	switch node.Labels[shim.Name] {
+	case ProvisioningStatusProvisioned, ProvisioningStatusPendingRestart, ProvisioningStatusDrifted, ProvisioningStatusFailed:
+	default:
+		return false, nil
+	}
+	token := reinstallToken(shim, node)
+	if token == "" || node.Annotations[shim.Name+reinstalledAnnotationSuffix] == token {
+		return false, nil
+	}
+
+	logging.FromContext(ctx).Info("Reinstall requested", logging.KeyShim, shim.Name, logging.KeyNode, node.Name)
+	delete(node.Labels, shim.Name)
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	node.Annotations[shim.Name+reinstalledAnnotationSuffix] = token
+	if err := sr.Update(ctx, node); err != nil {
+		return false, fmt.Errorf("failed to reinstall shim: %w", err)
+	}
+	recordEvent(sr.Recorder, shim, node, corev1.EventTypeNormal, EventReasonReinstallRequested, "Reinstalling shim on node %s", node.Name)
+	return true, nil
+}
+
+// replaceFinishedJob makes way for job. Jobs of earlier runs are kept as
+// history, but a finished Job of the same run is deleted, as applying job
+// onto it would not run it again. It returns whether job must not be
+// deployed, because a Job of the operation is still running on the node or
+// the Job of the same run failed.
+func (sr *ShimReconciler) replaceFinishedJob(ctx context.Context, job *batchv1.Job) (bool, error) {
+	log := logging.FromContext(ctx)
+
+	jobs, err := findJobs(ctx, sr.Client, job.Namespace, job.Annotations["kwasm.sh/nodeName"],
+		job.Annotations["kwasm.sh/shimName"], job.Annotations["kwasm.sh/operation"])
+	if err != nil {
+		return false, err
+	}
+	var existing *batchv1.Job
+	for i := range jobs {
+		if finished, _ := isJobFinished(&jobs[i]); !finished {
+			log.Info("Job is still running", logging.KeyJob, jobs[i].Name)
+			return true, nil
+		}
+		if jobs[i].Name == job.Name {
+			existing = &jobs[i]
+		}
+	}
+
+	if existing == nil {
+		return false, nil
+	}
+	if existing.DeletionTimestamp != nil {
+		log.Info("Job is being deleted", logging.KeyJob, existing.Name)
+		return true, nil
+	}
+	if _, finishedType := isJobFinished(existing); finishedType == batchv1.JobFailed {
+		log.Info("Job failed, not running it again for the same run", logging.KeyJob, existing.Name)
+		return true, nil
+	}
+
+	log.Info("Deleting finished Job", logging.KeyJob, existing.Name)
+	if err := sr.Delete(ctx, existing, client.Preconditions{UID: &existing.UID},
+		client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+		return false, fmt.Errorf("failed to delete finished job: %w", err)
+	}
+	return false, nil
+}
+
+// superseded returns whether job has been created for an earlier run than
+// the current run of its Shim on node, i.e. the Shim has been changed or a
+// reinstall has been requested since. The outcome of a superseded Job is
+// history and must not change the state of the node, e.g. when it is
+// reconciled again after a restart of the controller. Jobs of a Shim that is
+// gone and Jobs without a run are not superseded.
+func (jr *JobReconciler) superseded(ctx context.Context, job *batchv1.Job, node *corev1.Node) (bool, error) {
+	run, ok := job.Annotations[RunAnnotation]
+	if !ok {
+		return false, nil
+	}
+	shim := &rcmv1.Shim{}
+	if err := jr.Get(ctx, types.NamespacedName{Name: job.Annotations["kwasm.sh/shimName"]}, shim); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return run != jobRun(shim, node), nil
+}
+
+// cleanupJobHistory deletes the finished Jobs of the operation of current on
+// its node beyond the job history limits of the Shim. The most recently
+// finished Jobs are kept and current, the Job being reconciled, is never
+// deleted. Verify Jobs are deleted as soon as they finish and are not
+// counted.
+func (jr *JobReconciler) cleanupJobHistory(ctx context.Context, shimName string, current *batchv1.Job) error {
+	shim := &rcmv1.Shim{}
+	if err := jr.Get(ctx, types.NamespacedName{Name: shimName}, shim); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	limits := shim.Spec.JobHistoryLimits
+	if limits == nil || (limits.Successful == nil && limits.Failed == nil) {
+		return nil
+	}
+	operation := current.Annotations["kwasm.sh/operation"]
+	if operation == VERIFY {
+		return nil
+	}
+
+	jobs, err := findJobs(ctx, jr.Client, current.Namespace, current.Annotations["kwasm.sh/nodeName"], shimName, operation)
+	if err != nil {
+		return err
+	}
+	var succeeded, failed []batchv1.Job
+	for _, job := range jobs {
+		if job.DeletionTimestamp != nil {
+			continue
+		}
+		switch _, finishedType := isJobFinished(&job); finishedType {
+		case batchv1.JobComplete:
+			succeeded = append(succeeded, job)
+		case batchv1.JobFailed:
+			failed = append(failed, job)
+		}
+	}
+
+	return errors.Join(
+		jr.deleteOldJobs(ctx, succeeded, limits.Successful, current),
+		jr.deleteOldJobs(ctx, failed, limits.Failed, current),
+	)
+}
+
+// deleteOldJobs deletes all but the limit most recently finished jobs. A nil
+// limit keeps all jobs.
+func (jr *JobReconciler) deleteOldJobs(ctx context.Context, jobs []batchv1.Job, limit *int32, current *batchv1.Job) error {
+	if limit == nil || len(jobs) <= int(*limit) {
+		return nil
+	}
+	slices.SortFunc(jobs, func(a, b batchv1.Job) int {
+		return jobFinishTime(&b).Compare(jobFinishTime(&a))
+	})
+
+	var errs []error
+	for i := int(*limit); i < len(jobs); i++ {
+		job := &jobs[i]
+		if job.UID == current.UID {
+			continue
+		}
+		logging.FromContext(ctx).Info("Deleting Job beyond history limit", logging.KeyJob, job.Name)
+		if err := jr.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			errs = append(errs, fmt.Errorf("failed to delete job %s: %w", job.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// jobFinishTime returns when a finished Job finished.
+func jobFinishTime(job *batchv1.Job) time.Time {
+	for _, c := range job.Status.Conditions {
+		if (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) && c.Status == corev1.ConditionTrue {
+			return c.LastTransitionTime.Time
+		}
+	}
+	return time.Time{}
+}
+
+// isJobFinished returns whether a Job has finished and whether it completed
+// or failed.
+func isJobFinished(job *batchv1.Job) (bool, batchv1.JobConditionType) {
+	for _, c := range job.Status.Conditions {
+		if (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) && c.Status == corev1.ConditionTrue {
+			return true, c.Type
+		}
+	}
+
+	return false, ""
+}
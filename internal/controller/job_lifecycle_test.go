@@ -0,0 +1,341 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller //nolint:testpackage // whitebox test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+)
+
+func lifecycleScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, rcmv1.AddToScheme(scheme))
+	return scheme
+}
+
+// finishJob marks job as finished with the condition finishedType at
+// finishedAt.
+func finishJob(job *batchv1.Job, finishedType batchv1.JobConditionType, finishedAt time.Time) *batchv1.Job {
+	job.Status.Conditions = []batchv1.JobCondition{{
+		Type:               finishedType,
+		Status:             corev1.ConditionTrue,
+		LastTransitionTime: metav1.NewTime(finishedAt),
+	}}
+	return job
+}
+
+func TestJobRun(t *testing.T) {
+	shim := &rcmv1.Shim{ObjectMeta: metav1.ObjectMeta{Name: "spin", UID: "uid", Generation: 1}}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	run := jobRun(shim, node)
+
+	assert.Equal(t, run, jobRun(shim.DeepCopy(), node.DeepCopy()), "run is stable")
+
+	changed := shim.DeepCopy()
+	changed.Generation = 2
+	assert.NotEqual(t, run, jobRun(changed, node), "new generation")
+
+	reinstalled := shim.DeepCopy()
+	reinstalled.Annotations = map[string]string{ReinstallAnnotation: "1"}
+	assert.NotEqual(t, run, jobRun(reinstalled, node), "reinstall of shim")
+
+	reinstalledNode := node.DeepCopy()
+	reinstalledNode.Annotations = map[string]string{ReinstallAnnotation: "1"}
+	assert.NotEqual(t, run, jobRun(shim, reinstalledNode), "reinstall on node")
+	assert.NotEqual(t, jobRun(reinstalled, node), jobRun(shim, reinstalledNode), "shim and node requests differ")
+}
+
+func TestReplaceFinishedJob(t *testing.T) {
+	scheme := lifecycleScheme(t)
+	shim := &rcmv1.Shim{ObjectMeta: metav1.ObjectMeta{Name: "spin", UID: "uid", Generation: 2}}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	sr := &ShimReconciler{Scheme: scheme, Config: Config{Namespace: "rcm"}}
+
+	job, err := sr.createJobManifest(shim, node, INSTALL)
+	require.NoError(t, err)
+	previous := shim.DeepCopy()
+	previous.Generation = 1
+	previousJob, err := sr.createJobManifest(previous, node, INSTALL)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		existing    *batchv1.Job
+		wantSkip    bool
+		wantDeleted bool
+	}{
+		{"no job", nil, false, false},
+		{"running", job.DeepCopy(), true, false},
+		{"running previous run", previousJob.DeepCopy(), true, false},
+		{"succeeded", finishJob(job.DeepCopy(), batchv1.JobComplete, time.Now()), false, true},
+		{"failed", finishJob(job.DeepCopy(), batchv1.JobFailed, time.Now()), true, false},
+		{"succeeded previous run", finishJob(previousJob.DeepCopy(), batchv1.JobComplete, time.Now()), false, false},
+		{"failed previous run", finishJob(previousJob.DeepCopy(), batchv1.JobFailed, time.Now()), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder().WithScheme(scheme)
+			if tt.existing != nil {
+				tt.existing.UID = "job-uid"
+				builder = builder.WithObjects(tt.existing)
+			}
+			sr.Client = builder.Build()
+
+			skip, err := sr.replaceFinishedJob(context.Background(), job.DeepCopy())
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantSkip, skip)
+
+			if tt.existing != nil {
+				err = sr.Get(context.Background(), client.ObjectKeyFromObject(tt.existing), &batchv1.Job{})
+				assert.Equal(t, tt.wantDeleted, apierrors.IsNotFound(err))
+			}
+		})
+	}
+}
+
+func TestReinstallIfRequested(t *testing.T) {
+	scheme := lifecycleScheme(t)
+
+	tests := []struct {
+		name            string
+		shimToken       string
+		nodeToken       string
+		status          string
+		reinstalled     string
+		wantReinstalled bool
+	}{
+		{"not requested", "", "", ProvisioningStatusProvisioned, "", false},
+		{"requested on shim", "1", "", ProvisioningStatusProvisioned, "", true},
+		{"requested on node", "", "1", ProvisioningStatusProvisioned, "", true},
+		{"already handled", "1", "", ProvisioningStatusProvisioned, "1,", false},
+		{"requested again", "2", "", ProvisioningStatusProvisioned, "1,", true},
+		{"failed node", "1", "", ProvisioningStatusFailed, "", true},
+		{"drifted node", "1", "", ProvisioningStatusDrifted, "", true},
+		{"installing node", "1", "", ProvisioningStatusPending, "", false},
+		{"uninstalling node", "1", "", UNINSTALL, "", false},
+		{"new node", "1", "", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shim := &rcmv1.Shim{ObjectMeta: metav1.ObjectMeta{Name: "spin", Annotations: map[string]string{ReinstallAnnotation: tt.shimToken}}}
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:        "node-1",
+				Labels:      map[string]string{},
+				Annotations: map[string]string{ReinstallAnnotation: tt.nodeToken},
+			}}
+			if tt.status != "" {
+				node.Labels["spin"] = tt.status
+			}
+			if tt.reinstalled != "" {
+				node.Annotations["spin"+reinstalledAnnotationSuffix] = tt.reinstalled
+			}
+			sr := &ShimReconciler{Scheme: scheme, Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()}
+
+			reinstalled, err := sr.reinstallIfRequested(context.Background(), shim, node.DeepCopy())
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantReinstalled, reinstalled)
+
+			got := &corev1.Node{}
+			require.NoError(t, sr.Get(context.Background(), types.NamespacedName{Name: "node-1"}, got))
+			_, labeled := got.Labels["spin"]
+			assert.Equal(t, tt.wantReinstalled, !labeled && tt.status != "", "node label removed")
+			if tt.wantReinstalled {
+				assert.Equal(t, reinstallToken(shim, node), got.Annotations["spin"+reinstalledAnnotationSuffix])
+			}
+		})
+	}
+}
+
+func TestInstallFailed(t *testing.T) {
+	shim := &rcmv1.Shim{ObjectMeta: metav1.ObjectMeta{Name: "spin", UID: "uid", Generation: 1}}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{}}}
+	recordInstall(shim, node)
+
+	node.Labels["spin"] = ProvisioningStatusFailed
+	assert.True(t, installFailed(shim, node), "failed for the current run")
+
+	changed := shim.DeepCopy()
+	changed.Generation = 2
+	assert.False(t, installFailed(changed, node), "shim changed since")
+
+	node.Labels["spin"] = ProvisioningStatusProvisioned
+	assert.False(t, installFailed(shim, node), "installed")
+
+	forgetInstall(node, "spin")
+	assert.Empty(t, node.Annotations)
+}
+
+func TestSuperseded(t *testing.T) {
+	scheme := lifecycleScheme(t)
+	shim := &rcmv1.Shim{ObjectMeta: metav1.ObjectMeta{Name: "spin", UID: "uid", Generation: 1}}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	sr := &ShimReconciler{Scheme: scheme, Config: Config{Namespace: "rcm"}}
+
+	job, err := sr.createJobManifest(shim, node, INSTALL)
+	require.NoError(t, err)
+	withoutRun := job.DeepCopy()
+	delete(withoutRun.Annotations, RunAnnotation)
+
+	changed := shim.DeepCopy()
+	changed.Generation = 2
+	reinstalled := shim.DeepCopy()
+	reinstalled.Annotations = map[string]string{ReinstallAnnotation: "1"}
+
+	tests := []struct {
+		name string
+		shim *rcmv1.Shim
+		job  *batchv1.Job
+		want bool
+	}{
+		{"current run", shim, job, false},
+		{"shim changed", changed, job, true},
+		{"reinstall requested", reinstalled, job, true},
+		{"shim gone", nil, job, false},
+		{"job without run", changed, withoutRun, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder().WithScheme(scheme)
+			if tt.shim != nil {
+				builder = builder.WithObjects(tt.shim)
+			}
+			jr := &JobReconciler{Scheme: scheme, Client: builder.Build()}
+
+			superseded, err := jr.superseded(context.Background(), tt.job, node)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, superseded)
+		})
+	}
+}
+
+func TestCleanupJobHistory(t *testing.T) {
+	scheme := lifecycleScheme(t)
+	now := time.Now()
+	sr := &ShimReconciler{Scheme: scheme, Config: Config{Namespace: "rcm"}}
+
+	// job returns a finished job of a generation of the shim on a node, which
+	// finished the given number of minutes ago.
+	job := func(shim *rcmv1.Shim, generation int64, nodeName, operation string, finishedType batchv1.JobConditionType, minutesAgo int) *batchv1.Job {
+		shim = shim.DeepCopy()
+		shim.Generation = generation
+		job, err := sr.createJobManifest(shim, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}, operation)
+		require.NoError(t, err)
+		job.UID = types.UID(job.Name)
+		return finishJob(job, finishedType, now.Add(-time.Duration(minutesAgo)*time.Minute))
+	}
+
+	all := []string{"node-1 gen 1", "node-1 gen 2", "node-1 gen 3", "node-1 gen 4", "node-1 preflight", "node-2 gen 1", "node-2 gen 2"}
+	tests := []struct {
+		name       string
+		limits     *rcmv1.JobHistoryLimits
+		current    string
+		wantKept   []string
+		wantDelete []string
+	}{
+		{
+			name:     "no limits",
+			current:  "node-1 gen 4",
+			wantKept: all,
+		},
+		{
+			name:       "keep newest of node and operation",
+			limits:     &rcmv1.JobHistoryLimits{Successful: ptr(int32(1)), Failed: ptr(int32(1))},
+			current:    "node-1 gen 4",
+			wantKept:   []string{"node-1 gen 2", "node-1 gen 4", "node-1 preflight", "node-2 gen 1", "node-2 gen 2"},
+			wantDelete: []string{"node-1 gen 1", "node-1 gen 3"},
+		},
+		{
+			name:       "only failed limited",
+			limits:     &rcmv1.JobHistoryLimits{Failed: ptr(int32(0))},
+			current:    "node-1 gen 4",
+			wantKept:   []string{"node-1 gen 1", "node-1 gen 3", "node-1 gen 4", "node-1 preflight", "node-2 gen 1", "node-2 gen 2"},
+			wantDelete: []string{"node-1 gen 2"},
+		},
+		{
+			name:       "current job is kept",
+			limits:     &rcmv1.JobHistoryLimits{Successful: ptr(int32(0)), Failed: ptr(int32(0))},
+			current:    "node-1 gen 4",
+			wantKept:   []string{"node-1 gen 4", "node-1 preflight", "node-2 gen 1", "node-2 gen 2"},
+			wantDelete: []string{"node-1 gen 1", "node-1 gen 2", "node-1 gen 3"},
+		},
+		{
+			name:       "other node",
+			limits:     &rcmv1.JobHistoryLimits{Successful: ptr(int32(0)), Failed: ptr(int32(0))},
+			current:    "node-2 gen 1",
+			wantKept:   []string{"node-1 gen 1", "node-1 gen 2", "node-1 gen 3", "node-1 gen 4", "node-1 preflight", "node-2 gen 1"},
+			wantDelete: []string{"node-2 gen 2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shim := &rcmv1.Shim{ObjectMeta: metav1.ObjectMeta{Name: "spin", UID: "uid"}, Spec: rcmv1.ShimSpec{JobHistoryLimits: tt.limits}}
+			other := &rcmv1.Shim{ObjectMeta: metav1.ObjectMeta{Name: "other", UID: "other-uid"}}
+			jobs := map[string]*batchv1.Job{
+				"node-1 gen 1":     job(shim, 1, "node-1", INSTALL, batchv1.JobComplete, 40),
+				"node-1 gen 2":     job(shim, 2, "node-1", INSTALL, batchv1.JobFailed, 30),
+				"node-1 gen 3":     job(shim, 3, "node-1", INSTALL, batchv1.JobComplete, 20),
+				"node-1 gen 4":     job(shim, 4, "node-1", INSTALL, batchv1.JobComplete, 1),
+				"node-1 preflight": job(shim, 4, "node-1", PREFLIGHT, batchv1.JobComplete, 2),
+				"node-2 gen 1":     job(shim, 1, "node-2", INSTALL, batchv1.JobComplete, 40),
+				"node-2 gen 2":     job(shim, 2, "node-2", INSTALL, batchv1.JobFailed, 30),
+			}
+			running, err := sr.createJobManifest(shim, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}, UNINSTALL)
+			require.NoError(t, err)
+			verify := job(shim, 4, "node-1", VERIFY, batchv1.JobComplete, 10)
+			otherShim := job(other, 1, "node-1", INSTALL, batchv1.JobComplete, 10)
+			objects := []client.Object{shim, running, verify, otherShim}
+			for _, job := range jobs {
+				objects = append(objects, job)
+			}
+			jr := &JobReconciler{Scheme: scheme, Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()}
+
+			require.NoError(t, jr.cleanupJobHistory(context.Background(), "spin", jobs[tt.current]))
+
+			exists := func(job *batchv1.Job) bool {
+				err := jr.Get(context.Background(), client.ObjectKeyFromObject(job), &batchv1.Job{})
+				require.NoError(t, client.IgnoreNotFound(err))
+				return err == nil
+			}
+			for _, name := range tt.wantKept {
+				assert.True(t, exists(jobs[name]), "job %s kept", name)
+			}
+			for _, name := range tt.wantDelete {
+				assert.False(t, exists(jobs[name]), "job %s deleted", name)
+			}
+			assert.True(t, exists(running), "running job kept")
+			assert.True(t, exists(verify), "verify job kept")
+			assert.True(t, exists(otherShim), "job of other shim kept")
+		})
+	}
+}
@@ -0,0 +1,92 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Labels of node-installer Jobs, used to find the Jobs of a Shim, Node and
+// operation.
+const (
+	LabelNode      = "kwasm.sh/node"
+	LabelShimName  = "kwasm.sh/shimName"
+	LabelOperation = "kwasm.sh/operation"
+)
+
+// hashLength is the number of hex digits of the hash that makes shortened
+// names unique.
+const hashLength = 10
+
+// jobName returns the name of the Job of an operation of a Shim on a node in
+// a run. The name is unique for every node, Shim, operation and run, and at
+// most K8sNameMaxLength characters long, so that it can be used as label
+// value of the pods of the Job.
+func jobName(nodeName, shimName, operation, run string) string {
+	sum := sha256.Sum256([]byte(nodeName + "\x00" + shimName + "\x00" + operation + "\x00" + run))
+	hash := hex.EncodeToString(sum[:])[:hashLength]
+	return shorten(nodeName+"-"+shimName+"-"+operation, K8sNameMaxLength-hashLength-1) + "-" + hash
+}
+
+// labelValue returns s as label value. Values longer than K8sNameMaxLength
+// are shortened and made unique with a hash of s.
+func labelValue(s string) string {
+	if len(s) <= K8sNameMaxLength {
+		return s
+	}
+	sum := sha256.Sum256([]byte(s))
+	return shorten(s, K8sNameMaxLength-hashLength-1) + "-" + hex.EncodeToString(sum[:])[:hashLength]
+}
+
+// shorten cuts s to at most maxLength characters and removes separators it
+// would end with.
+func shorten(s string, maxLength int) string {
+	if len(s) > maxLength {
+		s = s[:maxLength]
+	}
+	return strings.TrimRight(s, "-.")
+}
+
+// jobLabels returns the labels that identify the Job of an operation of a
+// Shim on a node.
+func jobLabels(nodeName, shimName, operation string) client.MatchingLabels {
+	labels := client.MatchingLabels{LabelShimName: labelValue(shimName)}
+	if nodeName != "" {
+		labels[LabelNode] = labelValue(nodeName)
+	}
+	if operation != "" {
+		labels[LabelOperation] = operation
+	}
+	return labels
+}
+
+// findJobs returns the Jobs in namespace that match the node, Shim and
+// operation. An empty node or operation matches all.
+func findJobs(ctx context.Context, c client.Client, namespace, nodeName, shimName, operation string) ([]batchv1.Job, error) {
+	jobs := &batchv1.JobList{}
+	if err := c.List(ctx, jobs, client.InNamespace(namespace), jobLabels(nodeName, shimName, operation)); err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	return jobs.Items, nil
+}
@@ -0,0 +1,130 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller //nolint:testpackage // whitebox test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+)
+
+func TestJobName(t *testing.T) {
+	longNode := "ip-10-0-0-1." + strings.Repeat("compute-", 8) + "internal"
+
+	tests := []struct {
+		name      string
+		nodeName  string
+		shimName  string
+		operation string
+		run       string
+	}{
+		{"short", "node-1", "spin", INSTALL, "run-1"},
+		{"other run", "node-1", "spin", INSTALL, "run-2"},
+		{"long node", longNode, "spin-v2", INSTALL, "run-1"},
+		{"long node, other shim", longNode, "spin-v3", INSTALL, "run-1"},
+		{"long node, other operation", longNode, "spin-v2", UNINSTALL, "run-1"},
+		{"long shim", "node-1", strings.Repeat("s", 100), VERIFY, "run-1"},
+		{"cut at separator", strings.Repeat("n", 51) + "-x", "spin", INSTALL, "run-1"},
+	}
+	names := map[string]string{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := jobName(tt.nodeName, tt.shimName, tt.operation, tt.run)
+
+			assert.LessOrEqual(t, len(name), K8sNameMaxLength)
+			assert.Empty(t, validation.IsDNS1123Subdomain(name), "name is a valid Job name")
+			assert.Empty(t, validation.IsValidLabelValue(name), "name is a valid label value")
+			assert.Equal(t, name, jobName(tt.nodeName, tt.shimName, tt.operation, tt.run), "name is stable")
+
+			other, exists := names[name]
+			assert.False(t, exists, "name collides with %s", other)
+			names[name] = tt.name
+		})
+	}
+
+	assert.True(t, strings.HasPrefix(jobName("node-1", "spin", INSTALL, "run-1"), "node-1-spin-install-"))
+}
+
+func TestLabelValue(t *testing.T) {
+	long := strings.Repeat("a", 100)
+
+	assert.Equal(t, "node-1", labelValue("node-1"))
+	assert.Len(t, labelValue(long), K8sNameMaxLength)
+	assert.Empty(t, validation.IsValidLabelValue(labelValue(long)))
+	assert.NotEqual(t, labelValue(long), labelValue(long+"b"))
+}
+
+func TestFindJobs(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, rcmv1.AddToScheme(scheme))
+	longNode := strings.Repeat("node-", 20) + "1"
+	sr := &ShimReconciler{Scheme: scheme, Config: Config{Namespace: "rcm"}}
+	shim := &rcmv1.Shim{ObjectMeta: metav1.ObjectMeta{Name: "spin", UID: "uid"}}
+	run := jobRun(shim, &corev1.Node{})
+
+	var objects []runtime.Object
+	for _, nodeName := range []string{"node-1", "node-2", longNode} {
+		for _, operation := range []string{INSTALL, VERIFY} {
+			job, err := sr.createJobManifest(shim, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}, operation)
+			require.NoError(t, err)
+			objects = append(objects, job)
+		}
+	}
+	sr.Client = fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objects...).Build()
+
+	tests := []struct {
+		name      string
+		nodeName  string
+		operation string
+		want      []string
+	}{
+		{"node and operation", "node-1", VERIFY, []string{jobName("node-1", "spin", VERIFY, run)}},
+		{"long node", longNode, INSTALL, []string{jobName(longNode, "spin", INSTALL, run)}},
+		{"all operations of node", "node-2", "", []string{jobName("node-2", "spin", INSTALL, run), jobName("node-2", "spin", VERIFY, run)}},
+		{"no jobs", "node-3", INSTALL, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs, err := findJobs(context.Background(), sr.Client, "rcm", tt.nodeName, "spin", tt.operation)
+			require.NoError(t, err)
+
+			var names []string
+			for _, job := range jobs {
+				names = append(names, job.Name)
+			}
+			assert.ElementsMatch(t, tt.want, names)
+		})
+	}
+}
+
+func TestIsShimJob(t *testing.T) {
+	assert.True(t, isShimJob(&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{LabelShimName: "spin"}}}))
+	assert.False(t, isShimJob(&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "backup"}}}))
+}
@@ -0,0 +1,173 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/spinkube/runtime-class-manager/internal/preset"
+)
+
+const (
+	// nodeRootMountPath is where the host is mounted in node-installer
+	// pods. node-installer is started with it as host root.
+	nodeRootMountPath = "/mnt/node-root"
+	// kwasmHostPath is the working directory of node-installer on the host.
+	kwasmHostPath = "/opt/kwasm"
+	// downloaderUID is the user the downloader runs as.
+	downloaderUID = 65532
+)
+
+// DistroLabel on a Node names the distro preset of the node, e.g. k3s, for
+// distros that cannot be told from the node info.
+const DistroLabel = "rcm.spinkube.dev/distro"
+
+// errUnknownDistro is returned for nodes labeled with a distro that has no
+// preset.
+var errUnknownDistro = errors.New("unknown distro")
+
+// provisionerCapabilities are the capabilities of unprivileged
+// node-installer pods. KILL signals containerd on distros that do not run it
+// with systemd, DAC_OVERRIDE writes runtime configs not owned by root.
+var provisionerCapabilities = []corev1.Capability{"KILL", "DAC_OVERRIDE"}
+
+// nodeDistro returns the distro preset of a node. The preset is taken from
+// DistroLabel or recognized from the kubelet version and OS image the node
+// reports, nodes of unrecognized distros get the default preset.
+func nodeDistro(node *corev1.Node) (preset.Settings, error) {
+	if name, ok := node.Labels[DistroLabel]; ok {
+		distro, ok := preset.ByName(name)
+		if !ok {
+			return preset.Settings{}, fmt.Errorf("%w %q in label %s of node %s", errUnknownDistro, name, DistroLabel, node.Name)
+		}
+		return distro, nil
+	}
+
+	info := node.Status.NodeInfo
+	switch {
+	case strings.Contains(info.KubeletVersion, "+k3s"):
+		return preset.K3s, nil
+	case strings.Contains(info.KubeletVersion, "+rke2"):
+		return preset.RKE2, nil
+	case strings.Contains(info.KubeletVersion, "+k0s"):
+		return preset.K0s, nil
+	case strings.HasPrefix(info.OSImage, "Talos"):
+		return preset.Talos, nil
+	case strings.HasPrefix(info.OSImage, "Bottlerocket"):
+		return preset.Bottlerocket, nil
+	default:
+		return preset.Default, nil
+	}
+}
+
+// needsHostPID returns whether node-installer needs the host PID namespace.
+// containerd is found and signaled through its process on distros that do
+// not run it with systemd, systemd restarts go over the system bus.
+// Privileged jobs detect the distro themselves and always get it.
+func needsHostPID(privileged bool, distro preset.Settings) bool {
+	return privileged || len(distro.SystemdUnits) == 0
+}
+
+// hostVolumes returns the volumes and mounts that give node-installer access
+// to the host. Privileged jobs mount the whole host, unprivileged jobs only
+// the kwasm path, the host paths of the distro and the configured host
+// paths, at the same location below the host root. The paths are created if
+// missing, so that a path a node does not have does not keep the pod from
+// starting.
+func (sr *ShimReconciler) hostVolumes(privileged bool, distro preset.Settings) ([]corev1.Volume, []corev1.VolumeMount) {
+	if privileged {
+		return []corev1.Volume{{
+			Name: "root-mount",
+			VolumeSource: corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{Path: "/"},
+			},
+		}}, []corev1.VolumeMount{{
+			Name:      "root-mount",
+			MountPath: nodeRootMountPath,
+		}}
+	}
+
+	volumes := []corev1.Volume{{
+		Name: "kwasm",
+		VolumeSource: corev1.VolumeSource{
+			HostPath: &corev1.HostPathVolumeSource{Path: kwasmHostPath, Type: ptr(corev1.HostPathDirectoryOrCreate)},
+		},
+	}}
+	mounts := []corev1.VolumeMount{{
+		Name:      "kwasm",
+		MountPath: path.Join(nodeRootMountPath, kwasmHostPath),
+	}}
+	var hostPaths []string
+	for _, hostPath := range append(distro.HostPaths(), sr.Config.HostPaths...) {
+		if !slices.Contains(hostPaths, hostPath) {
+			hostPaths = append(hostPaths, hostPath)
+		}
+	}
+	for i, hostPath := range hostPaths {
+		name := fmt.Sprintf("host-path-%d", i)
+		volumes = append(volumes, corev1.Volume{
+			Name: name,
+			VolumeSource: corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{Path: hostPath, Type: ptr(corev1.HostPathDirectoryOrCreate)},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      name,
+			MountPath: path.Join(nodeRootMountPath, hostPath),
+		})
+	}
+	return volumes, mounts
+}
+
+// provisionerSecurityContext returns the security context of the
+// node-installer container.
+func provisionerSecurityContext(privileged bool) *corev1.SecurityContext {
+	if privileged {
+		return &corev1.SecurityContext{Privileged: ptr(true)}
+	}
+	return &corev1.SecurityContext{
+		Privileged:               ptr(false),
+		AllowPrivilegeEscalation: ptr(false),
+		ReadOnlyRootFilesystem:   ptr(true),
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+			Add:  provisionerCapabilities,
+		},
+		SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}
+}
+
+// downloaderSecurityContext returns the security context of the downloader.
+// It only writes to an emptyDir, so it runs without any privileges.
+func downloaderSecurityContext() *corev1.SecurityContext {
+	return &corev1.SecurityContext{
+		RunAsNonRoot:             ptr(true),
+		RunAsUser:                ptr(int64(downloaderUID)),
+		AllowPrivilegeEscalation: ptr(false),
+		ReadOnlyRootFilesystem:   ptr(true),
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+		SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}
+}
@@ -0,0 +1,178 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller //nolint:testpackage // whitebox test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+)
+
+func TestJobManifestSecurity(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, rcmv1.AddToScheme(scheme))
+	shim := &rcmv1.Shim{
+		ObjectMeta: metav1.ObjectMeta{Name: "spin", UID: "uid"},
+		Spec: rcmv1.ShimSpec{
+			FetchStrategy: rcmv1.FetchStrategy{
+				Type:     "anonymousHttp",
+				AnonHTTP: rcmv1.AnonHTTPSpec{Location: "https://example.com/shim.tar.gz"},
+			},
+		},
+	}
+	node := func(kubeletVersion string, labels map[string]string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: labels},
+			Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{KubeletVersion: kubeletVersion}},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		privileged  bool
+		hostPaths   []string
+		node        *corev1.Node
+		wantMounts  map[string]string
+		wantDistro  string
+		wantHostPID bool
+	}{
+		{"unprivileged", false, nil, node("v1.30.0", nil), map[string]string{
+			"/assets":                       "",
+			"/mnt/node-root/opt/kwasm":      "/opt/kwasm",
+			"/mnt/node-root/etc/containerd": "/etc/containerd",
+			"/mnt/node-root/run/containerd": "/run/containerd",
+			"/mnt/node-root/run/dbus":       "/run/dbus",
+		}, "default", false},
+		{"unprivileged k3s", false, nil, node("v1.30.0+k3s1", nil), map[string]string{
+			"/assets":                  "",
+			"/mnt/node-root/opt/kwasm": "/opt/kwasm",
+			"/mnt/node-root/var/lib/rancher/k3s/agent/etc/containerd": "/var/lib/rancher/k3s/agent/etc/containerd",
+			"/mnt/node-root/run/k3s/containerd":                       "/run/k3s/containerd",
+			"/mnt/node-root/run/dbus":                                 "/run/dbus",
+		}, "k3s", false},
+		{"unprivileged labeled k3d", false, nil, node("v1.30.0+k3s1", map[string]string{DistroLabel: "k3d"}), map[string]string{
+			"/assets":                  "",
+			"/mnt/node-root/opt/kwasm": "/opt/kwasm",
+			"/mnt/node-root/var/lib/rancher/k3s/agent/etc/containerd": "/var/lib/rancher/k3s/agent/etc/containerd",
+			"/mnt/node-root/run/k3s/containerd":                       "/run/k3s/containerd",
+		}, "k3d", true},
+		{"unprivileged labeled microk8s", false, nil, node("v1.30.0", map[string]string{DistroLabel: "microk8s"}), map[string]string{
+			"/assets":                  "",
+			"/mnt/node-root/opt/kwasm": "/opt/kwasm",
+			"/mnt/node-root/var/snap/microk8s/current/args": "/var/snap/microk8s/current/args",
+			"/mnt/node-root/var/snap/microk8s/common/run":   "/var/snap/microk8s/common/run",
+			"/mnt/node-root/run/dbus":                       "/run/dbus",
+		}, "microk8s", false},
+		{"unprivileged with host paths", false, []string{"/run/dbus", "/etc/ssl"}, node("v1.30.0", nil), map[string]string{
+			"/assets":                       "",
+			"/mnt/node-root/opt/kwasm":      "/opt/kwasm",
+			"/mnt/node-root/etc/containerd": "/etc/containerd",
+			"/mnt/node-root/run/containerd": "/run/containerd",
+			"/mnt/node-root/run/dbus":       "/run/dbus",
+			"/mnt/node-root/etc/ssl":        "/etc/ssl",
+		}, "default", false},
+		{"privileged", true, nil, node("v1.30.0+k3s1", nil), map[string]string{
+			"/assets":        "",
+			"/mnt/node-root": "/",
+		}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sr := &ShimReconciler{Scheme: scheme, Config: Config{PrivilegedJobs: tt.privileged, HostPaths: tt.hostPaths}}
+
+			job, err := sr.createJobManifest(shim, tt.node, INSTALL)
+			require.NoError(t, err)
+
+			pod := job.Spec.Template.Spec
+			hostPaths := map[string]string{}
+			for _, volume := range pod.Volumes {
+				hostPaths[volume.Name] = ""
+				if volume.HostPath != nil {
+					hostPaths[volume.Name] = volume.HostPath.Path
+				}
+			}
+			mounts := map[string]string{}
+			for _, mount := range pod.Containers[0].VolumeMounts {
+				mounts[mount.MountPath] = hostPaths[mount.Name]
+			}
+			assert.Equal(t, tt.wantMounts, mounts)
+			if !tt.privileged {
+				for _, volume := range pod.Volumes {
+					if volume.HostPath != nil {
+						assert.Equal(t, corev1.HostPathDirectoryOrCreate, *volume.HostPath.Type, "missing host paths are created")
+					}
+				}
+			}
+
+			args := pod.Containers[0].Args
+			if tt.wantDistro == "" {
+				assert.NotContains(t, args, "--distro")
+			} else {
+				assert.Subset(t, args, []string{"--distro", tt.wantDistro})
+			}
+
+			assert.Equal(t, tt.wantHostPID, pod.HostPID, "only jobs that signal containerd share the host PID namespace")
+
+			provisioner := pod.Containers[0].SecurityContext
+			assert.Equal(t, tt.privileged, *provisioner.Privileged)
+			if !tt.privileged {
+				assert.False(t, *provisioner.AllowPrivilegeEscalation)
+				assert.Equal(t, []corev1.Capability{"ALL"}, provisioner.Capabilities.Drop)
+				assert.Equal(t, []corev1.Capability{"KILL", "DAC_OVERRIDE"}, provisioner.Capabilities.Add)
+			}
+
+			downloader := pod.InitContainers[0].SecurityContext
+			assert.Nil(t, downloader.Privileged, "the downloader is never privileged")
+			assert.True(t, *downloader.RunAsNonRoot)
+			assert.False(t, *downloader.AllowPrivilegeEscalation)
+			assert.Equal(t, []corev1.Capability{"ALL"}, downloader.Capabilities.Drop)
+		})
+	}
+}
+
+func TestJobManifestUnknownDistro(t *testing.T) {
+	scheme := lifecycleScheme(t)
+	shim := &rcmv1.Shim{ObjectMeta: metav1.ObjectMeta{Name: "spin", UID: "uid"}}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{DistroLabel: "windows"}}}
+
+	sr := &ShimReconciler{Scheme: scheme, Config: Config{PrivilegedJobs: true}}
+	_, err := sr.createJobManifest(shim, node, UNINSTALL)
+	require.NoError(t, err, "privileged jobs ignore the distro label")
+
+	sr.Config.PrivilegedJobs = false
+	_, err = sr.createJobManifest(shim, node, UNINSTALL)
+	require.ErrorIs(t, err, errUnknownDistro)
+	require.ErrorContains(t, err, `unknown distro "windows"`)
+
+	recorder := record.NewFakeRecorder(10) //nolint:mnd // buffer for all events of the test
+	sr.Recorder = recorder
+	sr.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	require.NoError(t, sr.deployJobOnNode(context.Background(), shim, *node, INSTALL), "a bad label is no reconcile error")
+	require.NotEmpty(t, recorder.Events)
+	assert.Contains(t, <-recorder.Events, EventReasonUnknownDistro)
+}
@@ -0,0 +1,84 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+)
+
+// mergeJobTemplate returns the job template of the controller with the job
+// template of a Shim merged in. Labels and annotations of the Shim override
+// those of the controller, tolerations and pull secrets are added, and the
+// remaining fields are replaced if set.
+func mergeJobTemplate(base rcmv1.JobTemplateSpec, override *rcmv1.JobTemplateSpec) rcmv1.JobTemplateSpec {
+	merged := *base.DeepCopy()
+	if override == nil {
+		return merged
+	}
+	override = override.DeepCopy()
+
+	merged.Labels = mergeMaps(merged.Labels, override.Labels)
+	merged.Annotations = mergeMaps(merged.Annotations, override.Annotations)
+	merged.Tolerations = append(merged.Tolerations, override.Tolerations...)
+	merged.ImagePullSecrets = append(merged.ImagePullSecrets, override.ImagePullSecrets...)
+	if override.Resources != nil {
+		merged.Resources = override.Resources
+	}
+	if override.PriorityClassName != "" {
+		merged.PriorityClassName = override.PriorityClassName
+	}
+	if override.ServiceAccountName != "" {
+		merged.ServiceAccountName = override.ServiceAccountName
+	}
+	return merged
+}
+
+// applyJobTemplate applies tmpl to the pod template of a Job. Labels and
+// annotations set by the controller are kept.
+func applyJobTemplate(pod *corev1.PodTemplateSpec, tmpl rcmv1.JobTemplateSpec) {
+	pod.Labels = mergeMaps(tmpl.Labels, pod.Labels)
+	pod.Annotations = mergeMaps(tmpl.Annotations, pod.Annotations)
+	pod.Spec.Tolerations = append(pod.Spec.Tolerations, tmpl.Tolerations...)
+	pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, tmpl.ImagePullSecrets...)
+	pod.Spec.PriorityClassName = tmpl.PriorityClassName
+	pod.Spec.ServiceAccountName = tmpl.ServiceAccountName
+	if tmpl.Resources != nil {
+		for i := range pod.Spec.InitContainers {
+			pod.Spec.InitContainers[i].Resources = *tmpl.Resources.DeepCopy()
+		}
+		for i := range pod.Spec.Containers {
+			pod.Spec.Containers[i].Resources = *tmpl.Resources.DeepCopy()
+		}
+	}
+}
+
+// mergeMaps returns the entries of a and b. Entries of b win.
+func mergeMaps(a, b map[string]string) map[string]string {
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	merged := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		merged[k] = v
+	}
+	for k, v := range b {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,118 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller //nolint:testpackage // whitebox test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+)
+
+func TestMergeJobTemplate(t *testing.T) {
+	small := &corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("10m")}}
+	large := &corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}}
+	base := rcmv1.JobTemplateSpec{
+		Labels:            map[string]string{"team": "platform", "tier": "system"},
+		Tolerations:       []corev1.Toleration{{Key: "wasm", Operator: corev1.TolerationOpExists}},
+		ImagePullSecrets:  []corev1.LocalObjectReference{{Name: "registry"}},
+		Resources:         small,
+		PriorityClassName: "low",
+	}
+
+	tests := []struct {
+		name     string
+		override *rcmv1.JobTemplateSpec
+		want     rcmv1.JobTemplateSpec
+	}{
+		{"no override", nil, base},
+		{"override", &rcmv1.JobTemplateSpec{
+			Labels:             map[string]string{"tier": "wasm"},
+			Annotations:        map[string]string{"owner": "spin"},
+			Tolerations:        []corev1.Toleration{{Key: "dedicated", Value: "spin", Operator: corev1.TolerationOpEqual}},
+			ImagePullSecrets:   []corev1.LocalObjectReference{{Name: "mirror"}},
+			Resources:          large,
+			PriorityClassName:  "high",
+			ServiceAccountName: "installer",
+		}, rcmv1.JobTemplateSpec{
+			Labels:      map[string]string{"team": "platform", "tier": "wasm"},
+			Annotations: map[string]string{"owner": "spin"},
+			Tolerations: []corev1.Toleration{
+				{Key: "wasm", Operator: corev1.TolerationOpExists},
+				{Key: "dedicated", Value: "spin", Operator: corev1.TolerationOpEqual},
+			},
+			ImagePullSecrets:   []corev1.LocalObjectReference{{Name: "registry"}, {Name: "mirror"}},
+			Resources:          large,
+			PriorityClassName:  "high",
+			ServiceAccountName: "installer",
+		}},
+		{"empty override keeps base", &rcmv1.JobTemplateSpec{}, base},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, mergeJobTemplate(base, tt.override))
+		})
+	}
+
+	assert.Len(t, base.Tolerations, 1, "base is not modified")
+}
+
+func TestJobManifestTemplate(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, rcmv1.AddToScheme(scheme))
+	resources := corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")}}
+	sr := &ShimReconciler{Scheme: scheme, Config: Config{
+		JobTemplate: rcmv1.JobTemplateSpec{
+			Labels:      map[string]string{"team": "platform", JobPodLabel: "false"},
+			Tolerations: []corev1.Toleration{{Key: "wasm", Operator: corev1.TolerationOpExists}},
+			Resources:   &resources,
+		},
+	}}
+	shim := &rcmv1.Shim{
+		ObjectMeta: metav1.ObjectMeta{Name: "spin", UID: "uid"},
+		Spec: rcmv1.ShimSpec{
+			FetchStrategy: rcmv1.FetchStrategy{
+				Type:     "anonymousHttp",
+				AnonHTTP: rcmv1.AnonHTTPSpec{Location: "https://example.com/shim.tar.gz"},
+			},
+			JobTemplate: &rcmv1.JobTemplateSpec{
+				Annotations:       map[string]string{"owner": "spin"},
+				PriorityClassName: "system-node-critical",
+			},
+		},
+	}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+
+	job, err := sr.createJobManifest(shim, node, INSTALL)
+	require.NoError(t, err)
+
+	pod := job.Spec.Template
+	assert.Equal(t, map[string]string{"team": "platform", JobPodLabel: "true"}, pod.Labels, "labels of the controller are kept")
+	assert.Equal(t, map[string]string{"owner": "spin"}, pod.Annotations)
+	assert.Equal(t, []corev1.Toleration{{Key: "wasm", Operator: corev1.TolerationOpExists}}, pod.Spec.Tolerations)
+	assert.Equal(t, "system-node-critical", pod.Spec.PriorityClassName)
+	assert.Equal(t, resources, pod.Spec.Containers[0].Resources)
+	assert.Equal(t, resources, pod.Spec.InitContainers[0].Resources)
+}
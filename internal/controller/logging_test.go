@@ -0,0 +1,57 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller //nolint:testpackage // whitebox test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+)
+
+func TestJobManifestLogFlags(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, rcmv1.AddToScheme(scheme))
+	shim := &rcmv1.Shim{ObjectMeta: metav1.ObjectMeta{Name: "spin", UID: "uid"}}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+
+	tests := []struct {
+		name      string
+		logFormat string
+		logLevel  string
+		want      []string
+	}{
+		{"defaults", "", "", []string{"uninstall", "-H", "/mnt/node-root", "-r", "spin"}},
+		{"format and level", "json", "debug", []string{"uninstall", "-H", "/mnt/node-root", "-r", "spin", "--log-format", "json", "--log-level", "debug"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sr := &ShimReconciler{Scheme: scheme, Config: Config{PrivilegedJobs: true, LogFormat: tt.logFormat, LogLevel: tt.logLevel}}
+
+			job, err := sr.createJobManifest(shim, node, UNINSTALL)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, job.Spec.Template.Spec.Containers[0].Args)
+		})
+	}
+}
@@ -0,0 +1,269 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+	"github.com/spinkube/runtime-class-manager/internal/logging"
+	"github.com/spinkube/runtime-class-manager/internal/termination"
+)
+
+// Phases of a Shim as reported by the rcm_shim_phase metric.
+const (
+	ShimPhaseIdle        = "idle"
+	ShimPhaseProgressing = "progressing"
+	ShimPhaseProvisioned = "provisioned"
+	ShimPhaseFailed      = "failed"
+	ShimPhaseDeleting    = "deleting"
+)
+
+// Outcomes of jobs as reported by the rcm_job_duration_seconds metric.
+const (
+	JobOutcomeSucceeded = "succeeded"
+	JobOutcomeFailed    = "failed"
+)
+
+// nodeStatusNone is reported for selected nodes without a provisioning status.
+const nodeStatusNone = "none"
+
+var (
+	shimPhases = []string{ShimPhaseIdle, ShimPhaseProgressing, ShimPhaseProvisioned, ShimPhaseFailed, ShimPhaseDeleting}
+
+	nodeStatuses = []string{
+		nodeStatusNone,
+		ProvisioningStatusPending,
+		ProvisioningStatusProvisioned,
+		ProvisioningStatusPendingRestart,
+		ProvisioningStatusPreflight,
+		ProvisioningStatusDraining,
+		ProvisioningStatusDrifted,
+		ProvisioningStatusFailed,
+	}
+
+	shimPhaseGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rcm_shim_phase",
+		Help: "Phase of a Shim, 1 for the current phase and 0 for all others.",
+	}, []string{"shim", "phase"})
+
+	shimNodesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rcm_shim_nodes",
+		Help: "Number of nodes selected by a Shim by provisioning status.",
+	}, []string{"shim", "status"})
+
+	jobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rcm_job_duration_seconds",
+		Help:    "Duration of finished node-installer jobs by operation and outcome.",
+		Buckets: []float64{5, 10, 30, 60, 120, 300, 600, 1200},
+	}, []string{"shim", "operation", "outcome"})
+
+	downloadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rcm_shim_download_duration_seconds",
+		Help:    "Duration of shim downloads by install jobs.",
+		Buckets: []float64{1, 2, 5, 10, 30, 60, 120, 300},
+	}, []string{"shim"})
+
+	downloadBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rcm_shim_download_bytes",
+		Help:    "Size of the shim assets downloaded by install jobs.",
+		Buckets: prometheus.ExponentialBuckets(1<<20, 2, 10), //nolint:mnd // 1 MiB to 512 MiB
+	}, []string{"shim"})
+
+	containerdRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rcm_containerd_restarts_total",
+		Help: "Number of containerd restarts by install jobs.",
+	}, []string{"shim"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(shimPhaseGauge, shimNodesGauge, jobDuration, downloadDuration, downloadBytes, containerdRestarts)
+}
+
+// shimPhase derives the phase of a Shim from the provisioning status of the
+// selected nodes.
+func shimPhase(shim *rcmv1.Shim, nodes *corev1.NodeList) string {
+	if !shim.DeletionTimestamp.IsZero() {
+		return ShimPhaseDeleting
+	}
+	if len(nodes.Items) == 0 {
+		return ShimPhaseIdle
+	}
+
+	provisioned := 0
+	for _, node := range nodes.Items {
+		switch node.Labels[shim.Name] {
+		case ProvisioningStatusFailed:
+			return ShimPhaseFailed
+		case ProvisioningStatusProvisioned:
+			provisioned++
+		}
+	}
+	if provisioned == len(nodes.Items) {
+		return ShimPhaseProvisioned
+	}
+	return ShimPhaseProgressing
+}
+
+// recordShimMetrics updates the phase and node metrics of a Shim.
+func recordShimMetrics(shim *rcmv1.Shim, nodes *corev1.NodeList) {
+	phase := shimPhase(shim, nodes)
+	for _, p := range shimPhases {
+		value := 0.0
+		if p == phase {
+			value = 1
+		}
+		shimPhaseGauge.WithLabelValues(shim.Name, p).Set(value)
+	}
+
+	counts := make(map[string]int, len(nodeStatuses))
+	for _, node := range nodes.Items {
+		status := node.Labels[shim.Name]
+		if status == "" {
+			status = nodeStatusNone
+		}
+		counts[status]++
+	}
+	for _, status := range nodeStatuses {
+		shimNodesGauge.WithLabelValues(shim.Name, status).Set(float64(counts[status]))
+	}
+}
+
+// forgetShimMetrics removes the metrics of a deleted Shim.
+func forgetShimMetrics(shimName string) {
+	labels := prometheus.Labels{"shim": shimName}
+	shimPhaseGauge.DeletePartialMatch(labels)
+	shimNodesGauge.DeletePartialMatch(labels)
+	jobDuration.DeletePartialMatch(labels)
+	downloadDuration.DeletePartialMatch(labels)
+	downloadBytes.DeletePartialMatch(labels)
+	containerdRestarts.DeletePartialMatch(labels)
+}
+
+// ObservedAnnotation is set on finished Jobs once their metrics and Events
+// have been recorded, so that they are not recorded again after a restart of
+// the controller.
+const ObservedAnnotation = "kwasm.sh/observed"
+
+// jobObserver records the metrics of finished jobs. Finished jobs are
+// reconciled again on every change, so every job is only recorded once. Jobs
+// recorded by this controller are remembered until the cache has seen their
+// ObservedAnnotation.
+type jobObserver struct {
+	mu       sync.Mutex
+	observed map[types.NamespacedName]types.UID
+}
+
+// forget drops a deleted job.
+func (o *jobObserver) forget(name types.NamespacedName) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.observed, name)
+}
+
+// observe records the metrics of a finished job, unless they have already
+// been recorded. It returns whether the job has been seen finished for the
+// first time. The job is annotated before its metrics are recorded, so that
+// they are recorded at most once.
+func (o *jobObserver) observe(ctx context.Context, c client.Client, job *batchv1.Job, shimName string, finishedType batchv1.JobConditionType) (bool, error) {
+	o.mu.Lock()
+	key := types.NamespacedName{Namespace: job.Namespace, Name: job.Name}
+	if o.observed[key] == job.UID || job.Annotations[ObservedAnnotation] != "" {
+		o.mu.Unlock()
+		return false, nil
+	}
+	o.mu.Unlock()
+
+	patch := client.MergeFromWithOptions(job.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	if job.Annotations == nil {
+		job.Annotations = map[string]string{}
+	}
+	job.Annotations[ObservedAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	if err := c.Patch(ctx, job, patch); err != nil {
+		return false, fmt.Errorf("failed to mark job as observed: %w", err)
+	}
+
+	o.mu.Lock()
+	if o.observed == nil {
+		o.observed = map[types.NamespacedName]types.UID{}
+	}
+	o.observed[key] = job.UID
+	o.mu.Unlock()
+
+	operation := job.Annotations["kwasm.sh/operation"]
+	outcome := JobOutcomeSucceeded
+	if finishedType == batchv1.JobFailed {
+		outcome = JobOutcomeFailed
+	}
+	if duration, ok := jobRunTime(job, finishedType); ok {
+		jobDuration.WithLabelValues(shimName, operation, outcome).Observe(duration.Seconds())
+	}
+
+	if operation != INSTALL {
+		return true, nil
+	}
+
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{batchv1.JobNameLabel: job.Name}); err != nil {
+		logging.FromContext(ctx).Error("Unable to list pods of job for metrics", logging.KeyJob, job.Name, "error", err)
+		return true, nil
+	}
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.InitContainerStatuses {
+			if t := status.State.Terminated; status.Name == "downloader" && t != nil && t.ExitCode == 0 {
+				downloadDuration.WithLabelValues(shimName).Observe(t.FinishedAt.Sub(t.StartedAt.Time).Seconds())
+			}
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name != "provisioner" || status.State.Terminated == nil {
+				continue
+			}
+			msg, err := termination.Parse(status.State.Terminated.Message)
+			if err != nil {
+				continue
+			}
+			if msg.AssetBytes > 0 {
+				downloadBytes.WithLabelValues(shimName).Observe(float64(msg.AssetBytes))
+			}
+			containerdRestarts.WithLabelValues(shimName).Add(float64(msg.Restarts))
+		}
+	}
+	return true, nil
+}
+
+// jobRunTime returns the time from the start of a job until it finished.
+func jobRunTime(job *batchv1.Job, finishedType batchv1.JobConditionType) (time.Duration, bool) {
+	if job.Status.StartTime == nil {
+		return 0, false
+	}
+	for _, c := range job.Status.Conditions {
+		if c.Type == finishedType {
+			return c.LastTransitionTime.Sub(job.Status.StartTime.Time), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,150 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller //nolint:testpackage // whitebox test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+)
+
+func nodesWithStatus(shimName string, statuses ...string) *corev1.NodeList {
+	nodes := &corev1.NodeList{}
+	for _, status := range statuses {
+		node := corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{}}}
+		if status != "" {
+			node.Labels[shimName] = status
+		}
+		nodes.Items = append(nodes.Items, node)
+	}
+	return nodes
+}
+
+func TestShimPhase(t *testing.T) {
+	now := metav1.Now()
+	tests := []struct {
+		name     string
+		deleting bool
+		statuses []string
+		want     string
+	}{
+		{"no nodes", false, nil, ShimPhaseIdle},
+		{"all provisioned", false, []string{ProvisioningStatusProvisioned, ProvisioningStatusProvisioned}, ShimPhaseProvisioned},
+		{"rollout in progress", false, []string{ProvisioningStatusProvisioned, ProvisioningStatusPending, ""}, ShimPhaseProgressing},
+		{"failed node", false, []string{ProvisioningStatusProvisioned, "failed"}, ShimPhaseFailed},
+		{"deleting", true, []string{ProvisioningStatusProvisioned}, ShimPhaseDeleting},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shim := &rcmv1.Shim{ObjectMeta: metav1.ObjectMeta{Name: "spin"}}
+			if tt.deleting {
+				shim.DeletionTimestamp = &now
+			}
+			assert.Equal(t, tt.want, shimPhase(shim, nodesWithStatus("spin", tt.statuses...)))
+		})
+	}
+}
+
+func TestRecordShimMetrics(t *testing.T) {
+	shim := &rcmv1.Shim{ObjectMeta: metav1.ObjectMeta{Name: "metrics-shim"}}
+	recordShimMetrics(shim, nodesWithStatus("metrics-shim", ProvisioningStatusProvisioned, ProvisioningStatusProvisioned, "failed", ""))
+
+	assert.InDelta(t, 1, testutil.ToFloat64(shimPhaseGauge.WithLabelValues("metrics-shim", ShimPhaseFailed)), 0)
+	assert.InDelta(t, 0, testutil.ToFloat64(shimPhaseGauge.WithLabelValues("metrics-shim", ShimPhaseProvisioned)), 0)
+	assert.InDelta(t, 2, testutil.ToFloat64(shimNodesGauge.WithLabelValues("metrics-shim", ProvisioningStatusProvisioned)), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(shimNodesGauge.WithLabelValues("metrics-shim", "failed")), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(shimNodesGauge.WithLabelValues("metrics-shim", nodeStatusNone)), 0)
+	assert.InDelta(t, 0, testutil.ToFloat64(shimNodesGauge.WithLabelValues("metrics-shim", ProvisioningStatusPending)), 0)
+
+	forgetShimMetrics("metrics-shim")
+	assert.Zero(t, testutil.CollectAndCount(shimPhaseGauge, "rcm_shim_phase"))
+	assert.Zero(t, testutil.CollectAndCount(shimNodesGauge, "rcm_shim_nodes"))
+}
+
+func TestJobObserver(t *testing.T) {
+	start := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "rcm",
+			Name:        "worker-1-observed-shim-install",
+			UID:         "1",
+			Annotations: map[string]string{"kwasm.sh/operation": INSTALL},
+		},
+		Status: batchv1.JobStatus{
+			StartTime: &metav1.Time{Time: start},
+			Conditions: []batchv1.JobCondition{{
+				Type:               batchv1.JobComplete,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: metav1.Time{Time: start.Add(42 * time.Second)},
+			}},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "rcm", Name: "pod", Labels: map[string]string{batchv1.JobNameLabel: job.Name}},
+		Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{{
+				Name: "downloader",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+					StartedAt:  metav1.Time{Time: start},
+					FinishedAt: metav1.Time{Time: start.Add(3 * time.Second)},
+				}},
+			}},
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name: "provisioner",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+					Message: `{"restarts":1,"assetBytes":1048576}`,
+				}},
+			}},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(job, pod).Build()
+
+	var observer jobObserver
+	for range 2 {
+		current := &batchv1.Job{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(job), current))
+		_, err := observer.observe(context.Background(), c, current, "observed-shim", batchv1.JobComplete)
+		require.NoError(t, err)
+	}
+
+	// After a restart, the job is known to be observed from its annotation.
+	observed := &batchv1.Job{}
+	require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(job), observed))
+	assert.NotEmpty(t, observed.Annotations[ObservedAnnotation])
+	first, err := (&jobObserver{}).observe(context.Background(), c, observed, "observed-shim", batchv1.JobComplete)
+	require.NoError(t, err)
+	assert.False(t, first, "job is not observed again after a restart")
+
+	assert.Equal(t, 1, testutil.CollectAndCount(jobDuration, "rcm_job_duration_seconds"))
+	assert.InDelta(t, 1, testutil.ToFloat64(containerdRestarts.WithLabelValues("observed-shim")), 0, "job is only recorded once")
+	assert.Equal(t, 1, testutil.CollectAndCount(downloadDuration, "rcm_shim_download_duration_seconds"))
+	assert.Equal(t, 1, testutil.CollectAndCount(downloadBytes, "rcm_shim_download_bytes"))
+
+	forgetShimMetrics("observed-shim")
+}
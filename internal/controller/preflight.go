@@ -0,0 +1,118 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+	"github.com/spinkube/runtime-class-manager/internal/logging"
+	"github.com/spinkube/runtime-class-manager/internal/termination"
+)
+
+// GenerationAnnotation is set on jobs to the generation of the Shim they
+// have been created for.
+const GenerationAnnotation = "kwasm.sh/generation"
+
+// preflightPassed returns whether the shim may be installed on the node. If
+// no dry run has been run on the node for the current generation of the
+// shim, a preflight Job is deployed to the node.
+func (sr *ShimReconciler) preflightPassed(ctx context.Context, shim *rcmv1.Shim, node corev1.Node) (bool, error) {
+	log := logging.FromContext(ctx)
+
+	result := findNodePreflight(shim.Status.Preflight, node.Name)
+	switch {
+	case result == nil || result.ObservedGeneration != shim.Generation:
+		return false, sr.deployJobOnNode(ctx, shim, node, PREFLIGHT)
+	case result.Error != "":
+		log.Info("Preflight failed, not installing", logging.KeyShim, shim.Name, logging.KeyNode, node.Name, "error", result.Error)
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+// preflightFailed returns whether the dry run failed on the node for the
+// current generation of the shim. The rollout stays paused on the node until
+// the shim is changed.
+func preflightFailed(shim *rcmv1.Shim, node *corev1.Node) bool {
+	result := findNodePreflight(shim.Status.Preflight, node.Name)
+	return node.Labels[shim.Name] == ProvisioningStatusFailed &&
+		result != nil && result.ObservedGeneration == shim.Generation && result.Error != ""
+}
+
+// recordPreflight stores the result of a preflight Job in the status of the
+// Shim. A nil preflight records a dry run that failed with preflightErr.
+func recordPreflight(ctx context.Context, c client.Client, shimName string, nodeName string, generation int64, preflight *termination.Preflight, preflightErr string) error {
+	shim := &rcmv1.Shim{}
+	if err := c.Get(ctx, types.NamespacedName{Name: shimName}, shim); err != nil {
+		return fmt.Errorf("failed to fetch shim: %w", err)
+	}
+
+	result := rcmv1.NodePreflight{
+		Node:               nodeName,
+		ObservedGeneration: generation,
+		LastRunTime:        metav1.NewTime(time.Now()),
+	}
+	if preflight == nil {
+		result.Error = preflightErr
+	} else {
+		result.ConfigDiff = preflight.ConfigDiff
+		result.RestartRequired = preflight.Restart
+		for _, change := range preflight.Shims {
+			result.Shims = append(result.Shims, rcmv1.ShimChange{Name: change.Name, Action: change.Action})
+		}
+	}
+
+	if existing := findNodePreflight(shim.Status.Preflight, nodeName); existing != nil {
+		*existing = result
+	} else {
+		shim.Status.Preflight = append(shim.Status.Preflight, result)
+	}
+
+	if err := c.Update(ctx, shim); err != nil {
+		return fmt.Errorf("failed to update preflight status: %w", err)
+	}
+	return nil
+}
+
+// findNodePreflight returns the preflight result of the node.
+func findNodePreflight(results []rcmv1.NodePreflight, nodeName string) *rcmv1.NodePreflight {
+	for i := range results {
+		if results[i].Node == nodeName {
+			return &results[i]
+		}
+	}
+	return nil
+}
+
+// jobGeneration returns the generation of the Shim a job has been created for.
+func jobGeneration(annotations map[string]string) int64 {
+	generation, err := strconv.ParseInt(annotations[GenerationAnnotation], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return generation
+}
@@ -21,12 +21,15 @@ import (
 	"errors"
 	"fmt"
 	"math"
-	"os"
 	"strconv"
+	"time"
 
-	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -41,21 +44,48 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+	"github.com/spinkube/runtime-class-manager/internal/logging"
+	"github.com/spinkube/runtime-class-manager/internal/preset"
+	"github.com/spinkube/runtime-class-manager/internal/tracing"
 )
 
 const (
 	RCMOperatorFinalizer          = "rcm.spinkube.dev/finalizer"
 	INSTALL                       = "install"
 	UNINSTALL                     = "uninstall"
+	PREFLIGHT                     = "preflight"
+	VERIFY                        = "verify"
 	ProvisioningStatusProvisioned = "provisioned"
 	ProvisioningStatusPending     = "pending"
-	K8sNameMaxLength              = 63
+	// ProvisioningStatusPendingRestart is set on nodes where the shim is
+	// installed but containerd has not been restarted yet.
+	ProvisioningStatusPendingRestart = "pending-restart"
+	// ProvisioningStatusPreflight is set on nodes while the installation is
+	// run in dry-run mode.
+	ProvisioningStatusPreflight = "preflight"
+	// ProvisioningStatusDrifted is set on nodes where the installed shim no
+	// longer matches the lock file of node-installer.
+	ProvisioningStatusDrifted = "drifted"
+	// ProvisioningStatusDraining is set on nodes that are drained before the
+	// shim is installed.
+	ProvisioningStatusDraining = "draining"
+	// ProvisioningStatusFailed is set on nodes where a job of the shim
+	// failed.
+	ProvisioningStatusFailed = "failed"
+	K8sNameMaxLength         = 63
+	// drainRequeueInterval is the interval in which draining nodes are checked.
+	drainRequeueInterval = 10 * time.Second
+	// restartCheckInterval is the interval in which nodes with a pending
+	// containerd restart are verified.
+	restartCheckInterval = time.Minute
 )
 
 // ShimReconciler reconciles a Shim object
 type ShimReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	Config   Config
 }
 
 // configuration for INSTALL or UNINSTALL jobs
@@ -69,9 +99,18 @@ type opConfig struct {
 //+kubebuilder:rbac:groups=runtime.kwasm.sh,resources=shims,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=runtime.kwasm.sh,resources=shims/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=runtime.kwasm.sh,resources=shims/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch;update
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=pods/eviction,verbs=create
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // SetupWithManager sets up the controller with the Manager.
 func (sr *ShimReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Index pods by node, to find the pods to evict when draining a node.
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.Pod{}, podNodeNameField, indexPodsByNodeName); err != nil {
+		return fmt.Errorf("failed to index pods by node: %w", err)
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&rcmv1.Shim{}).
 		// As we create and own the created jobs
@@ -100,13 +139,24 @@ func (sr *ShimReconciler) SetupWithManager(mgr ctrl.Manager) error {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.14.1/pkg/reconcile
 func (sr *ShimReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	log := log.With().Str("shim", req.Name).Logger()
-	ctx = log.WithContext(ctx)
+	ctx, span := tracing.Start(ctx, "ShimReconciler.Reconcile", attribute.String("shim", req.Name))
+	result, err := sr.reconcile(ctx, req)
+	tracing.End(span, err)
+	return result, err
+}
+
+// reconcile moves the nodes selected by the Shim towards its spec.
+func (sr *ShimReconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := logging.FromContext(ctx).With(logging.KeyShim, req.Name)
+	ctx = logging.IntoContext(ctx, log)
 
 	// 1. Check if the shim resource exists
 	var shimResource rcmv1.Shim
 	if err := sr.Client.Get(ctx, req.NamespacedName, &shimResource); err != nil {
-		log.Err(err).Msg("Unable to fetch shimResource")
+		log.Error("Unable to fetch shimResource", "error", err)
+		if apierrors.IsNotFound(err) {
+			forgetShimMetrics(req.Name)
+		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
@@ -114,7 +164,7 @@ func (sr *ShimReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 	defer func() {
 		err := sr.ensureFinalizerForShim(ctx, &shimResource, RCMOperatorFinalizer)
 		if err != nil {
-			log.Error().Msgf("Failed to ensure finalizer: %s", err)
+			log.Error("Failed to ensure finalizer", "error", err)
 		}
 	}()
 
@@ -126,13 +176,23 @@ func (sr *ShimReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 
 	err = sr.updateStatus(ctx, &shimResource, nodes)
 	if err != nil {
-		log.Error().Msgf("Unable to update node count: %s", err)
+		log.Error("Unable to update node count", "error", err)
 		return ctrl.Result{}, err
 	}
 
 	// Shim has been requested for deletion, delete the child resources
 	if !shimResource.DeletionTimestamp.IsZero() {
-		log.Debug().Msgf("Deleting shim %s", shimResource.Name)
+		log.Debug("Deleting shim")
+		if sr.Config.InstallMode == InstallModeAgent {
+			// The agents uninstall the shim and remove the node labels,
+			// every label change triggers a reconcile.
+			if shimOnAnyNode(shimResource.Name, nodes) {
+				log.Info("Waiting for agents to uninstall shim")
+				return ctrl.Result{}, nil
+			}
+			err = sr.removeFinalizerFromShim(ctx, &shimResource)
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
 		err := sr.handleDeleteShim(ctx, &shimResource, nodes)
 		if err != nil {
 			return ctrl.Result{}, err
@@ -142,27 +202,37 @@ func (sr *ShimReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	// 3. Check if referenced runtimeClass exists in cluster
-	rcExists, err := sr.runtimeClassExists(ctx, &shimResource)
-	if err != nil {
-		log.Error().Msgf("RuntimeClass issue: %s", err)
+	// 3. Check if referenced runtimeClass exists in cluster and is up to date
+	rc, err := sr.getRuntimeClass(ctx, &shimResource)
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error("RuntimeClass issue", "error", err)
+	}
+	if rc == nil {
+		log.Info("RuntimeClass not found", "runtimeClass", shimResource.Spec.RuntimeClass.Name)
 	}
-	if !rcExists {
-		log.Info().Msgf("RuntimeClass '%s' not found", shimResource.Spec.RuntimeClass.Name)
+	if rc == nil || sr.runtimeClassChanged(&shimResource, rc) {
 		_, err = sr.handleDeployRuntimeClass(ctx, &shimResource)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
+		reason, verb := EventReasonRuntimeClassCreated, "Created"
+		if rc != nil {
+			reason, verb = EventReasonRuntimeClassUpdated, "Updated"
+		}
+		recordEvent(sr.Recorder, &shimResource, nil, corev1.EventTypeNormal, reason, "%s RuntimeClass %s", verb, shimResource.Spec.RuntimeClass.Name)
 	}
 
 	// 4. Deploy job to each node in list
-	if len(nodes.Items) > 0 {
-		_, err = sr.handleInstallShim(ctx, &shimResource, nodes)
+	result := ctrl.Result{}
+	if sr.Config.InstallMode == InstallModeAgent {
+		log.Debug("Installation is left to the node agents")
+	} else if len(nodes.Items) > 0 {
+		result, err = sr.handleInstallShim(ctx, &shimResource, nodes)
 	} else {
-		log.Info().Msg("No nodes found")
+		log.Info("No nodes found")
 	}
 
-	return ctrl.Result{}, err
+	return result, err
 }
 
 // findShimsToReconcile finds all Shims that need to be reconciled.
@@ -193,7 +263,7 @@ func (sr *ShimReconciler) findShimsToReconcile(ctx context.Context, node client.
 }
 
 func (sr *ShimReconciler) updateStatus(ctx context.Context, shim *rcmv1.Shim, nodes *corev1.NodeList) error {
-	log := log.Ctx(ctx)
+	log := logging.FromContext(ctx)
 
 	shim.Status.NodeCount = len(nodes.Items)
 	shim.Status.NodeReadyCount = 0
@@ -208,13 +278,15 @@ func (sr *ShimReconciler) updateStatus(ctx context.Context, shim *rcmv1.Shim, no
 
 	// TODO: include proper status conditions to update
 
+	recordShimMetrics(shim, nodes)
+
 	if err := sr.Update(ctx, shim); err != nil {
-		log.Error().Msgf("Unable to update status %s", err)
+		log.Error("Unable to update status", "error", err)
 	}
 
 	// Re-fetch shim to avoid "object has been modified" errors
 	if err := sr.Client.Get(ctx, types.NamespacedName{Name: shim.Name, Namespace: shim.Namespace}, shim); err != nil {
-		log.Error().Msgf("Unable to re-fetch shim: %s", err)
+		log.Error("Unable to re-fetch shim", "error", err)
 		return fmt.Errorf("failed to fetch shim: %w", err)
 	}
 
@@ -223,83 +295,167 @@ func (sr *ShimReconciler) updateStatus(ctx context.Context, shim *rcmv1.Shim, no
 
 // handleInstallShim deploys a Job to each node in a list.
 func (sr *ShimReconciler) handleInstallShim(ctx context.Context, shim *rcmv1.Shim, nodes *corev1.NodeList) (ctrl.Result, error) {
-	log := log.Ctx(ctx)
+	log := logging.FromContext(ctx)
 
 	switch shim.Spec.RolloutStrategy.Type {
 	case rcmv1.RolloutStrategyTypeRolling:
 		{
-			log.Debug().Msgf("Rolling strategy selected: maxUpdate=%d", shim.Spec.RolloutStrategy.Rolling.MaxUpdate)
+			log.Debug("Rolling strategy selected", "maxUpdate", shim.Spec.RolloutStrategy.Rolling.MaxUpdate)
 			return ctrl.Result{}, errors.New("rolling strategy not implemented yet")
 		}
 	case rcmv1.RolloutStrategyTypeRecreate:
 		{
-			log.Debug().Msgf("Recreate strategy selected")
+			log.Debug("Recreate strategy selected")
 			return sr.recreateStrategyRollout(ctx, shim, nodes)
 		}
 	default:
 		{
-			log.Debug().Msgf("No rollout strategy selected; using default: recreate")
+			log.Debug("No rollout strategy selected; using default: recreate")
 			return sr.recreateStrategyRollout(ctx, shim, nodes)
 		}
 	}
 }
 
 func (sr *ShimReconciler) recreateStrategyRollout(ctx context.Context, shim *rcmv1.Shim, nodes *corev1.NodeList) (ctrl.Result, error) {
-	log := log.Ctx(ctx)
+	log := logging.FromContext(ctx)
+	result := ctrl.Result{}
 	shimInstallationErrors := []error{}
 	for i := range nodes.Items {
 		node := nodes.Items[i]
 
-		shimProvisioned := node.Labels[shim.Name] == ProvisioningStatusProvisioned
-		shimPending := node.Labels[shim.Name] == ProvisioningStatusPending
-		if !shimProvisioned && !shimPending {
-			err := sr.deployJobOnNode(ctx, shim, node, INSTALL)
+		reinstalling, err := sr.reinstallIfRequested(ctx, shim, &node)
+		if err != nil {
 			shimInstallationErrors = append(shimInstallationErrors, err)
+			continue
+		}
+		if reinstalling {
+			// The node is installed once the label removal is reconciled.
+			continue
 		}
 
-		if shimProvisioned {
-			log.Info().Msgf("Shim %s already provisioned on Node %s", shim.Name, node.Name)
+		switch node.Labels[shim.Name] {
+		case ProvisioningStatusProvisioned:
+			log.Info("Shim already provisioned", logging.KeyShim, shim.Name, logging.KeyNode, node.Name)
+			if shim.Spec.DriftDetection.Interval == nil {
+				continue
+			}
+			next, err := sr.verifyIfDue(ctx, shim, node)
+			if err != nil {
+				shimInstallationErrors = append(shimInstallationErrors, err)
+				continue
+			}
+			if result.RequeueAfter == 0 || next < result.RequeueAfter {
+				result.RequeueAfter = next
+			}
+		case ProvisioningStatusDrifted:
+			if !shim.Spec.DriftDetection.Remediate {
+				log.Info("Shim drifted, remediation disabled", logging.KeyShim, shim.Name, logging.KeyNode, node.Name)
+				continue
+			}
+			shimInstallationErrors = append(shimInstallationErrors, sr.remediateDrift(ctx, shim, node))
+		case ProvisioningStatusPending:
+		case ProvisioningStatusPendingRestart:
+			log.Info("Shim installed, waiting for containerd restart", logging.KeyShim, shim.Name, logging.KeyNode, node.Name)
+			next, err := sr.checkRestart(ctx, shim, node)
+			if err != nil {
+				shimInstallationErrors = append(shimInstallationErrors, err)
+				continue
+			}
+			if result.RequeueAfter == 0 || next < result.RequeueAfter {
+				result.RequeueAfter = next
+			}
+		case ProvisioningStatusPreflight:
+			log.Info("Waiting for preflight", logging.KeyShim, shim.Name, logging.KeyNode, node.Name)
+		case ProvisioningStatusFailed:
+			if installFailed(shim, &node) {
+				log.Info("Install failed, waiting for a change of the shim or a reinstall", logging.KeyShim, shim.Name, logging.KeyNode, node.Name)
+				continue
+			}
+			if preflightFailed(shim, &node) {
+				log.Info("Preflight failed, waiting for a change of the shim", logging.KeyShim, shim.Name, logging.KeyNode, node.Name)
+				continue
+			}
+			fallthrough
+		default:
+			if shim.Spec.Preflight {
+				passed, err := sr.preflightPassed(ctx, shim, node)
+				if err != nil {
+					shimInstallationErrors = append(shimInstallationErrors, err)
+					continue
+				}
+				if !passed {
+					continue
+				}
+			}
+			if shim.Spec.RestartPolicy == rcmv1.RestartPolicyDrain {
+				drained, err := sr.drainNode(ctx, shim, &node)
+				if err != nil {
+					shimInstallationErrors = append(shimInstallationErrors, err)
+					continue
+				}
+				if !drained {
+					result.RequeueAfter = drainRequeueInterval
+					continue
+				}
+			}
+			err := sr.deployJobOnNode(ctx, shim, node, INSTALL)
+			shimInstallationErrors = append(shimInstallationErrors, err)
 		}
 	}
-	return ctrl.Result{}, errors.Join(shimInstallationErrors...)
+	return result, errors.Join(shimInstallationErrors...)
 }
 
 // deployUninstallJob deploys an uninstall Job for a Shim.
 func (sr *ShimReconciler) deployJobOnNode(ctx context.Context, shim *rcmv1.Shim, node corev1.Node, jobType string) error {
-	log := log.Ctx(ctx)
+	log := logging.FromContext(ctx)
 
 	if err := sr.Client.Get(ctx, types.NamespacedName{Name: node.Name}, &node); err != nil {
-		log.Error().Msgf("Unable to re-fetch node: %s", err)
+		log.Error("Unable to re-fetch node", logging.KeyNode, node.Name, "error", err)
 		return fmt.Errorf("failed to fetch node: %w", err)
 	}
 
-	log.Info().Msgf("Deploying %s-Job for Shim %s on node: %s", jobType, shim.Name, node.Name)
-
-	var job *batchv1.Job
+	log.Info("Deploying Job", logging.KeyOperation, jobType, logging.KeyShim, shim.Name, logging.KeyNode, node.Name)
+	uninstallStarted := jobType == UNINSTALL && node.Labels[shim.Name] != UNINSTALL
 
+	// The status the node is labeled with while the job runs.
+	var status string
 	switch jobType {
 	case INSTALL:
-		err := sr.updateNodeLabels(ctx, &node, shim, ProvisioningStatusPending)
-		if err != nil {
-			log.Error().Msgf("Unable to update node label %s: %s", shim.Name, err)
-		}
+		status = ProvisioningStatusPending
+	case UNINSTALL:
+		status = UNINSTALL
+	case PREFLIGHT:
+		status = ProvisioningStatusPreflight
+	case VERIFY:
+	default:
+		return fmt.Errorf("invalid jobType: %s", jobType)
+	}
 
-		job, err = sr.createJobManifest(shim, &node, INSTALL)
-		if err != nil {
-			return err
+	job, err := sr.createJobManifest(shim, &node, jobType)
+	if errors.Is(err, errUnknownDistro) {
+		// Only relabeling the node helps, retrying the reconcile does not.
+		log.Error("Unable to create Job", logging.KeyShim, shim.Name, logging.KeyNode, node.Name, "error", err)
+		recordEvent(sr.Recorder, shim, &node, corev1.EventTypeWarning, EventReasonUnknownDistro, "Not creating %s job: %s", jobType, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if skip, err := sr.replaceFinishedJob(ctx, job); err != nil || skip {
+		return err
+	}
+
+	if status != "" {
+		if jobType == INSTALL {
+			recordInstall(shim, &node)
 		}
-	case UNINSTALL:
-		err := sr.updateNodeLabels(ctx, &node, shim, UNINSTALL)
-		if err != nil {
-			log.Error().Msgf("Unable to update node label %s: %s", shim.Name, err)
+		if err := sr.updateNodeLabels(ctx, &node, shim, status); err != nil {
+			log.Error("Unable to update node label", logging.KeyShim, shim.Name, logging.KeyNode, node.Name, "error", err)
 		}
+	}
 
-		job, err = sr.createJobManifest(shim, &node, UNINSTALL)
-		if err != nil {
-			return err
-		}
-	default:
-		return fmt.Errorf("invalid jobType: %s", jobType)
+	if err := sr.setTraceParent(ctx, job); err != nil {
+		return err
 	}
 
 	// We want to use server-side apply https://kubernetes.io/docs/reference/using-api/server-side-apply
@@ -311,17 +467,25 @@ func (sr *ShimReconciler) deployJobOnNode(ctx context.Context, shim *rcmv1.Shim,
 
 	// We rely on controller-runtime to rate limit us.
 	if err := sr.Client.Patch(ctx, job, patchMethod, patchOptions); err != nil {
-		log.Error().Msgf("Unable to reconcile Job: %s", err)
-		if err := sr.updateNodeLabels(ctx, &node, shim, "failed"); err != nil {
-			log.Error().Msgf("Unable to update node label %s: %s", shim.Name, err)
+		log.Error("Unable to reconcile Job", logging.KeyShim, shim.Name, logging.KeyNode, node.Name, "error", err)
+		if err := sr.updateNodeLabels(ctx, &node, shim, ProvisioningStatusFailed); err != nil {
+			log.Error("Unable to update node label", logging.KeyShim, shim.Name, logging.KeyNode, node.Name, "error", err)
 		}
 		return fmt.Errorf("failed to reconcile job: %w", err)
 	}
 
+	if uninstallStarted {
+		recordEvent(sr.Recorder, shim, &node, corev1.EventTypeNormal, EventReasonUninstallStarted, "Uninstalling shim from node %s", node.Name)
+	}
+	recordEvent(sr.Recorder, shim, &node, corev1.EventTypeNormal, EventReasonJobCreated, "Created %s job %s on node %s", jobType, job.Name, node.Name)
+
 	return nil
 }
 
 func (sr *ShimReconciler) updateNodeLabels(ctx context.Context, node *corev1.Node, shim *rcmv1.Shim, status string) error {
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
 	node.Labels[shim.Name] = status
 
 	if err := sr.Update(ctx, node); err != nil {
@@ -333,13 +497,11 @@ func (sr *ShimReconciler) updateNodeLabels(ctx context.Context, node *corev1.Nod
 
 // setOperationConfiguration sets operation specific configuration for the job manifest
 func (sr *ShimReconciler) setOperationConfiguration(shim *rcmv1.Shim, opConfig *opConfig) {
-	if opConfig.operation == INSTALL {
+	if opConfig.operation == INSTALL || opConfig.operation == PREFLIGHT {
 		opConfig.initContainer = []corev1.Container{{
-			Image: os.Getenv("SHIM_DOWNLOADER_IMAGE"),
-			Name:  "downloader",
-			SecurityContext: &corev1.SecurityContext{
-				Privileged: &opConfig.privileged,
-			},
+			Image:           sr.Config.DownloaderImage,
+			Name:            "downloader",
+			SecurityContext: downloaderSecurityContext(),
 			Env: []corev1.EnvVar{
 				{
 					Name:  "SHIM_NAME",
@@ -363,6 +525,36 @@ func (sr *ShimReconciler) setOperationConfiguration(shim *rcmv1.Shim, opConfig *
 			"/mnt/node-root",
 			"-r",
 			shim.Name,
+			"--restart-policy",
+			nodeRestartPolicy(shim.Spec.RestartPolicy),
+			"--source-url",
+			shim.Spec.FetchStrategy.AnonHTTP.Location,
+			"--fetch-strategy",
+			shim.Spec.FetchStrategy.Type,
+			"--shim-uid",
+			string(shim.UID),
+			"--shim-generation",
+			strconv.FormatInt(shim.Generation, 10),
+		}
+		if opConfig.operation == PREFLIGHT {
+			opConfig.args = append(opConfig.args, "--dry-run")
+		}
+		// A pinned version is activated from the versions kept on the node,
+		// so nothing is downloaded.
+		if shim.Spec.PinnedVersion != "" {
+			opConfig.initContainer = nil
+			opConfig.args = append(opConfig.args, "--pin", shim.Spec.PinnedVersion)
+		}
+	}
+
+	if opConfig.operation == VERIFY {
+		opConfig.initContainer = nil
+		opConfig.args = []string{
+			"verify",
+			"-H",
+			"/mnt/node-root",
+			"-r",
+			shim.Name,
 		}
 	}
 
@@ -378,16 +570,44 @@ func (sr *ShimReconciler) setOperationConfiguration(shim *rcmv1.Shim, opConfig *
 	}
 }
 
+// nodeRestartPolicy returns the restart policy node-installer applies. Nodes
+// are drained before the install job runs, so it can restart immediately.
+func nodeRestartPolicy(policy rcmv1.RestartPolicyType) string {
+	if policy == rcmv1.RestartPolicyDeferred {
+		return string(rcmv1.RestartPolicyDeferred)
+	}
+	return string(rcmv1.RestartPolicyImmediate)
+}
+
 // createJobManifest creates a Job manifest for a Shim.
 func (sr *ShimReconciler) createJobManifest(shim *rcmv1.Shim, node *corev1.Node, operation string) (*batchv1.Job, error) {
 	opConfig := opConfig{
 		operation:  operation,
-		privileged: true,
+		privileged: sr.Config.PrivilegedJobs,
 	}
 	sr.setOperationConfiguration(shim, &opConfig)
+	if sr.Config.LogFormat != "" {
+		opConfig.args = append(opConfig.args, "--log-format", sr.Config.LogFormat)
+	}
+	if sr.Config.LogLevel != "" {
+		opConfig.args = append(opConfig.args, "--log-level", sr.Config.LogLevel)
+	}
 
-	name := node.Name + "-" + shim.Name + "-" + operation
-	nameMax := int(math.Min(float64(len(name)), K8sNameMaxLength))
+	// Privileged jobs detect the distro from the files and processes of the
+	// host, unprivileged jobs are told the distro of the node.
+	var distro preset.Settings
+	if !opConfig.privileged {
+		var err error
+		if distro, err = nodeDistro(node); err != nil {
+			return nil, err
+		}
+		opConfig.args = append(opConfig.args, "--distro", distro.Name)
+	}
+	hostVolumes, hostMounts := sr.hostVolumes(opConfig.privileged, distro)
+
+	labels := jobLabels(node.Name, shim.Name, operation)
+	labels[JobPodLabel] = "true"
+	run := jobRun(shim, node)
 
 	job := &batchv1.Job{
 		TypeMeta: metav1.TypeMeta{
@@ -395,46 +615,38 @@ func (sr *ShimReconciler) createJobManifest(shim *rcmv1.Shim, node *corev1.Node,
 			Kind:       "Job",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name[:nameMax],
-			Namespace: os.Getenv("CONTROLLER_NAMESPACE"),
+			Name:      jobName(node.Name, shim.Name, operation, run),
+			Namespace: sr.Config.Namespace,
 			Annotations: map[string]string{
 				"kwasm.sh/nodeName":  node.Name,
 				"kwasm.sh/shimName":  shim.Name,
 				"kwasm.sh/operation": operation,
+				GenerationAnnotation: strconv.FormatInt(shim.Generation, 10),
+				RunAnnotation:        run,
 			},
-			Labels: map[string]string{
-				name[:nameMax]:       "true",
-				"kwasm.sh/shimName":  shim.Name,
-				"kwasm.sh/operation": operation,
-				"kwasm.sh/job":       "true",
-			},
+			Labels: labels,
 		},
 		Spec: batchv1.JobSpec{
 			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						JobPodLabel: "true",
+					},
+				},
 				Spec: corev1.PodSpec{
 					NodeName: node.Name,
-					HostPID:  true,
-					Volumes: []corev1.Volume{
+					HostPID:  needsHostPID(opConfig.privileged, distro),
+					Volumes: append([]corev1.Volume{
 						{
 							Name: "shim-download",
 						},
-						{
-							Name: "root-mount",
-							VolumeSource: corev1.VolumeSource{
-								HostPath: &corev1.HostPathVolumeSource{
-									Path: "/",
-								},
-							},
-						},
-					},
+					}, hostVolumes...),
 					InitContainers: opConfig.initContainer,
 					Containers: []corev1.Container{{
-						Image: os.Getenv("SHIM_NODE_INSTALLER_IMAGE"),
-						Args:  opConfig.args,
-						Name:  "provisioner",
-						SecurityContext: &corev1.SecurityContext{
-							Privileged: &opConfig.privileged,
-						},
+						Image:           sr.Config.NodeInstallerImage,
+						Args:            opConfig.args,
+						Name:            "provisioner",
+						SecurityContext: provisionerSecurityContext(opConfig.privileged),
 						Env: []corev1.EnvVar{
 							{
 								Name:  "HOST_ROOT",
@@ -445,29 +657,25 @@ func (sr *ShimReconciler) createJobManifest(shim *rcmv1.Shim, node *corev1.Node,
 								Value: "/mnt/node-root",
 							},
 						},
-						VolumeMounts: []corev1.VolumeMount{
-							{
-								Name:      "root-mount",
-								MountPath: "/mnt/node-root",
-							},
+						VolumeMounts: append([]corev1.VolumeMount{
 							{
 								Name:      "shim-download",
 								MountPath: "/assets",
 							},
-						},
+						}, hostMounts...),
 					}},
 					RestartPolicy: corev1.RestartPolicyNever,
 				},
 			},
 		},
 	}
+	applyJobTemplate(&job.Spec.Template, mergeJobTemplate(sr.Config.JobTemplate, shim.Spec.JobTemplate))
+
 	// set ttl for the installer job only if specified by the user
-	if ttlStr := os.Getenv("SHIM_NODE_INSTALLER_JOB_TTL"); ttlStr != "" {
-		if ttl, err := strconv.ParseInt(ttlStr, 10, 32); err == nil && ttl > 0 {
-			job.Spec.TTLSecondsAfterFinished = ptr(int32(ttl))
-		}
+	if sr.Config.JobTTLSeconds > 0 {
+		job.Spec.TTLSecondsAfterFinished = ptr(sr.Config.JobTTLSeconds)
 	}
-	if operation == INSTALL {
+	if operation != UNINSTALL {
 		if err := ctrl.SetControllerReference(shim, job, sr.Scheme); err != nil {
 			return nil, fmt.Errorf("failed to set controller reference: %w", err)
 		}
@@ -478,9 +686,9 @@ func (sr *ShimReconciler) createJobManifest(shim *rcmv1.Shim, node *corev1.Node,
 
 // handleDeployRuntimeClass deploys a RuntimeClass for a Shim.
 func (sr *ShimReconciler) handleDeployRuntimeClass(ctx context.Context, shim *rcmv1.Shim) (ctrl.Result, error) {
-	log := log.Ctx(ctx)
+	log := logging.FromContext(ctx)
 
-	log.Info().Msgf("Deploying RuntimeClass: %s", shim.Spec.RuntimeClass.Name)
+	log.Info("Deploying RuntimeClass", "runtimeClass", shim.Spec.RuntimeClass.Name)
 	runtimeClass, err := sr.createRuntimeClassManifest(shim)
 	if err != nil {
 		return ctrl.Result{}, err
@@ -495,7 +703,7 @@ func (sr *ShimReconciler) handleDeployRuntimeClass(ctx context.Context, shim *rc
 
 	// Note that we reconcile even if the deployment is in a good state. We rely on controller-runtime to rate limit us.
 	if err := sr.Client.Patch(ctx, runtimeClass, patchMethod, patchOptions); err != nil {
-		log.Error().Msgf("Unable to reconcile RuntimeClass %s", err)
+		log.Error("Unable to reconcile RuntimeClass", "error", err)
 		return ctrl.Result{}, fmt.Errorf("failed to reconcile RuntimeClass: %w", err)
 	}
 
@@ -546,7 +754,7 @@ func (sr *ShimReconciler) handleDeleteShim(ctx context.Context, shim *rcmv1.Shim
 				return err
 			}
 		} else {
-			log.Info().Msgf("Shim %s has no label on Node %s", shim.Name, node.Name)
+			logging.FromContext(ctx).Info("Shim has no label on node", logging.KeyShim, shim.Name, logging.KeyNode, node.Name)
 		}
 	}
 	return nil
@@ -569,22 +777,14 @@ func (sr *ShimReconciler) getNodeListFromShimsNodeSelector(ctx context.Context,
 	return nodes, nil
 }
 
-// runtimeClassExists checks whether a RuntimeClass for a Shim exists.
-func (sr *ShimReconciler) runtimeClassExists(ctx context.Context, shim *rcmv1.Shim) (bool, error) {
-	log := log.Ctx(ctx)
-
-	if shim.Spec.RuntimeClass.Name != "" {
-		runtimeClass, err := sr.getRuntimeClass(ctx, shim)
-		if err != nil {
-			log.Debug().Msgf("No RuntimeClass '%s' found", shim.Spec.RuntimeClass.Name)
-
-			return false, err
-		}
-		log.Debug().Msgf("RuntimeClass found: %s", runtimeClass.Name)
-		return true, nil
+// runtimeClassChanged returns whether the RuntimeClass of a Shim differs
+// from the Shim's spec.
+func (sr *ShimReconciler) runtimeClassChanged(shim *rcmv1.Shim, rc *nodev1.RuntimeClass) bool {
+	desired, err := sr.createRuntimeClassManifest(shim)
+	if err != nil {
+		return true
 	}
-	log.Debug().Msg("Shim.Spec.RuntimeClass not defined")
-	return false, nil
+	return rc.Handler != desired.Handler || !equality.Semantic.DeepEqual(rc.Scheduling, desired.Scheduling)
 }
 
 // getRuntimeClass finds a RuntimeClass.
@@ -18,6 +18,7 @@ package controller_test
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"runtime"
 	"testing"
@@ -68,6 +69,13 @@ var _ = BeforeSuite(func() {
 			fmt.Sprintf("1.28.3-%s-%s", runtime.GOOS, runtime.GOARCH)),
 	}
 
+	// Without the envtest binaries there is no API server to test against.
+	if _, ok := os.LookupEnv("KUBEBUILDER_ASSETS"); !ok {
+		if _, err := os.Stat(testEnv.BinaryAssetsDirectory); err != nil {
+			Skip("envtest binaries not found, run the tests with make test")
+		}
+	}
+
 	var err error
 	// cfg is defined in this file globally.
 	cfg, err = testEnv.Start()
@@ -85,6 +93,9 @@ var _ = BeforeSuite(func() {
 })
 
 var _ = AfterSuite(func() {
+	if cfg == nil {
+		return
+	}
 	By("tearing down the test environment")
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
@@ -0,0 +1,63 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spinkube/runtime-class-manager/internal/tracing"
+)
+
+// setTraceParent passes the trace context of the reconcile to the job and
+// configures its exporter like the one of the controller. The pod template
+// of a Job cannot be changed, so an existing Job keeps its trace context.
+func (sr *ShimReconciler) setTraceParent(ctx context.Context, job *batchv1.Job) error {
+	if !tracing.Enabled() {
+		return nil
+	}
+
+	traceParent := tracing.TraceParent(ctx)
+	existing := &batchv1.Job{}
+	err := sr.Get(ctx, client.ObjectKeyFromObject(job), existing)
+	switch {
+	case err == nil:
+		traceParent = existing.Annotations[tracing.Annotation]
+	case !apierrors.IsNotFound(err):
+		return fmt.Errorf("failed to fetch job: %w", err)
+	}
+	if traceParent == "" {
+		return nil
+	}
+
+	job.Annotations[tracing.Annotation] = traceParent
+	env := []corev1.EnvVar{{Name: tracing.EnvTraceParent, Value: traceParent}}
+	for _, kv := range tracing.ExporterEnv() {
+		name, value, _ := strings.Cut(kv, "=")
+		env = append(env, corev1.EnvVar{Name: name, Value: value})
+	}
+	for i := range job.Spec.Template.Spec.Containers {
+		job.Spec.Template.Spec.Containers[i].Env = append(job.Spec.Template.Spec.Containers[i].Env, env...)
+	}
+	return nil
+}
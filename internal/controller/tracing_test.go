@@ -0,0 +1,117 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controller //nolint:testpackage // whitebox test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rcmv1 "github.com/spinkube/runtime-class-manager/api/v1alpha1"
+	"github.com/spinkube/runtime-class-manager/internal/tracing"
+)
+
+const existingTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func inMemoryTracing(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	return exporter
+}
+
+func TestShimReconcilerSpan(t *testing.T) {
+	exporter := inMemoryTracing(t)
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, rcmv1.AddToScheme(scheme))
+	sr := &ShimReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).Build(), Scheme: scheme}
+
+	_, err := sr.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "spin"}})
+	require.NoError(t, err)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "ShimReconciler.Reconcile", spans[0].Name)
+	assert.Contains(t, spans[0].Attributes, attribute.String("shim", "spin"))
+}
+
+func TestSetTraceParent(t *testing.T) {
+	inMemoryTracing(t)
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4317")
+	ctx, span := tracing.Start(context.Background(), "reconcile")
+	defer span.End()
+
+	newJob := func() *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "rcm", Name: "worker-1-spin-install", Annotations: map[string]string{}},
+			Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "provisioner"}},
+			}}},
+		}
+	}
+	existing := newJob()
+	existing.Annotations[tracing.Annotation] = existingTraceParent
+
+	tests := []struct {
+		name    string
+		objects []client.Object
+		want    string
+	}{
+		{"new job", nil, tracing.TraceParent(ctx)},
+		{"existing job", []client.Object{existing}, existingTraceParent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sr := &ShimReconciler{Client: fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(tt.objects...).Build()}
+			job := newJob()
+			require.NoError(t, sr.setTraceParent(ctx, job))
+
+			assert.Equal(t, tt.want, job.Annotations[tracing.Annotation])
+			assert.Equal(t, []corev1.EnvVar{
+				{Name: tracing.EnvTraceParent, Value: tt.want},
+				{Name: "OTEL_EXPORTER_OTLP_ENDPOINT", Value: "http://collector:4317"},
+			}, job.Spec.Template.Spec.Containers[0].Env)
+		})
+	}
+}
+
+func TestSetTraceParentDisabled(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	ctx, span := tracing.Start(context.Background(), "reconcile")
+	defer span.End()
+
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+	require.NoError(t, (&ShimReconciler{}).setTraceParent(ctx, job))
+	assert.Empty(t, job.Annotations)
+}
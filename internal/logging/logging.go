@@ -0,0 +1,79 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package logging sets up the slog logger shared by the controller and
+// node-installer, so that both write records in the same format and with
+// the same keys.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/go-logr/logr"
+)
+
+// Formats of log records.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Keys of the attributes that identify what a log record is about.
+const (
+	KeyShim      = "shim"
+	KeyNode      = "node"
+	KeyJob       = "job"
+	KeyOperation = "operation"
+)
+
+// NewHandler returns a handler that writes records of at least the given
+// level to w in the given format. level is one of debug, info, warn and
+// error.
+func NewHandler(w io.Writer, format, level string) (slog.Handler, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q, must be one of debug, info, warn, error", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case FormatText:
+		return slog.NewTextHandler(w, opts), nil
+	case FormatJSON:
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, must be one of %s, %s", format, FormatText, FormatJSON)
+	}
+}
+
+// FromContext returns the logger of ctx. Reconcilers get a logger with the
+// controller and the reconciled object from controller-runtime. Without a
+// logger in ctx, the default logger is returned.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger := logr.FromContextAsSlogLogger(ctx); logger != nil {
+		return logger
+	}
+	return slog.Default()
+}
+
+// IntoContext returns a context that carries logger.
+func IntoContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return logr.NewContextWithSlogLogger(ctx, logger)
+}
@@ -0,0 +1,103 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/spinkube/runtime-class-manager/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		level   string
+		want    string
+		wantErr bool
+	}{
+		{"text", "text", "info", "level=INFO msg=installed shim=spin node=node-1\n", false},
+		{"json", "json", "info", `{"level":"INFO","msg":"installed","shim":"spin","node":"node-1"}` + "\n", false},
+		{"format is case insensitive", "JSON", "info", `{"level":"INFO","msg":"installed","shim":"spin","node":"node-1"}` + "\n", false},
+		{"below level", "text", "warn", "", false},
+		{"invalid format", "yaml", "info", "", true},
+		{"invalid level", "text", "verbose", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			handler, err := logging.NewHandler(&buf, tt.format, tt.level)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			slog.New(withoutTime{handler}).Info("installed", logging.KeyShim, "spin", logging.KeyNode, "node-1")
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestNewHandlerJSONKeys(t *testing.T) {
+	var buf bytes.Buffer
+	handler, err := logging.NewHandler(&buf, logging.FormatJSON, "debug")
+	require.NoError(t, err)
+
+	slog.New(handler).With(logging.KeyShim, "spin", logging.KeyOperation, "install").
+		Debug("deploying job", logging.KeyJob, "spin-install", logging.KeyNode, "node-1")
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "spin", record["shim"])
+	assert.Equal(t, "install", record["operation"])
+	assert.Equal(t, "spin-install", record["job"])
+	assert.Equal(t, "node-1", record["node"])
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Same(t, slog.Default(), logging.FromContext(context.Background()), "without a logger, the default logger is used")
+
+	var buf bytes.Buffer
+	handler, err := logging.NewHandler(&buf, logging.FormatText, "info")
+	require.NoError(t, err)
+	ctx := logging.IntoContext(context.Background(), slog.New(withoutTime{handler}).With(logging.KeyShim, "spin"))
+
+	logging.FromContext(ctx).Info("reconciling")
+	assert.Equal(t, "level=INFO msg=reconciling shim=spin\n", buf.String())
+}
+
+// withoutTime drops the time of records to make the output predictable.
+type withoutTime struct {
+	slog.Handler
+}
+
+func (h withoutTime) Handle(ctx context.Context, r slog.Record) error {
+	r.Time = time.Time{}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h withoutTime) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return withoutTime{h.Handler.WithAttrs(attrs)}
+}
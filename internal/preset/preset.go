@@ -2,18 +2,27 @@ package preset
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"os"
+	"path"
 	"strings"
 
 	"github.com/spf13/afero"
 	"github.com/spinkube/runtime-class-manager/internal/containerd"
 )
 
+// ErrImmutableConfig is returned by the setup of distros whose runtime config
+// cannot be changed by node-installer.
+var ErrImmutableConfig = errors.New("runtime config is immutable")
+
 type Settings struct {
-	ConfigPath string
-	Setup      func(Env) error
-	Restarter  containerd.Restarter
+	Name         string
+	ConfigPath   string
+	SocketPath   string
+	SystemdUnits []string
+	Setup        func(Env) error
+	Restarter    containerd.Restarter
 }
 
 type Env struct {
@@ -22,9 +31,32 @@ type Env struct {
 }
 
 var Default = Settings{
+	Name:       "default",
 	ConfigPath: "/etc/containerd/config.toml",
+	SocketPath: "/run/containerd/containerd.sock",
 	Setup:      func(_ Env) error { return nil },
-	Restarter:  containerd.NewRestarter(),
+}.WithSystemdUnits("containerd.service")
+
+// SystemBusPath is the directory of the system bus systemd is reached via.
+const SystemBusPath = "/run/dbus"
+
+// HostPaths returns the host directories node-installer needs on the distro:
+// the directories of the runtime config and socket, and the system bus if
+// containerd is restarted via systemd.
+func (s Settings) HostPaths() []string {
+	paths := []string{path.Dir(s.ConfigPath)}
+	if dir := path.Dir(s.SocketPath); dir != paths[0] {
+		paths = append(paths, dir)
+	}
+	if len(s.SystemdUnits) > 0 {
+		paths = append(paths, SystemBusPath)
+	}
+	return paths
+}
+
+func (s Settings) WithName(name string) Settings {
+	s.Name = name
+	return s
 }
 
 func (s Settings) WithConfigPath(path string) Settings {
@@ -32,14 +64,51 @@ func (s Settings) WithConfigPath(path string) Settings {
 	return s
 }
 
+func (s Settings) WithSocketPath(path string) Settings {
+	s.SocketPath = path
+	return s
+}
+
+// WithSystemdUnits sets the systemd units that run containerd. The units are
+// restarted via systemd, falling back to signaling containerd directly if
+// none of them is active.
+func (s Settings) WithSystemdUnits(units ...string) Settings {
+	s.SystemdUnits = units
+	s.Restarter = containerd.NewFallbackRestarter(
+		containerd.NewSystemdRestarter(units...),
+		containerd.NewRestarter(),
+	)
+	return s
+}
+
 func (s Settings) WithSetup(setup func(env Env) error) Settings {
 	s.Setup = setup
 	return s
 }
 
-var MicroK8s = Default.WithConfigPath("/var/snap/microk8s/current/args/containerd-template.toml")
+// WithRestarter sets a restarter for distros that do not run containerd as
+// a systemd unit.
+func (s Settings) WithRestarter(restarter containerd.Restarter) Settings {
+	s.SystemdUnits = nil
+	s.Restarter = restarter
+	return s
+}
+
+// immutable returns a setup that refuses to configure the distro, explaining
+// how to configure the runtime instead.
+func immutable(distro string, hint string) func(Env) error {
+	return func(_ Env) error {
+		return fmt.Errorf("%w on %s: %s", ErrImmutableConfig, distro, hint)
+	}
+}
 
-var RKE2 = Default.WithConfigPath("/var/lib/rancher/rke2/agent/etc/containerd/config.toml.tmpl").
+var MicroK8s = Default.WithName("microk8s").WithConfigPath("/var/snap/microk8s/current/args/containerd-template.toml").
+	WithSocketPath("/var/snap/microk8s/common/run/containerd.sock").
+	WithSystemdUnits("snap.microk8s.daemon-containerd.service")
+
+var RKE2 = Default.WithName("rke2").WithConfigPath("/var/lib/rancher/rke2/agent/etc/containerd/config.toml.tmpl").
+	WithSocketPath("/run/k3s/containerd/containerd.sock").
+	WithSystemdUnits("rke2-agent.service", "rke2-server.service").
 	WithSetup(func(env Env) error {
 		_, err := env.HostFs.Stat(env.ConfigPath)
 		if err == nil {
@@ -75,9 +144,12 @@ var RKE2 = Default.WithConfigPath("/var/lib/rancher/rke2/agent/etc/containerd/co
 		return err
 	})
 
-var K3s = RKE2.WithConfigPath("/var/lib/rancher/k3s/agent/etc/containerd/config.toml.tmpl")
+var K3s = RKE2.WithName("k3s").WithConfigPath("/var/lib/rancher/k3s/agent/etc/containerd/config.toml.tmpl").
+	WithSystemdUnits("k3s-agent.service", "k3s.service")
 
-var K0s = Default.WithConfigPath("/etc/k0s/containerd.d/config.toml").
+var K0s = Default.WithName("k0s").WithConfigPath("/etc/k0s/containerd.d/config.toml").
+	WithSocketPath("/run/k0s/containerd.sock").
+	WithSystemdUnits("k0sworker.service", "k0scontroller.service").
 	WithSetup(func(env Env) error {
 		_, err := env.HostFs.Stat(env.ConfigPath)
 		if err == nil {
@@ -94,3 +166,43 @@ var K0s = Default.WithConfigPath("/etc/k0s/containerd.d/config.toml").
 
 		return err
 	})
+
+// Kind runs containerd as a systemd unit inside the node container, with the
+// same config location as the default.
+var Kind = Default.WithName("kind")
+
+// K3d runs k3s as the init process of the node container, without systemd.
+// containerd is signaled directly, restarting k3s would stop the node.
+var K3d = K3s.WithName("k3d").WithRestarter(containerd.NewRestarter())
+
+// Talos generates the containerd config from the machine config, the root
+// file system is read-only.
+var Talos = Default.WithName("talos").
+	WithConfigPath("/etc/cri/conf.d/20-customization.part").
+	WithSocketPath("/run/containerd/containerd.sock").
+	WithSetup(immutable("Talos", "add the runtime to /etc/cri/conf.d/20-customization.part via machine.files in the machine config"))
+
+// Bottlerocket generates the containerd config from its settings API.
+var Bottlerocket = Default.WithName("bottlerocket").
+	WithSetup(immutable("Bottlerocket", "configure the runtime via the settings API or user data"))
+
+// OpenShift uses CRI-O, which is configured via MachineConfigs.
+var OpenShift = Default.WithName("openshift").
+	WithConfigPath("/etc/crio/crio.conf").
+	WithSocketPath("/run/crio/crio.sock").
+	WithSystemdUnits("crio.service").
+	WithSetup(immutable("OpenShift", "CRI-O is not supported yet, configure the runtime via a MachineConfig"))
+
+// All are the presets of all supported distros. Of the presets that share a
+// config path, the one of the plain distro comes first.
+var All = []Settings{Default, Kind, K3s, K3d, RKE2, MicroK8s, K0s, Talos, Bottlerocket, OpenShift}
+
+// ByName returns the preset of the distro with the given name.
+func ByName(name string) (Settings, bool) {
+	for _, s := range All {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Settings{}, false
+}
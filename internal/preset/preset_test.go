@@ -95,3 +95,40 @@ func Test_WithSetup(t *testing.T) {
 		})
 	}
 }
+
+func Test_ImmutableSetup(t *testing.T) {
+	for _, settings := range []preset.Settings{preset.Talos, preset.Bottlerocket, preset.OpenShift} {
+		t.Run(settings.Name, func(t *testing.T) {
+			err := settings.Setup(preset.Env{ConfigPath: settings.ConfigPath, HostFs: afero.NewMemMapFs()})
+			require.ErrorIs(t, err, preset.ErrImmutableConfig)
+		})
+	}
+}
+
+func Test_HostPaths(t *testing.T) {
+	tests := []struct {
+		settings preset.Settings
+		want     []string
+	}{
+		{preset.Default, []string{"/etc/containerd", "/run/containerd", "/run/dbus"}},
+		{preset.K3s, []string{"/var/lib/rancher/k3s/agent/etc/containerd", "/run/k3s/containerd", "/run/dbus"}},
+		{preset.K3d, []string{"/var/lib/rancher/k3s/agent/etc/containerd", "/run/k3s/containerd"}},
+		{preset.MicroK8s, []string{"/var/snap/microk8s/current/args", "/var/snap/microk8s/common/run", "/run/dbus"}},
+		{preset.Talos, []string{"/etc/cri/conf.d", "/run/containerd", "/run/dbus"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.settings.Name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.settings.HostPaths())
+		})
+	}
+}
+
+func Test_ByName(t *testing.T) {
+	for _, settings := range preset.All {
+		got, ok := preset.ByName(settings.Name)
+		require.True(t, ok, settings.Name)
+		require.Equal(t, settings.ConfigPath, got.ConfigPath)
+	}
+	_, ok := preset.ByName("windows")
+	require.False(t, ok)
+}
@@ -0,0 +1,182 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package shim
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+
+	"github.com/spf13/afero"
+	"github.com/spinkube/runtime-class-manager/internal/state"
+)
+
+// ManifestFile is the name of the manifest describing a shim bundle in the
+// asset directory.
+const ManifestFile = "kwasm-manifest.json"
+
+var ErrInvalidManifest = errors.New("invalid bundle manifest")
+
+// Manifest describes a shim bundle: the shim binary and the auxiliary files
+// installed with it, like helper executables or config files.
+type Manifest struct {
+	// Shim is the file name of the shim binary in the asset directory. It
+	// defaults to the only file named containerd-shim-*.
+	Shim string `json:"shim,omitempty"`
+	// Files are the auxiliary files of the bundle.
+	Files []BundleFile `json:"files,omitempty"`
+}
+
+// BundleFile is an auxiliary file of a shim bundle.
+type BundleFile struct {
+	// Source is the path of the file relative to the asset directory.
+	Source string `json:"source"`
+	// Destination is the path the file is installed to on the host.
+	// Relative paths are relative to the kwasm path.
+	Destination string `json:"destination"`
+	// Executable installs the file with execute permissions.
+	Executable bool `json:"executable,omitempty"`
+}
+
+// ReadManifest reads the bundle manifest from the asset directory. It returns
+// nil if the directory contains no manifest.
+func ReadManifest(fs afero.Fs, assetPath string) (*Manifest, error) {
+	data, err := afero.ReadFile(fs, path.Join(assetPath, ManifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil //nolint:nilnil // a missing manifest is not an error
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidManifest, err)
+	}
+
+	if m.Shim == "" {
+		matches, err := afero.Glob(fs, path.Join(assetPath, "containerd-shim-*"))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) != 1 {
+			return nil, fmt.Errorf("%w: found %d shim binaries, set shim in the manifest", ErrInvalidManifest, len(matches))
+		}
+		m.Shim = path.Base(matches[0])
+	}
+	if !filepath.IsLocal(m.Shim) {
+		return nil, fmt.Errorf("%w: shim %s is not in the asset directory", ErrInvalidManifest, m.Shim)
+	}
+	for _, f := range m.Files {
+		if !filepath.IsLocal(f.Source) {
+			return nil, fmt.Errorf("%w: source %s is not in the asset directory", ErrInvalidManifest, f.Source)
+		}
+		if f.Destination == "" || (!path.IsAbs(f.Destination) && !filepath.IsLocal(f.Destination)) {
+			return nil, fmt.Errorf("%w: invalid destination %q", ErrInvalidManifest, f.Destination)
+		}
+	}
+
+	return &m, nil
+}
+
+// InstallFiles installs the auxiliary files of a bundle for the installed
+// shim runtimeName and records them in the lock file. Files of an earlier
+// install that are no longer part of the bundle are removed. It returns
+// whether any file changed.
+func (c *Config) InstallFiles(runtimeName string, files []BundleFile) (changed bool, err error) {
+	st, err := state.Get(c.hostFs, c.kwasmPath)
+	if err != nil {
+		return false, err
+	}
+	current, ok := st.Shims[runtimeName]
+	if !ok {
+		return false, fmt.Errorf("shim %s not installed", runtimeName)
+	}
+
+	installed := make([]state.File, 0, len(files))
+	for _, f := range files {
+		srcPath := path.Join(c.assetPath, f.Source)
+		sum, err := fileSha256(c.rootFs, srcPath)
+		if err != nil {
+			return false, err
+		}
+
+		dstFilePath := c.bundleFilePath(f.Destination)
+		installed = append(installed, state.File{Path: dstFilePath, Sha256: sum})
+		if existing, err := fileSha256(c.hostFs, dstFilePath); err == nil && bytes.Equal(existing, sum) {
+			continue
+		}
+
+		var perm os.FileMode = 0o644 //nolint:mnd // file permissions
+		if f.Executable {
+			perm = 0o755 //nolint:mnd // file permissions
+		}
+		if err := c.replaceFile(srcPath, dstFilePath, sum, perm); err != nil {
+			return false, fmt.Errorf("failed to install %s: %w", dstFilePath, err)
+		}
+		slog.Info("bundle file installed", "shim", runtimeName, "path", dstFilePath)
+		changed = true
+	}
+
+	var removed []state.File
+	for _, old := range current.Files {
+		if !slices.ContainsFunc(installed, func(f state.File) bool { return f.Path == old.Path }) {
+			removed = append(removed, old)
+			changed = true
+		}
+	}
+
+	entry := *current
+	entry.Files = installed
+	if len(entry.Files) == 0 {
+		entry.Files = nil
+	}
+	if !reflect.DeepEqual(current, &entry) {
+		st.UpdateShim(runtimeName, entry)
+		if err := st.Write(); err != nil {
+			return false, err
+		}
+	}
+	c.removeFiles(runtimeName, removed)
+
+	return changed, nil
+}
+
+// bundleFilePath returns the path on the host a bundle file is installed to.
+func (c *Config) bundleFilePath(destination string) string {
+	if path.IsAbs(destination) {
+		return path.Clean(destination)
+	}
+	return path.Join(c.kwasmPath, destination)
+}
+
+// removeFiles deletes auxiliary files. Failing to delete a file only leaves
+// it behind, so it is logged.
+func (c *Config) removeFiles(runtimeName string, files []state.File) {
+	for _, f := range files {
+		if err := c.hostFs.Remove(f.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("failed to remove bundle file", "shim", runtimeName, "path", f.Path, "error", err)
+		}
+	}
+}
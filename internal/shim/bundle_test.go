@@ -0,0 +1,172 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package shim //nolint:testpackage // whitebox test
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/spinkube/runtime-class-manager/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadManifest(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    *Manifest
+		wantErr error
+	}{
+		{
+			"no manifest",
+			map[string]string{"containerd-shim-wws": "shim"},
+			nil,
+			nil,
+		},
+		{
+			"shim defaults to the only shim binary",
+			map[string]string{
+				"containerd-shim-wws": "shim",
+				ManifestFile:          `{"files":[{"source":"lib/runtime.toml","destination":"lib/wws/runtime.toml"}]}`,
+			},
+			&Manifest{Shim: "containerd-shim-wws", Files: []BundleFile{{Source: "lib/runtime.toml", Destination: "lib/wws/runtime.toml"}}},
+			nil,
+		},
+		{
+			"explicit shim",
+			map[string]string{ManifestFile: `{"shim":"wws-shim","files":[{"source":"helper","destination":"/usr/local/bin/wws-helper","executable":true}]}`},
+			&Manifest{Shim: "wws-shim", Files: []BundleFile{{Source: "helper", Destination: "/usr/local/bin/wws-helper", Executable: true}}},
+			nil,
+		},
+		{
+			"ambiguous shim",
+			map[string]string{"containerd-shim-a": "a", "containerd-shim-b": "b", ManifestFile: `{}`},
+			nil,
+			ErrInvalidManifest,
+		},
+		{
+			"source outside of the asset directory",
+			map[string]string{ManifestFile: `{"shim":"shim","files":[{"source":"../etc/passwd","destination":"passwd"}]}`},
+			nil,
+			ErrInvalidManifest,
+		},
+		{
+			"destination outside of the kwasm path",
+			map[string]string{ManifestFile: `{"shim":"shim","files":[{"source":"helper","destination":"../helper"}]}`},
+			nil,
+			ErrInvalidManifest,
+		},
+		{
+			"malformed manifest",
+			map[string]string{ManifestFile: `{`},
+			nil,
+			ErrInvalidManifest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			for name, content := range tt.files {
+				require.NoError(t, afero.WriteFile(fs, "/assets/"+name, []byte(content), 0o644))
+			}
+
+			got, err := ReadManifest(fs, "/assets")
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestConfig_InstallFiles(t *testing.T) {
+	rootFs := afero.NewMemMapFs()
+	hostFs := afero.NewMemMapFs()
+	for name, content := range map[string]string{
+		"containerd-shim-wws": "shim",
+		"helper":              "helper",
+		"lib/runtime.toml":    "config",
+	} {
+		require.NoError(t, afero.WriteFile(rootFs, "/assets/"+name, []byte(content), 0o644))
+	}
+	c := NewConfig(rootFs, hostFs, "/assets", "/opt/kwasm")
+	_, _, err := c.Install("containerd-shim-wws")
+	require.NoError(t, err)
+
+	files := []BundleFile{
+		{Source: "helper", Destination: "/usr/local/bin/wws-helper", Executable: true},
+		{Source: "lib/runtime.toml", Destination: "lib/wws/runtime.toml"},
+	}
+
+	t.Run("install", func(t *testing.T) {
+		changed, err := c.InstallFiles("wws", files)
+		require.NoError(t, err)
+		assert.True(t, changed)
+
+		info, err := hostFs.Stat("/usr/local/bin/wws-helper")
+		require.NoError(t, err)
+		assert.Equal(t, "-rwxr-xr-x", info.Mode().Perm().String())
+		data, err := afero.ReadFile(hostFs, "/opt/kwasm/lib/wws/runtime.toml")
+		require.NoError(t, err)
+		assert.Equal(t, "config", string(data))
+
+		st, err := state.Get(hostFs, "/opt/kwasm")
+		require.NoError(t, err)
+		require.Len(t, st.Shims["wws"].Files, 2)
+		assert.Equal(t, "/usr/local/bin/wws-helper", st.Shims["wws"].Files[0].Path)
+		assert.Equal(t, "/opt/kwasm/lib/wws/runtime.toml", st.Shims["wws"].Files[1].Path)
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		changed, err := c.InstallFiles("wws", files)
+		require.NoError(t, err)
+		assert.False(t, changed)
+	})
+
+	t.Run("files are kept when a new shim version is installed", func(t *testing.T) {
+		require.NoError(t, afero.WriteFile(rootFs, "/assets/containerd-shim-wws", []byte("shim v2"), 0o644))
+		_, changed, err := c.Install("containerd-shim-wws")
+		require.NoError(t, err)
+		assert.True(t, changed)
+
+		st, err := state.Get(hostFs, "/opt/kwasm")
+		require.NoError(t, err)
+		assert.Len(t, st.Shims["wws"].Files, 2)
+	})
+
+	t.Run("file removed from bundle", func(t *testing.T) {
+		changed, err := c.InstallFiles("wws", files[:1])
+		require.NoError(t, err)
+		assert.True(t, changed)
+
+		exists, err := afero.Exists(hostFs, "/opt/kwasm/lib/wws/runtime.toml")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("uninstall removes all files", func(t *testing.T) {
+		_, err := c.Uninstall("wws")
+		require.NoError(t, err)
+
+		exists, err := afero.Exists(hostFs, "/usr/local/bin/wws-helper")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+}
@@ -17,51 +17,160 @@
 package shim
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
+	"time"
 
+	"github.com/spf13/afero"
 	"github.com/spinkube/runtime-class-manager/internal/state"
 )
 
+// ErrShimChanged is returned if the shim asset changed while it was installed.
+var ErrShimChanged = errors.New("shim changed during installation")
+
+// Install installs the shim binary shimName from the asset path into a
+// versioned path below the kwasm path. The previously installed version is
+// kept for rollbacks. It returns the path of the installed binary and
+// whether it changed.
 func (c *Config) Install(shimName string) (filePath string, changed bool, err error) {
 	shimPath := filepath.Join(c.assetPath, shimName)
-	srcFile, err := c.rootFs.OpenFile(shimPath, os.O_RDONLY, 0o000) //nolint:mnd // file permissions
+	sum, err := fileSha256(c.rootFs, shimPath)
 	if err != nil {
 		return "", false, err
 	}
-	dstFilePath := path.Join(c.kwasmPath, "bin", shimName)
 
-	err = c.hostFs.MkdirAll(path.Dir(dstFilePath), 0o775) //nolint:mnd // file permissions
+	st, err := state.Get(c.hostFs, c.kwasmPath)
 	if err != nil {
-		return dstFilePath, false, err
+		return "", false, err
 	}
+	runtimeName := RuntimeName(shimName)
+	current, installed := st.Shims[runtimeName]
 
-	dstFile, err := c.hostFs.OpenFile(dstFilePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o755) //nolint:mnd // file permissions
-	if err != nil {
+	if installed && bytes.Equal(current.Sha256, sum) && c.binaryIntact(current.Path, sum) {
+		// The binary is unchanged, only its metadata is updated if it differs.
+		entry := *current
+		entry.Source = c.source
+		entry.Handler = runtimeName
+		entry.ConfigPath = c.configPath
+		if !reflect.DeepEqual(current, &entry) {
+			st.UpdateShim(runtimeName, entry)
+			if err := st.Write(); err != nil {
+				return "", false, err
+			}
+		}
+		return current.Path, false, nil
+	}
+
+	dstFilePath := c.versionPath(shimName, sum)
+	if err := c.copyVersion(shimPath, dstFilePath, sum); err != nil {
 		return "", false, err
 	}
 
-	st, err := state.Get(c.hostFs, c.kwasmPath)
-	if err != nil {
+	entry := state.Shim{
+		Path:        dstFilePath,
+		Sha256:      sum,
+		Source:      c.source,
+		InstalledAt: time.Now(),
+		Handler:     runtimeName,
+		ConfigPath:  c.configPath,
+	}
+	var pruned []state.Version
+	if installed {
+		entry.Versions, pruned = retainVersions(current, entry.Sha256, c.keepVersions)
+		entry.Files = current.Files
+	}
+	st.UpdateShim(runtimeName, entry)
+	if err := st.Write(); err != nil {
 		return "", false, err
 	}
-	shimSha256 := sha256.New()
+	c.removeVersions(runtimeName, pruned)
 
-	_, err = io.Copy(io.MultiWriter(dstFile, shimSha256), srcFile)
-	runtimeName := RuntimeName(shimName)
-	changed = st.ShimChanged(runtimeName, shimSha256.Sum(nil), dstFilePath)
-	if changed {
-		st.UpdateShim(runtimeName, state.Shim{
-			Path:   dstFilePath,
-			Sha256: shimSha256.Sum(nil),
-		})
-		if err := st.Write(); err != nil {
-			return "", false, err
-		}
+	return dstFilePath, true, nil
+}
+
+// binaryIntact returns whether the binary at filePath exists and still has
+// the sha256 sum recorded in the lock file. A binary that has been removed
+// or modified since it has been installed is installed again.
+func (c *Config) binaryIntact(filePath string, sum []byte) bool {
+	existing, err := fileSha256(c.hostFs, filePath)
+	return err == nil && bytes.Equal(existing, sum)
+}
+
+// copyVersion copies the shim to its versioned path. A binary that is
+// already there from an earlier install is reused, as it may still be
+// executed by running shim processes.
+func (c *Config) copyVersion(shimPath, dstFilePath string, sum []byte) error {
+	if c.binaryIntact(dstFilePath, sum) {
+		return nil
 	}
+	return c.replaceFile(shimPath, dstFilePath, sum, 0o755) //nolint:mnd // file permissions
+}
 
-	return dstFilePath, changed, err
+// replaceFile copies srcPath from the root filesystem to dstFilePath on the
+// host. The file is written to a temporary file next to the destination,
+// which is renamed into place once it is complete, so that a file at the
+// destination is never truncated and stays in place if the copy fails.
+func (c *Config) replaceFile(srcPath, dstFilePath string, sum []byte, perm os.FileMode) error {
+	srcFile, err := c.rootFs.OpenFile(srcPath, os.O_RDONLY, 0o000) //nolint:mnd // file permissions
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	err = c.hostFs.MkdirAll(path.Dir(dstFilePath), 0o775) //nolint:mnd // file permissions
+	if err != nil {
+		return err
+	}
+
+	tmpFilePath := dstFilePath + ".tmp"
+	if err := c.writeVerified(tmpFilePath, srcFile, sum, perm); err != nil {
+		_ = c.hostFs.Remove(tmpFilePath)
+		return err
+	}
+
+	if err := c.hostFs.Rename(tmpFilePath, dstFilePath); err != nil {
+		_ = c.hostFs.Remove(tmpFilePath)
+		return err
+	}
+	return nil
+}
+
+// writeVerified writes src to filePath and checks that the written content
+// has the sha256 sum, in case the source changed since it has been hashed.
+func (c *Config) writeVerified(filePath string, src io.Reader, sum []byte, perm os.FileMode) error {
+	dstFile, err := c.hostFs.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dstFile, h), src); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), sum) {
+		return fmt.Errorf("%w: %s", ErrShimChanged, filePath)
+	}
+	return dstFile.Close()
+}
+
+func fileSha256(fs afero.Fs, filePath string) ([]byte, error) {
+	f, err := fs.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
 }
@@ -17,9 +17,12 @@
 package shim //nolint:testpackage // whitebox test
 
 import (
+	"errors"
+	"os"
 	"testing"
 
 	"github.com/spf13/afero"
+	"github.com/spinkube/runtime-class-manager/internal/state"
 	tests "github.com/spinkube/runtime-class-manager/tests/node-installer"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -56,7 +59,7 @@ func TestConfig_Install(t *testing.T) {
 			},
 			args{"containerd-shim-slight-v1"},
 			wants{
-				"/opt/kwasm/bin/containerd-shim-slight-v1",
+				"/opt/kwasm/bin/slight-v1/a0b2c89fea105c727a1358856a24afb119891e852b452176d6ec65dc85b74df8/containerd-shim-slight-v1",
 				true,
 			},
 			false,
@@ -86,7 +89,7 @@ func TestConfig_Install(t *testing.T) {
 			},
 			args{"containerd-shim-slight-v1"},
 			wants{
-				"/opt/kwasm/bin/containerd-shim-slight-v1",
+				"/opt/kwasm/bin/slight-v1/a0b2c89fea105c727a1358856a24afb119891e852b452176d6ec65dc85b74df8/containerd-shim-slight-v1",
 				true,
 			},
 			false,
@@ -114,9 +117,9 @@ func TestConfig_Install(t *testing.T) {
 				"/assets",
 				"/opt/kwasm",
 			},
-			args{"containerd-shim-spin-v1"},
+			args{"containerd-shim-slight-v1"},
 			wants{
-				"/opt/kwasm/bin/containerd-shim-spin-v1",
+				"",
 				false,
 			},
 			true,
@@ -144,3 +147,143 @@ func TestConfig_Install(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_InstallMetadata(t *testing.T) {
+	hostFs := tests.FixtureFs("../../testdata/node-installer/shim")
+	source := state.Source{URL: "https://example.com/spin.tar.gz", FetchStrategy: "anonHttp", ShimUID: "1234", ShimGeneration: 2}
+	c := NewConfig(tests.FixtureFs("../../testdata/node-installer"), hostFs, "/assets", "/opt/kwasm").
+		WithMetadata(source, "/etc/containerd/config.toml")
+
+	// The binary is unchanged, but the metadata is recorded.
+	_, changed, err := c.Install("containerd-shim-spin-v1")
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	st, err := state.Get(hostFs, "/opt/kwasm")
+	require.NoError(t, err)
+	shim := st.Shims["spin-v1"]
+	assert.Equal(t, source, shim.Source)
+	assert.Equal(t, "spin-v1", shim.Handler)
+	assert.Equal(t, "/etc/containerd/config.toml", shim.ConfigPath)
+	assert.True(t, shim.InstalledAt.IsZero(), "install time of an unchanged binary is kept")
+
+	_, changed, err = c.Install("containerd-shim-slight-v1")
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	st, err = state.Get(hostFs, "/opt/kwasm")
+	require.NoError(t, err)
+	assert.False(t, st.Shims["slight-v1"].InstalledAt.IsZero())
+}
+
+// recordingFs records the files opened for writing and fails renames with
+// renameErr if it is set.
+type recordingFs struct {
+	afero.Fs
+	renameErr error
+	written   []string
+}
+
+func (fs *recordingFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		fs.written = append(fs.written, name)
+	}
+	return fs.Fs.OpenFile(name, flag, perm)
+}
+
+func (fs *recordingFs) Rename(oldname, newname string) error {
+	if fs.renameErr != nil {
+		return fs.renameErr
+	}
+	return fs.Fs.Rename(oldname, newname)
+}
+
+func TestConfig_InstallReplacement(t *testing.T) {
+	const binPath = "/opt/kwasm/bin/slight-v1/a0b2c89fea105c727a1358856a24afb119891e852b452176d6ec65dc85b74df8/containerd-shim-slight-v1"
+	assets := tests.FixtureFs("../../testdata/node-installer")
+
+	tests := []struct {
+		name        string
+		renameErr   error
+		wantErr     bool
+		wantContent string
+	}{
+		{"corrupted binary is replaced", nil, false, ""},
+		{"binary is kept if the replacement fails", errors.New("rename failed"), true, "corrupted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memFs := afero.NewMemMapFs()
+			require.NoError(t, afero.WriteFile(memFs, binPath, []byte("corrupted"), 0o755))
+			hostFs := &recordingFs{Fs: memFs, renameErr: tt.renameErr}
+			c := NewConfig(assets, hostFs, "/assets", "/opt/kwasm")
+
+			_, _, err := c.Install("containerd-shim-slight-v1")
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			want := []byte(tt.wantContent)
+			if tt.wantContent == "" {
+				want, err = afero.ReadFile(assets, "/assets/containerd-shim-slight-v1")
+				require.NoError(t, err)
+			}
+			got, err := afero.ReadFile(memFs, binPath)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+
+			exists, err := afero.Exists(memFs, binPath+".tmp")
+			require.NoError(t, err)
+			assert.False(t, exists, "temporary file is removed")
+			assert.NotContains(t, hostFs.written, binPath, "binary is not opened for writing")
+		})
+	}
+}
+
+func TestConfig_InstallUnchangedNotWritten(t *testing.T) {
+	hostFs := &recordingFs{Fs: tests.FixtureFs("../../testdata/node-installer/shim")}
+	c := NewConfig(tests.FixtureFs("../../testdata/node-installer"), hostFs, "/assets", "/opt/kwasm")
+
+	_, changed, err := c.Install("containerd-shim-spin-v1")
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Empty(t, hostFs.written)
+}
+
+func TestConfig_InstallRestoresBinary(t *testing.T) {
+	assets := tests.FixtureFs("../../testdata/node-installer")
+	want, err := afero.ReadFile(assets, "/assets/containerd-shim-slight-v1")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		modify func(t *testing.T, hostFs afero.Fs, binPath string)
+	}{
+		{"deleted binary", func(t *testing.T, hostFs afero.Fs, binPath string) {
+			require.NoError(t, hostFs.Remove(binPath))
+		}},
+		{"modified binary", func(t *testing.T, hostFs afero.Fs, binPath string) {
+			require.NoError(t, afero.WriteFile(hostFs, binPath, []byte("modified"), 0o755))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hostFs := afero.NewMemMapFs()
+			c := NewConfig(assets, hostFs, "/assets", "/opt/kwasm")
+			binPath, _, err := c.Install("containerd-shim-slight-v1")
+			require.NoError(t, err)
+
+			tt.modify(t, hostFs, binPath)
+
+			reinstalled, changed, err := c.Install("containerd-shim-slight-v1")
+			require.NoError(t, err)
+			assert.True(t, changed, "a binary that is not intact is installed again")
+			assert.Equal(t, binPath, reinstalled)
+			got, err := afero.ReadFile(hostFs, binPath)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"strings"
 
 	"github.com/spf13/afero"
+	"github.com/spinkube/runtime-class-manager/internal/state"
 )
 
 type Config struct {
@@ -27,17 +28,37 @@ type Config struct {
 	hostFs    afero.Fs
 	assetPath string
 	kwasmPath string
+	// source and configPath are recorded in the lock file for installed shims.
+	source     state.Source
+	configPath string
+	// keepVersions is the number of previous versions kept per shim.
+	keepVersions int
 }
 
 func NewConfig(rootFs afero.Fs, hostFs afero.Fs, assetPath string, kwasmPath string) *Config {
 	return &Config{
-		rootFs:    rootFs,
-		hostFs:    hostFs,
-		assetPath: assetPath,
-		kwasmPath: kwasmPath,
+		rootFs:       rootFs,
+		hostFs:       hostFs,
+		assetPath:    assetPath,
+		kwasmPath:    kwasmPath,
+		keepVersions: DefaultKeepVersions,
 	}
 }
 
+// WithKeepVersions sets the number of previous versions kept per shim.
+func (c *Config) WithKeepVersions(keep int) *Config {
+	c.keepVersions = keep
+	return c
+}
+
+// WithMetadata sets where installed shims come from and the containerd
+// config they are configured in, to be recorded in the lock file.
+func (c *Config) WithMetadata(source state.Source, configPath string) *Config {
+	c.source = source
+	c.configPath = configPath
+	return c
+}
+
 func RuntimeName(bin string) string {
 	return strings.TrimPrefix(bin, "containerd-shim-")
 }
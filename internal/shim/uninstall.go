@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
 
 	"github.com/spinkube/runtime-class-manager/internal/state"
 )
@@ -25,6 +26,13 @@ func (c *Config) Uninstall(shimName string) (string, error) {
 			return "", fmt.Errorf("shim binary at %s does not exist, nothing to delete", filePath)
 		}
 	}
+	// Previous versions and bundle files are removed with the shim,
+	// including the directory holding the versioned binaries.
+	c.removeVersions(shimName, s.Versions)
+	c.removeFiles(shimName, s.Files)
+	if err := c.hostFs.RemoveAll(path.Join(c.kwasmPath, "bin", shimName)); err != nil {
+		return "", err
+	}
 	st.RemoveShim(shimName)
 	if err = st.Write(); err != nil {
 		return "", err
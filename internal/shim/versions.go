@@ -0,0 +1,152 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package shim
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+
+	"github.com/spf13/afero"
+	"github.com/spinkube/runtime-class-manager/internal/state"
+)
+
+// DefaultKeepVersions is the number of previous versions kept per shim.
+const DefaultKeepVersions = 2
+
+var (
+	ErrNoPreviousVersion = errors.New("no previous version to roll back to")
+	ErrVersionNotFound   = errors.New("version not found")
+	ErrAmbiguousVersion  = errors.New("version prefix matches more than one version")
+)
+
+// versionPath returns the path a version of a shim binary is installed to,
+// /opt/kwasm/bin/<runtime name>/<sha256>/<binary name>. The binary keeps its
+// name, as containerd derives the runtime name from it.
+func (c *Config) versionPath(binName string, sum []byte) string {
+	return path.Join(c.kwasmPath, "bin", RuntimeName(binName), hex.EncodeToString(sum), binName)
+}
+
+// retainVersions returns the previous versions of a shim after the current
+// version has been replaced by the version with sha256 newSum, and the
+// versions that are no longer kept.
+func retainVersions(current *state.Shim, newSum []byte, keep int) (retained, pruned []state.Version) {
+	versions := append([]state.Version{{
+		Sha256:      current.Sha256,
+		Path:        current.Path,
+		Source:      current.Source,
+		InstalledAt: current.InstalledAt,
+	}}, current.Versions...)
+
+	for _, v := range versions {
+		switch {
+		case bytes.Equal(v.Sha256, newSum):
+			// The version becomes the current one again.
+		case len(retained) < keep:
+			retained = append(retained, v)
+		default:
+			pruned = append(pruned, v)
+		}
+	}
+	return retained, pruned
+}
+
+// removeVersions deletes the binaries of versions that are no longer kept.
+// Failing to delete a binary only leaves it behind, so it is logged.
+func (c *Config) removeVersions(runtimeName string, versions []state.Version) {
+	for _, v := range versions {
+		target := v.Path
+		// Versioned binaries are removed with their directory, binaries
+		// installed before versioning only as a file.
+		if path.Dir(path.Dir(v.Path)) == path.Join(c.kwasmPath, "bin", runtimeName) {
+			target = path.Dir(v.Path)
+		}
+		if err := c.hostFs.RemoveAll(target); err != nil {
+			slog.Warn("failed to remove old shim version", "shim", runtimeName, "path", target, "error", err)
+		}
+	}
+}
+
+// Activate makes a kept version of the shim the current one, without
+// downloading it again. The version is selected by a prefix of its sha256;
+// an empty prefix selects the most recent previous version. It returns the
+// path of the activated binary and whether the current version changed.
+func (c *Config) Activate(runtimeName string, sha256Prefix string) (filePath string, changed bool, err error) {
+	st, err := state.Get(c.hostFs, c.kwasmPath)
+	if err != nil {
+		return "", false, err
+	}
+	current, ok := st.Shims[runtimeName]
+	if !ok {
+		return "", false, fmt.Errorf("shim %s not installed", runtimeName)
+	}
+
+	if sha256Prefix != "" && strings.HasPrefix(hex.EncodeToString(current.Sha256), sha256Prefix) {
+		return current.Path, false, nil
+	}
+
+	version, err := findVersion(current.Versions, sha256Prefix)
+	if err != nil {
+		return "", false, err
+	}
+	if exists, err := afero.Exists(c.hostFs, version.Path); err != nil || !exists {
+		return "", false, fmt.Errorf("binary of version %x at %s is missing", version.Sha256, version.Path)
+	}
+
+	entry := *current
+	entry.Sha256 = version.Sha256
+	entry.Path = version.Path
+	entry.Source = version.Source
+	entry.InstalledAt = version.InstalledAt
+	entry.Versions, _ = retainVersions(current, version.Sha256, len(current.Versions))
+	st.UpdateShim(runtimeName, entry)
+	if err := st.Write(); err != nil {
+		return "", false, err
+	}
+
+	return version.Path, true, nil
+}
+
+// findVersion returns the version whose sha256 starts with prefix, or the
+// first version if prefix is empty.
+func findVersion(versions []state.Version, prefix string) (state.Version, error) {
+	if prefix == "" {
+		if len(versions) == 0 {
+			return state.Version{}, ErrNoPreviousVersion
+		}
+		return versions[0], nil
+	}
+
+	var found []state.Version
+	for _, v := range versions {
+		if strings.HasPrefix(hex.EncodeToString(v.Sha256), prefix) {
+			found = append(found, v)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return state.Version{}, fmt.Errorf("%w: %s", ErrVersionNotFound, prefix)
+	case 1:
+		return found[0], nil
+	default:
+		return state.Version{}, fmt.Errorf("%w: %s", ErrAmbiguousVersion, prefix)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package shim //nolint:testpackage // whitebox test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/spinkube/runtime-class-manager/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// installVersions installs a binary with each of the contents in order and
+// returns the sha256 of each version.
+func installVersions(t *testing.T, c *Config, contents ...string) []string {
+	t.Helper()
+
+	sums := make([]string, 0, len(contents))
+	for _, content := range contents {
+		require.NoError(t, afero.WriteFile(c.rootFs, "/assets/containerd-shim-spin-v2", []byte(content), 0o755))
+		_, changed, err := c.Install("containerd-shim-spin-v2")
+		require.NoError(t, err)
+		require.True(t, changed)
+		sum := sha256.Sum256([]byte(content))
+		sums = append(sums, hex.EncodeToString(sum[:]))
+	}
+	return sums
+}
+
+func versionSums(shim *state.Shim) []string {
+	sums := make([]string, 0, len(shim.Versions))
+	for _, v := range shim.Versions {
+		sums = append(sums, hex.EncodeToString(v.Sha256))
+	}
+	return sums
+}
+
+func TestConfig_InstallKeepsVersions(t *testing.T) {
+	hostFs := afero.NewMemMapFs()
+	c := NewConfig(afero.NewMemMapFs(), hostFs, "/assets", "/opt/kwasm").WithKeepVersions(2)
+
+	sums := installVersions(t, c, "v1", "v2", "v3", "v4")
+
+	st, err := state.Get(hostFs, "/opt/kwasm")
+	require.NoError(t, err)
+	shim := st.Shims["spin-v2"]
+	assert.Equal(t, sums[3], hex.EncodeToString(shim.Sha256))
+	assert.Equal(t, []string{sums[2], sums[1]}, versionSums(shim))
+
+	for _, v := range shim.Versions {
+		exists, err := afero.Exists(hostFs, v.Path)
+		require.NoError(t, err)
+		assert.True(t, exists, "binary of kept version %s", v.Path)
+	}
+	exists, err := afero.DirExists(hostFs, "/opt/kwasm/bin/spin-v2/"+sums[0])
+	require.NoError(t, err)
+	assert.False(t, exists, "pruned version is removed")
+
+	// Reinstalling a kept version makes it the current one again.
+	installVersions(t, c, "v2")
+	st, err = state.Get(hostFs, "/opt/kwasm")
+	require.NoError(t, err)
+	assert.Equal(t, sums[1], hex.EncodeToString(st.Shims["spin-v2"].Sha256))
+	assert.Equal(t, []string{sums[3], sums[2]}, versionSums(st.Shims["spin-v2"]))
+
+	// Uninstalling removes all versions.
+	_, err = c.Uninstall("spin-v2")
+	require.NoError(t, err)
+	exists, err = afero.DirExists(hostFs, "/opt/kwasm/bin/spin-v2")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestConfig_Activate(t *testing.T) {
+	hostFs := afero.NewMemMapFs()
+	c := NewConfig(afero.NewMemMapFs(), hostFs, "/assets", "/opt/kwasm")
+
+	_, _, err := c.Activate("spin-v2", "")
+	require.Error(t, err, "shim not installed")
+
+	sums := installVersions(t, c, "v1")
+	_, _, err = c.Activate("spin-v2", "")
+	require.ErrorIs(t, err, ErrNoPreviousVersion)
+
+	sums = append(sums, installVersions(t, c, "v2", "v3")...)
+
+	tests := []struct {
+		name         string
+		prefix       string
+		wantSum      string
+		wantVersions []string
+		wantChanged  bool
+		wantErr      error
+	}{
+		{"previous version", "", sums[1], []string{sums[2], sums[0]}, true, nil},
+		{"current version", sums[1][:8], sums[1], []string{sums[2], sums[0]}, false, nil},
+		{"version by prefix", sums[0][:8], sums[0], []string{sums[1], sums[2]}, true, nil},
+		{"unknown version", "ffffffff", sums[0], []string{sums[1], sums[2]}, false, ErrVersionNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath, changed, err := c.Activate("spin-v2", tt.prefix)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "/opt/kwasm/bin/spin-v2/"+tt.wantSum+"/containerd-shim-spin-v2", filePath)
+				assert.Equal(t, tt.wantChanged, changed)
+			}
+
+			st, err := state.Get(hostFs, "/opt/kwasm")
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantSum, hex.EncodeToString(st.Shims["spin-v2"].Sha256))
+			assert.Equal(t, tt.wantVersions, versionSums(st.Shims["spin-v2"]))
+		})
+	}
+}
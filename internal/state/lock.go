@@ -0,0 +1,71 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// hostLockFile is the file below the kwasm path all node-installer processes
+// on a node lock before changing shims, the lock file or the runtime config.
+const hostLockFile = "kwasm.flock"
+
+var (
+	ErrLockTimeout = errors.New("timed out waiting for the host lock")
+
+	// lockPollInterval is the interval in which a held lock is retried.
+	lockPollInterval = 100 * time.Millisecond
+)
+
+// fder is implemented by files backed by the OS.
+type fder interface {
+	Fd() uintptr
+}
+
+// Lock takes an exclusive lock on the kwasm path of the host, waiting up to
+// timeout for other holders to release it. The returned function releases
+// the lock. Files that are not backed by the OS, like the in-memory copies
+// used for dry runs, cannot be shared with other processes and are not
+// locked.
+func Lock(fs afero.Fs, kwasmPath string, timeout time.Duration) (func() error, error) {
+	if err := fs.MkdirAll(kwasmPath, 0o775); err != nil { //nolint:mnd // file permissions
+		return nil, err
+	}
+	lockPath := filepath.Join(kwasmPath, hostLockFile)
+	f, err := fs.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o644) //nolint:mnd // file permissions
+	if err != nil {
+		return nil, fmt.Errorf("failed to open host lock: %w", err)
+	}
+
+	var osFile afero.File = f
+	if bp, ok := f.(*afero.BasePathFile); ok {
+		osFile = bp.File
+	}
+	fd, ok := osFile.(fder)
+	if !ok {
+		return f.Close, nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLock(fd.Fd())
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to take host lock: %w", err)
+		}
+		if locked {
+			return f.Close, nil
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("%w %s after %s", ErrLockTimeout, lockPath, timeout)
+		}
+		slog.Debug("waiting for host lock", "path", lockPath)
+		time.Sleep(lockPollInterval)
+	}
+}
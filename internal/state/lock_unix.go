@@ -0,0 +1,19 @@
+//go:build unix
+// +build unix
+
+package state
+
+import (
+	"errors"
+	"syscall"
+)
+
+// tryLock takes an exclusive flock on fd without blocking. The lock is
+// released when the file is closed.
+func tryLock(fd uintptr) (bool, error) {
+	err := syscall.Flock(int(fd), syscall.LOCK_EX|syscall.LOCK_NB) //nolint:gosec // file descriptors fit into an int
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,41 @@
+//go:build unix
+// +build unix
+
+package state_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spinkube/runtime-class-manager/internal/state"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	hostFs := afero.NewBasePathFs(afero.NewOsFs(), t.TempDir())
+
+	unlock, err := state.Lock(hostFs, "/opt/kwasm", time.Second)
+	require.NoError(t, err)
+
+	_, err = state.Lock(hostFs, "/opt/kwasm", 200*time.Millisecond)
+	require.ErrorIs(t, err, state.ErrLockTimeout)
+
+	require.NoError(t, unlock())
+
+	unlock, err = state.Lock(hostFs, "/opt/kwasm", time.Second)
+	require.NoError(t, err)
+	require.NoError(t, unlock())
+}
+
+func TestLock_InMemory(t *testing.T) {
+	memFs := afero.NewMemMapFs()
+
+	unlock1, err := state.Lock(memFs, "/opt/kwasm", 0)
+	require.NoError(t, err)
+	unlock2, err := state.Lock(memFs, "/opt/kwasm", 0)
+	require.NoError(t, err)
+
+	require.NoError(t, unlock1())
+	require.NoError(t, unlock2())
+}
@@ -3,36 +3,147 @@ package state
 import (
 	"encoding/hex"
 	"encoding/json"
+	"time"
 )
 
 type Shim struct {
 	Sha256 []byte
 	Path   string
+	// Source describes where the shim has been installed from.
+	Source Source
+	// InstalledAt is the time the binary has been installed.
+	InstalledAt time.Time
+	// Handler is the name of the runtime handler in the containerd config.
+	Handler string
+	// ConfigPath is the path of the containerd config the shim is
+	// configured in.
+	ConfigPath string
+	// Versions are the previously installed versions of the shim, most
+	// recent first. Their binaries are kept on disk for rollbacks.
+	Versions []Version
+	// Files are the auxiliary files installed with the shim from a bundle.
+	// They are removed when the shim is uninstalled.
+	Files []File
+}
+
+// File is an auxiliary file installed with a shim.
+type File struct {
+	Path   string
+	Sha256 []byte
+}
+
+// Version is a previously installed version of a shim.
+type Version struct {
+	Sha256      []byte
+	Path        string
+	Source      Source
+	InstalledAt time.Time
+}
+
+// Source describes where a shim has been installed from and for which Shim
+// resource. All fields are empty for shims installed without the controller.
+type Source struct {
+	URL            string `json:"url,omitempty"`
+	FetchStrategy  string `json:"fetchStrategy,omitempty"`
+	ShimUID        string `json:"shimUID,omitempty"`
+	ShimGeneration int64  `json:"shimGeneration,omitempty"`
+}
+
+// versionJSON is the representation of a binary in the lock file.
+type versionJSON struct {
+	Sha256      string  `json:"sha256"`
+	Path        string  `json:"path"`
+	Source      *Source `json:"source,omitempty"`
+	InstalledAt string  `json:"installedAt,omitempty"`
+}
+
+// shimJSON is the representation of a shim in the lock file.
+type shimJSON struct {
+	versionJSON
+	Handler    string        `json:"handler,omitempty"`
+	ConfigPath string        `json:"configPath,omitempty"`
+	Versions   []versionJSON `json:"versions,omitempty"`
+	Files      []versionJSON `json:"files,omitempty"`
 }
 
 func (s *Shim) MarshalJSON() ([]byte, error) {
-	return json.Marshal(&struct {
-		Sha256 string `json:"sha256"`
-		Path   string `json:"path"`
-	}{
-		Sha256: hex.EncodeToString(s.Sha256),
-		Path:   s.Path,
-	})
+	aux := shimJSON{
+		versionJSON: marshalVersion(Version{Sha256: s.Sha256, Path: s.Path, Source: s.Source, InstalledAt: s.InstalledAt}),
+		Handler:     s.Handler,
+		ConfigPath:  s.ConfigPath,
+	}
+	for _, v := range s.Versions {
+		aux.Versions = append(aux.Versions, marshalVersion(v))
+	}
+	for _, f := range s.Files {
+		aux.Files = append(aux.Files, marshalVersion(Version{Sha256: f.Sha256, Path: f.Path}))
+	}
+	return json.Marshal(&aux)
 }
 
 func (s *Shim) UnmarshalJSON(data []byte) error {
-	var aux struct {
-		Sha256 string `json:"sha256"`
-		Path   string `json:"path"`
-	}
+	var aux shimJSON
 	if err := json.Unmarshal(data, &aux); err != nil {
 		return err
 	}
 	s.Path = aux.Path
-	sha256, err := hex.DecodeString(aux.Sha256)
+	current, err := unmarshalVersion(aux.versionJSON)
 	if err != nil {
 		return err
 	}
-	s.Sha256 = sha256
+	s.Sha256 = current.Sha256
+	s.Source = current.Source
+	s.InstalledAt = current.InstalledAt
+	s.Handler = aux.Handler
+	s.ConfigPath = aux.ConfigPath
+	s.Versions = nil
+	for _, v := range aux.Versions {
+		version, err := unmarshalVersion(v)
+		if err != nil {
+			return err
+		}
+		s.Versions = append(s.Versions, version)
+	}
+	s.Files = nil
+	for _, f := range aux.Files {
+		file, err := unmarshalVersion(f)
+		if err != nil {
+			return err
+		}
+		s.Files = append(s.Files, File{Path: file.Path, Sha256: file.Sha256})
+	}
 	return nil
 }
+
+func marshalVersion(v Version) versionJSON {
+	aux := versionJSON{
+		Sha256: hex.EncodeToString(v.Sha256),
+		Path:   v.Path,
+	}
+	if v.Source != (Source{}) {
+		aux.Source = &v.Source
+	}
+	if !v.InstalledAt.IsZero() {
+		aux.InstalledAt = v.InstalledAt.UTC().Format(time.RFC3339)
+	}
+	return aux
+}
+
+func unmarshalVersion(aux versionJSON) (Version, error) {
+	var v Version
+	v.Path = aux.Path
+	sha256, err := hex.DecodeString(aux.Sha256)
+	if err != nil {
+		return v, err
+	}
+	v.Sha256 = sha256
+	if aux.Source != nil {
+		v.Source = *aux.Source
+	}
+	if aux.InstalledAt != "" {
+		if v.InstalledAt, err = time.Parse(time.RFC3339, aux.InstalledAt); err != nil {
+			return v, err
+		}
+	}
+	return v, nil
+}
@@ -4,14 +4,25 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/afero"
 )
 
+// SchemaVersion is the version of the lock file format written by Write.
+// Version 1 lock files had no version field and recorded only the sha256
+// and path of every shim. Version 3 added the previous versions of shims,
+// version 4 the auxiliary files of shim bundles.
+const SchemaVersion = 4
+
+var ErrUnsupportedVersion = errors.New("unsupported lock file version")
+
 type State struct {
+	Version      int              `json:"version"`
 	Shims        map[string]*Shim `json:"shims"`
 	fs           afero.Fs
 	lockFilePath string
@@ -24,17 +35,47 @@ func Get(fs afero.Fs, kwasmPath string) (*State, error) {
 		fs:           fs,
 	}
 	content, err := afero.ReadFile(fs, out.lockFilePath)
-	if err == nil {
-		err := json.Unmarshal(content, &out)
-		return &out, err
+	if errors.Is(err, os.ErrNotExist) {
+		out.Version = SchemaVersion
+		return &out, nil
 	}
-	if !errors.Is(err, os.ErrNotExist) {
+	if err != nil {
 		return nil, err
 	}
 
+	if err := json.Unmarshal(content, &out); err != nil {
+		return &out, err
+	}
+	if err := out.migrate(); err != nil {
+		return nil, err
+	}
 	return &out, nil
 }
 
+// migrate upgrades a lock file read from disk to SchemaVersion. Lock files
+// written by newer versions of node-installer are rejected, as they may
+// carry information that would be lost on the next write.
+func (l *State) migrate() error {
+	if l.Version > SchemaVersion {
+		return fmt.Errorf("%w %d, node-installer supports up to %d", ErrUnsupportedVersion, l.Version, SchemaVersion)
+	}
+
+	if l.Version < 2 { //nolint:mnd // schema version
+		// The handler of version 1 shims is derived from the binary name,
+		// like node-installer does when configuring them.
+		for _, shim := range l.Shims {
+			shim.Handler = strings.TrimPrefix(filepath.Base(shim.Path), "containerd-shim-")
+		}
+		l.Version = 2
+	}
+
+	// Versions 3 and 4 only added the previous versions of shims and the
+	// files of bundles, which older lock files do not have.
+	l.Version = SchemaVersion
+
+	return nil
+}
+
 func (l *State) ShimChanged(shimName string, sha256 []byte, path string) bool {
 	shim, ok := l.Shims[shimName]
 	if !ok {
@@ -53,6 +94,7 @@ func (l *State) RemoveShim(shimName string) {
 }
 
 func (l *State) Write() error {
+	l.Version = SchemaVersion
 	out, err := json.MarshalIndent(l, "", " ")
 	if err != nil {
 		return err
@@ -60,5 +102,15 @@ func (l *State) Write() error {
 
 	slog.Debug("writing lock file", "content", string(out))
 
-	return afero.WriteFile(l.fs, l.lockFilePath, out, 0644) //nolint:mnd // file permissions
+	// Write to a temporary file and rename it, so that the lock file is
+	// never left half written.
+	tmpPath := l.lockFilePath + ".tmp"
+	if err := afero.WriteFile(l.fs, tmpPath, out, 0644); err != nil { //nolint:mnd // file permissions
+		return err
+	}
+	if err := l.fs.Rename(tmpPath, l.lockFilePath); err != nil {
+		_ = l.fs.Remove(tmpPath)
+		return err
+	}
+	return nil
 }
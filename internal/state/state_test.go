@@ -2,6 +2,7 @@ package state_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 	"github.com/spinkube/runtime-class-manager/internal/state"
@@ -30,8 +31,9 @@ func TestGet(t *testing.T) {
 			&state.State{
 				Shims: map[string]*state.Shim{
 					"spin-v1": {
-						Sha256: []byte{109, 165, 232, 241, 122, 155, 250, 156, 176, 76, 242, 44, 135, 182, 71, 83, 148, 236, 236, 58, 244, 253, 195, 55, 247, 45, 109, 191, 51, 25, 234, 82},
-						Path:   "/opt/kwasm/bin/containerd-shim-spin-v1",
+						Sha256:  []byte{109, 165, 232, 241, 122, 155, 250, 156, 176, 76, 242, 44, 135, 182, 71, 83, 148, 236, 236, 58, 244, 253, 195, 55, 247, 45, 109, 191, 51, 25, 234, 82},
+						Path:    "/opt/kwasm/bin/containerd-shim-spin-v1",
+						Handler: "spin-v1",
 					},
 				},
 			},
@@ -58,6 +60,79 @@ func TestGet(t *testing.T) {
 				require.NoError(t, err)
 			}
 			assert.Equal(t, tt.want.Shims, got.Shims)
+			assert.Equal(t, state.SchemaVersion, got.Version)
+		})
+	}
+}
+
+func TestGet_Versions(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    *state.Shim
+		wantErr error
+	}{
+		{
+			"version 1 is migrated",
+			`{"shims":{"spin-v1":{"sha256":"0102","path":"/opt/kwasm/bin/containerd-shim-spin-v1"}}}`,
+			&state.Shim{Sha256: []byte{1, 2}, Path: "/opt/kwasm/bin/containerd-shim-spin-v1", Handler: "spin-v1"},
+			nil,
+		},
+		{
+			"version 2",
+			`{"version":2,"shims":{"spin":{"sha256":"0102","path":"/opt/kwasm/bin/containerd-shim-spin","handler":"spin",` +
+				`"configPath":"/etc/containerd/config.toml","installedAt":"2024-05-02T10:00:00Z",` +
+				`"source":{"url":"https://example.com/spin.tar.gz","fetchStrategy":"anonHttp","shimUID":"1234","shimGeneration":3}}}}`,
+			&state.Shim{
+				Sha256:      []byte{1, 2},
+				Path:        "/opt/kwasm/bin/containerd-shim-spin",
+				Handler:     "spin",
+				ConfigPath:  "/etc/containerd/config.toml",
+				InstalledAt: time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC),
+				Source:      state.Source{URL: "https://example.com/spin.tar.gz", FetchStrategy: "anonHttp", ShimUID: "1234", ShimGeneration: 3},
+			},
+			nil,
+		},
+		{
+			"version 4 with files",
+			`{"version":4,"shims":{"wws":{"sha256":"0102","path":"/opt/kwasm/bin/containerd-shim-wws","handler":"wws",` +
+				`"files":[{"sha256":"0304","path":"/opt/kwasm/lib/wws/runtime.toml"}]}}}`,
+			&state.Shim{
+				Sha256:  []byte{1, 2},
+				Path:    "/opt/kwasm/bin/containerd-shim-wws",
+				Handler: "wws",
+				Files:   []state.File{{Path: "/opt/kwasm/lib/wws/runtime.toml", Sha256: []byte{3, 4}}},
+			},
+			nil,
+		},
+		{
+			"newer version is rejected",
+			`{"version":5,"shims":{}}`,
+			nil,
+			state.ErrUnsupportedVersion,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			require.NoError(t, afero.WriteFile(fs, "/opt/kwasm/kwasm-lock.json", []byte(tt.content), 0o644))
+
+			got, err := state.Get(fs, "/opt/kwasm")
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, state.SchemaVersion, got.Version)
+			for _, shim := range got.Shims {
+				assert.Equal(t, tt.want, shim)
+			}
+
+			// The lock file is written in the current version and reads back the same.
+			require.NoError(t, got.Write())
+			reread, err := state.Get(fs, "/opt/kwasm")
+			require.NoError(t, err)
+			assert.Equal(t, got.Shims, reread.Shims)
 		})
 	}
 }
@@ -127,3 +202,20 @@ func TestShimChanged(t *testing.T) {
 		})
 	}
 }
+
+func TestWrite(t *testing.T) {
+	fs := tests.FixtureFs("../../testdata/node-installer/containerd/existing-containerd-shim-config")
+	st, err := state.Get(fs, "/opt/kwasm")
+	require.NoError(t, err)
+
+	st.UpdateShim("slight-v1", state.Shim{Sha256: []byte{1, 2, 3}, Path: "/opt/kwasm/bin/containerd-shim-slight-v1"})
+	require.NoError(t, st.Write())
+
+	exists, err := afero.Exists(fs, "/opt/kwasm/kwasm-lock.json.tmp")
+	require.NoError(t, err)
+	assert.False(t, exists, "temporary lock file must be renamed")
+
+	got, err := state.Get(fs, "/opt/kwasm")
+	require.NoError(t, err)
+	assert.Equal(t, st.Shims, got.Shims)
+}
@@ -0,0 +1,150 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package termination defines the message node-installer leaves in the
+// termination log of its container for the controller to pick up.
+package termination
+
+import (
+	"encoding/json"
+	"os"
+)
+
+const (
+	// DefaultPath is the default termination message path of Kubernetes containers.
+	DefaultPath = "/dev/termination-log"
+	// MaxLength is the maximum length of a termination message. Longer
+	// messages are truncated by the kubelet.
+	MaxLength = 4096
+	// truncatedSuffix marks a shortened config diff or failure message.
+	truncatedSuffix = "\n... (truncated)\n"
+)
+
+// Classes of failures of a node-installer run.
+const (
+	// FailureInvalidConfig is reported for invalid flags or settings.
+	FailureInvalidConfig = "InvalidConfig"
+	// FailureDistroDetection is reported if the containerd config could not
+	// be found.
+	FailureDistroDetection = "DistroDetection"
+	// FailureDistroSetup is reported if the distro specific setup failed.
+	FailureDistroSetup = "DistroSetup"
+	// FailureLockTimeout is reported if another installation on the node
+	// did not finish in time.
+	FailureLockTimeout = "LockTimeout"
+	// FailureRuntimeRestart is reported if the runtime could not be
+	// restarted or did not become healthy after a restart.
+	FailureRuntimeRestart = "RuntimeRestart"
+	// FailureInstall, FailureUninstall and FailureVerify are reported for
+	// all other failures of the operation.
+	FailureInstall   = "Install"
+	FailureUninstall = "Uninstall"
+	FailureVerify    = "Verify"
+	// FailureUnknown is used by the controller for runs that failed without
+	// reporting a failure, e.g. because the container has been killed.
+	FailureUnknown = "Unknown"
+)
+
+// Message is the result of a node-installer run as reported to the controller.
+type Message struct {
+	// RestartPending is set when the runtime config was changed but the
+	// runtime has not been restarted yet.
+	RestartPending bool `json:"restartPending,omitempty"`
+	// Preflight is the result of a dry run.
+	Preflight *Preflight `json:"preflight,omitempty"`
+	// Drift lists how the node differs from the installed shim.
+	Drift []string `json:"drift,omitempty"`
+	// Restarts is the number of times the runtime has been restarted.
+	Restarts int `json:"restarts,omitempty"`
+	// AssetBytes is the size of the downloaded assets that were installed.
+	AssetBytes int64 `json:"assetBytes,omitempty"`
+	// Failure is set if the run failed.
+	Failure *Failure `json:"failure,omitempty"`
+}
+
+// Failure describes why a node-installer run failed.
+type Failure struct {
+	// Class is one of the Failure* classes.
+	Class string `json:"class"`
+	// Message is the error the run failed with.
+	Message string `json:"message"`
+	// Distro is the name of the detected distro, if it has been detected.
+	Distro string `json:"distro,omitempty"`
+	// ConfigPath is the path of the runtime config on the host.
+	ConfigPath string `json:"configPath,omitempty"`
+}
+
+// Preflight describes the changes an install or uninstall would make.
+type Preflight struct {
+	// Shims lists the changes to the installed shims.
+	Shims []ShimChange `json:"shims,omitempty"`
+	// ConfigDiff is the unified diff of the runtime config.
+	ConfigDiff string `json:"configDiff,omitempty"`
+	// Restart is set if the runtime would be restarted or marked for a
+	// restart.
+	Restart bool `json:"restart,omitempty"`
+}
+
+// ShimChange describes the change to a single shim.
+type ShimChange struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+// Write writes the message to the termination log at path. The config diff
+// of a preflight and the message of a failure are shortened so that the
+// message fits into MaxLength.
+func Write(path string, msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if overflow := len(data) - MaxLength; overflow > 0 && msg.Preflight != nil {
+		preflight := *msg.Preflight
+		preflight.ConfigDiff = truncate(preflight.ConfigDiff, overflow)
+		msg.Preflight = &preflight
+		if data, err = json.Marshal(msg); err != nil {
+			return err
+		}
+	}
+	if overflow := len(data) - MaxLength; overflow > 0 && msg.Failure != nil {
+		failure := *msg.Failure
+		failure.Message = truncate(failure.Message, overflow)
+		msg.Failure = &failure
+		if data, err = json.Marshal(msg); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, data, 0o644) //nolint:mnd,gosec // file permissions
+}
+
+// truncate shortens s by at least overflow bytes in JSON. Escaping in JSON
+// may make s longer than it is, so twice the overflow is cut off.
+func truncate(s string, overflow int) string {
+	keep := max(len(s)-2*overflow-len(truncatedSuffix), 0)
+	return s[:keep] + truncatedSuffix
+}
+
+// Parse parses a termination message. An empty message parses to an empty
+// Message.
+func Parse(data string) (Message, error) {
+	var msg Message
+	if data == "" {
+		return msg, nil
+	}
+	err := json.Unmarshal([]byte(data), &msg)
+	return msg, err
+}
@@ -0,0 +1,130 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package termination_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spinkube/runtime-class-manager/internal/termination"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteParse(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  termination.Message
+		want string
+	}{
+		{"empty", termination.Message{}, `{}`},
+		{"restart pending", termination.Message{RestartPending: true}, `{"restartPending":true}`},
+		{
+			"preflight",
+			termination.Message{Preflight: &termination.Preflight{
+				Shims:      []termination.ShimChange{{Name: "spin-v2", Action: "install"}},
+				ConfigDiff: "+runtime_type = \"/opt/kwasm/bin/containerd-shim-spin-v2\"\n",
+				Restart:    true,
+			}},
+			`{"preflight":{"shims":[{"name":"spin-v2","action":"install"}],"configDiff":"+runtime_type = \"/opt/kwasm/bin/containerd-shim-spin-v2\"\n","restart":true}}`,
+		},
+		{
+			"failure",
+			termination.Message{Failure: &termination.Failure{
+				Class:      termination.FailureRuntimeRestart,
+				Message:    "failed to restart containerd: exit status 1",
+				Distro:     "k3s",
+				ConfigPath: "/var/lib/rancher/k3s/agent/etc/containerd/config.toml",
+			}},
+			`{"failure":{"class":"RuntimeRestart","message":"failed to restart containerd: exit status 1","distro":"k3s","configPath":"/var/lib/rancher/k3s/agent/etc/containerd/config.toml"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "termination-log")
+			require.NoError(t, termination.Write(path, tt.msg))
+
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(data))
+
+			got, err := termination.Parse(string(data))
+			require.NoError(t, err)
+			assert.Equal(t, tt.msg, got)
+		})
+	}
+}
+
+func TestWriteTruncatesConfigDiff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "termination-log")
+	diff := strings.Repeat("+runtime_type = \"/opt/kwasm/bin/shim\"\n", 500)
+	msg := termination.Message{Preflight: &termination.Preflight{ConfigDiff: diff, Restart: true}}
+	require.NoError(t, termination.Write(path, msg))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(data), termination.MaxLength)
+
+	got, err := termination.Parse(string(data))
+	require.NoError(t, err)
+	assert.True(t, got.Preflight.Restart)
+	assert.True(t, strings.HasSuffix(got.Preflight.ConfigDiff, "... (truncated)\n"))
+	assert.Equal(t, diff, msg.Preflight.ConfigDiff, "message passed in must not be modified")
+}
+
+func TestWriteTruncatesFailureMessage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "termination-log")
+	message := strings.Repeat("failed to write containerd config: ", 200)
+	msg := termination.Message{Failure: &termination.Failure{Class: termination.FailureInstall, Message: message}}
+	require.NoError(t, termination.Write(path, msg))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(data), termination.MaxLength)
+
+	got, err := termination.Parse(string(data))
+	require.NoError(t, err)
+	assert.Equal(t, termination.FailureInstall, got.Failure.Class)
+	assert.True(t, strings.HasSuffix(got.Failure.Message, "... (truncated)\n"))
+	assert.Equal(t, message, msg.Failure.Message, "message passed in must not be modified")
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    termination.Message
+		wantErr bool
+	}{
+		{"empty message", "", termination.Message{}, false},
+		{"restart pending", `{"restartPending":true}`, termination.Message{RestartPending: true}, false},
+		{"unstructured message", "failed to install", termination.Message{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := termination.Parse(tt.data)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package tracing sets up OpenTelemetry tracing for the controller and
+// node-installer and carries the trace context of a rollout from the
+// controller to the jobs it runs.
+package tracing
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// TracerName is the name of the tracer of all spans.
+	TracerName = "github.com/spinkube/runtime-class-manager"
+	// Annotation is set on jobs to the trace context of the reconcile that
+	// created them.
+	Annotation = "kwasm.sh/traceparent"
+	// EnvTraceParent passes the trace context to node-installer.
+	EnvTraceParent = "TRACEPARENT"
+	// envExporterPrefix is the prefix of the environment variables that
+	// configure the OTLP exporter.
+	envExporterPrefix = "OTEL_EXPORTER_OTLP_"
+)
+
+var propagator = propagation.TraceContext{}
+
+// Enabled returns whether an OTLP endpoint has been configured.
+func Enabled() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Setup exports spans to the OTLP endpoint configured by the standard
+// OTEL_EXPORTER_OTLP_* environment variables. Without an endpoint, tracing
+// stays disabled. The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, serviceName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+	if !Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span with the global tracer provider.
+func Start(ctx context.Context, spanName string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(TracerName).Start(ctx, spanName, trace.WithAttributes(attrs...))
+}
+
+// End ends the span and marks it as failed if err is set.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TraceParent returns the W3C traceparent of the span in ctx. It is empty
+// if ctx has no span.
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// ContextWithTraceParent returns a context with the remote span described
+// by traceParent as parent of new spans. An invalid or empty traceParent
+// leaves ctx unchanged.
+func ContextWithTraceParent(ctx context.Context, traceParent string) context.Context {
+	if traceParent == "" {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier{"traceparent": traceParent})
+}
+
+// ExporterEnv returns the OTEL_EXPORTER_OTLP_* environment variables as
+// KEY=value pairs, sorted by key, to configure the exporter of jobs like
+// the one of the controller.
+func ExporterEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, envExporterPrefix) {
+			env = append(env, kv)
+		}
+	}
+	slices.Sort(env)
+	return env
+}
@@ -0,0 +1,92 @@
+/*
+   Copyright The SpinKube Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/spinkube/runtime-class-manager/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceParent(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+	assert.Empty(t, tracing.TraceParent(context.Background()), "no span, no trace context")
+
+	ctx, parent := tracing.Start(context.Background(), "reconcile")
+	traceParent := tracing.TraceParent(ctx)
+	require.NotEmpty(t, traceParent)
+
+	// The job continues the trace of the reconcile.
+	_, child := tracing.Start(tracing.ContextWithTraceParent(context.Background(), traceParent), "install")
+	tracing.End(child, errors.New("failed to install"))
+	tracing.End(parent, nil)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "install", spans[0].Name)
+	assert.Equal(t, spans[1].SpanContext.TraceID(), spans[0].SpanContext.TraceID())
+	assert.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Equal(t, codes.Unset, spans[1].Status.Code)
+}
+
+func TestContextWithTraceParent(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceParent string
+		wantValid   bool
+	}{
+		{"empty", "", false},
+		{"invalid", "not-a-traceparent", false},
+		{"valid", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tracing.ContextWithTraceParent(context.Background(), tt.traceParent)
+			assert.Equal(t, tt.wantValid, trace.SpanContextFromContext(ctx).IsRemote())
+		})
+	}
+}
+
+func TestSetupDisabled(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+
+	assert.False(t, tracing.Enabled())
+	shutdown, err := tracing.Setup(context.Background(), "test")
+	require.NoError(t, err)
+	require.NoError(t, shutdown(context.Background()))
+}
+
+func TestExporterEnv(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_INSECURE", "true")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4317")
+	t.Setenv("OTEL_SERVICE_NAME", "rcm")
+
+	assert.Equal(t, []string{"OTEL_EXPORTER_OTLP_ENDPOINT=http://collector:4317", "OTEL_EXPORTER_OTLP_INSECURE=true"}, tracing.ExporterEnv())
+}